@@ -0,0 +1,118 @@
+// Package chat lets other Go programs hold conversations with an LLM provider
+// the way the chatbot CLI does, receiving each turn as a stream of events
+// instead of printed output.
+//
+//	client := chat.New(chat.Provider{
+//		UrlBase: "https://api.openai.com",
+//		APIKey:  os.Getenv("OPENAI_API_KEY"),
+//		APIs:    map[string]string{"chat": "/v1/chat/completions"},
+//		Model:   "gpt-4o",
+//	}, chat.Settings{})
+//	conv := chat.NewConversation("You are a helpful assistant.", 32000)
+//	events, err := client.Chat(ctx, conv, "Hello!")
+//	if err != nil {
+//		return err
+//	}
+//	for event := range events {
+//		switch event := event.(type) {
+//		case chat.ContentEvent:
+//			fmt.Print(event.Text)
+//		case chat.ErrorEvent:
+//			return event.Err
+//		}
+//	}
+package chat
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/henryhwang/chatbot/internal/api"
+	"github.com/henryhwang/chatbot/internal/config"
+	"github.com/henryhwang/chatbot/internal/conversation"
+	"github.com/henryhwang/chatbot/internal/types"
+)
+
+// Types shared with the CLI. See the fields of each for what they configure.
+type (
+	Provider     = types.ModelProvider       // Endpoint, credentials, model and API format
+	Settings     = types.Settings            // Optional behaviour; the zero value works
+	Message      = types.Message             // A stored conversation message
+	Usage        = types.UsageInfo           // Token usage reported by the provider
+	Conversation = conversation.Conversation // History and context selection
+	Result       = api.StreamResult          // Everything parsed from a turn
+	Hook         = types.Hook                // Custom logic around each request; see Client.Use
+	HookFuncs    = types.HookFuncs           // A Hook made of functions
+)
+
+// Events of a turn. A turn ends with exactly one DoneEvent or ErrorEvent,
+// after which the channel is closed.
+type (
+	StreamEvent    = api.StreamEvent    // Any of the events below
+	StartEvent     = api.StartEvent     // A request was sent; once per round
+	ReasoningEvent = api.ReasoningEvent // Text: a chunk of the model's reasoning
+	ContentEvent   = api.ContentEvent   // Text: a chunk of the answer
+	ToolCallEvent  = api.ToolCallEvent  // A tool the model called has run; another round follows
+	NoticeEvent    = api.NoticeEvent    // Text: e.g. that old messages were left out of the context
+	WarningEvent   = api.WarningEvent   // Text: a non-fatal problem, e.g. an unreadable @file reference
+	DoneEvent      = api.DoneEvent      // The turn succeeded; embeds the Result, including Usage
+	ErrorEvent     = api.ErrorEvent     // The turn failed with Err; Partial holds what arrived
+)
+
+// NewConversation returns a conversation with an optional system prompt that
+// sends as much recent history as fits in maxTokens (estimated).
+func NewConversation(systemPrompt string, maxTokens int) *Conversation {
+	return conversation.NewConversation(systemPrompt, &conversation.SimpleTruncationStrategy{}, maxTokens)
+}
+
+// SettingsFromEnv returns the settings the CLI would use, read from the
+// environment variables it documents (e.g. REQUEST_TIMEOUT, TEMPERATURE).
+func SettingsFromEnv() Settings {
+	return config.LoadSettings()
+}
+
+// Client sends conversation turns to one provider.
+type Client struct {
+	provider Provider
+	settings Settings
+}
+
+// New returns a client for provider. An empty provider.Format means "openai";
+// for "azure", an empty Deployment means the Model.
+func New(provider Provider, settings Settings) *Client {
+	if provider.Format == "" {
+		provider.Format = "openai"
+	}
+	if provider.Format == "azure" && provider.Deployment == "" {
+		provider.Deployment = provider.Model
+	}
+	return &Client{provider: provider, settings: settings}
+}
+
+// Use adds hooks that run around every request the client sends, after any
+// already added: BeforeRequest may change the messages (e.g. to redact them)
+// and AfterResponse observes the reply or error (e.g. for logging or
+// metrics). Add hooks before starting a turn, not during one.
+func (c *Client) Use(hooks ...Hook) {
+	c.settings.Hooks = append(slices.Clip(c.settings.Hooks), hooks...)
+}
+
+// Chat sends input as the next user message of conv and returns the turn's
+// events. The conversation is updated with the reply when the turn succeeds
+// and must not be used by anything else until the DoneEvent or ErrorEvent
+// ending the turn has been received. Cancelling ctx aborts the request,
+// including a stream in progress.
+func (c *Client) Chat(ctx context.Context, conv *Conversation, input string) (<-chan StreamEvent, error) {
+	if conv == nil {
+		return nil, errors.New("chat: conversation is nil")
+	}
+	if strings.TrimSpace(input) == "" {
+		return nil, errors.New("chat: input is empty")
+	}
+	if _, ok := c.provider.APIs["chat"]; !ok && c.provider.Format != "azure" {
+		return nil, errors.New("chat: provider has no 'chat' endpoint in APIs")
+	}
+	return api.Chat(ctx, conv, input, c.provider, c.settings), nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/chzyer/readline"
+)
+
+// --- Input Handling ---
+
+// historyFileName is the file in the user's home directory that persists input history.
+const historyFileName = ".chatbot_history"
+
+// lineReader reads one line of user input at a time. ReadLine returns io.EOF
+// (possibly together with a final partial line) when input ends.
+type lineReader interface {
+	ReadLine(prompt string) (string, error)
+	AddHistory(line string) // Record a submitted line for recall (no-op without history)
+	Close() error
+}
+
+// newLineReader returns a readline-backed reader with line editing and
+// persistent history when stdin is a terminal, falling back to plain buffered
+// input otherwise (or if readline can't be initialised).
+func newLineReader() lineReader {
+	if stdinIsTerminal() {
+		if reader, err := newReadlineReader(); err == nil {
+			return reader
+		}
+	}
+	return &bufioLineReader{reader: bufio.NewReader(os.Stdin)}
+}
+
+// Two Ctrl-C presses at the prompt within this interval quit the chatbot
+const doubleInterruptWindow = time.Second
+
+// readlineReader provides cursor movement, Ctrl-A/Ctrl-E and up-arrow recall.
+type readlineReader struct {
+	rl            *readline.Instance
+	lastInterrupt time.Time
+}
+
+func newReadlineReader() (*readlineReader, error) {
+	historyFile := ""
+	if home, err := os.UserHomeDir(); err == nil {
+		historyFile = filepath.Join(home, historyFileName)
+	}
+	rl, err := readline.NewEx(&readline.Config{
+		HistoryFile: historyFile,
+		// History is saved explicitly so confirmation answers aren't recorded
+		DisableAutoSaveHistory: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &readlineReader{rl: rl}, nil
+}
+
+func (r *readlineReader) ReadLine(prompt string) (string, error) {
+	r.rl.SetPrompt(prompt)
+	line, err := r.rl.Readline()
+	if errors.Is(err, readline.ErrInterrupt) {
+		// Ctrl-C at the prompt discards the current line; a quick second press quits
+		if time.Since(r.lastInterrupt) < doubleInterruptWindow {
+			return "", io.EOF
+		}
+		r.lastInterrupt = time.Now()
+		if line == "" {
+			fmt.Println("(Press Ctrl-C again to quit)")
+		}
+		return "", nil
+	}
+	return line, err
+}
+
+func (r *readlineReader) AddHistory(line string) {
+	_ = r.rl.SaveHistory(line) // Best effort: history is a convenience
+}
+
+// Close restores the terminal and closes the history file. readline's Close
+// waits for a pending terminal read, which can't be interrupted when a signal
+// handler shuts down at the prompt, so that wait is bounded.
+func (r *readlineReader) Close() error {
+	err := r.rl.Terminal.ExitRawMode()
+	closed := make(chan struct{})
+	go func() {
+		r.rl.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(closeTimeout):
+	}
+	return err
+}
+
+// How long Close waits for readline to finish a pending read
+const closeTimeout = 200 * time.Millisecond
+
+// bufioLineReader reads plain lines, used when stdin isn't a terminal.
+type bufioLineReader struct {
+	reader *bufio.Reader
+}
+
+func (b *bufioLineReader) ReadLine(prompt string) (string, error) {
+	fmt.Print(prompt)
+	return b.reader.ReadString('\n') // A final line without a newline comes with io.EOF
+}
+
+func (b *bufioLineReader) AddHistory(string) {}
+
+func (b *bufioLineReader) Close() error { return nil }
+
+// --- Multiline Input ---
+
+const (
+	continuationPrompt = "... "
+	multilineEnd       = "." // A line containing only this ends multiline input
+)
+
+// readInput reads one submission. A line ending in a backslash continues onto
+// the following lines until a "." or empty line; in multiline mode (toggled by
+// /multiline) every submission is accumulated until a "." line, so pasted
+// text with blank lines stays together. The lines are joined with newlines.
+func readInput(reader lineReader, prompt string, multiline bool) (string, error) {
+	line, err := reader.ReadLine(prompt)
+	line = strings.TrimRight(line, "\r\n")
+
+	continued := strings.HasSuffix(line, `\`)
+	if err != nil || (!multiline && !continued) {
+		return line, err
+	}
+	// Slash commands are never accumulated, so /multiline can always toggle back
+	if multiline && !continued && strings.HasPrefix(strings.TrimSpace(line), "/") {
+		return line, nil
+	}
+
+	lines := []string{strings.TrimSuffix(line, `\`)}
+	for {
+		line, err = reader.ReadLine(continuationPrompt)
+		line = strings.TrimRight(line, "\r\n")
+		if err == nil && strings.TrimSpace(line) == multilineEnd {
+			break
+		}
+		if err == nil && !multiline && strings.TrimSpace(line) == "" {
+			break
+		}
+		if line != "" || err == nil {
+			lines = append(lines, strings.TrimSuffix(line, `\`))
+		}
+		if err != nil {
+			break // EOF mid-input: submit what was accumulated
+		}
+	}
+	return strings.Join(lines, "\n"), err
+}
@@ -1,58 +1,323 @@
 package main
 
 import (
-	"bufio"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"syscall"
 
 	"github.com/henryhwang/chatbot/internal/api"
 	"github.com/henryhwang/chatbot/internal/commands"
 	"github.com/henryhwang/chatbot/internal/config"
 	"github.com/henryhwang/chatbot/internal/conversation" // Import conversation package
-	// types package might still be needed if commands use it, keep for now
-	// "github.com/henryhwang/chatbot/internal/types"
+	"github.com/henryhwang/chatbot/internal/models"
+	"github.com/henryhwang/chatbot/internal/prompts"
+	"github.com/henryhwang/chatbot/internal/tools"
+	"github.com/henryhwang/chatbot/internal/types"
+	"github.com/henryhwang/chatbot/internal/version"
 )
 
 // --- Main Application Logic ---
 
 func main() {
+	// Command-line flags for scripting; each has a short and a long form
+	var prompt string
+	var quiet, jsonOutput, markdown, debug, showVersion bool
+	flag.StringVar(&prompt, "p", "", "Send a single prompt, print the answer and exit")
+	flag.StringVar(&prompt, "prompt", "", "Same as -p")
+	flag.BoolVar(&quiet, "q", false, "Quiet: no \"Bot:\" prefix or reasoning output")
+	flag.BoolVar(&quiet, "quiet", false, "Same as -q")
+	flag.BoolVar(&markdown, "markdown", false, "Highlight fenced code blocks in responses (also RENDER_MARKDOWN=true)")
+	flag.BoolVar(&debug, "debug", false, "Log request bodies and raw response lines to stderr (also DEBUG=true)")
+	flag.BoolVar(&jsonOutput, "json", false, "Emit one JSON object per turn (content, reasoning, model, usage, error)")
+	flag.BoolVar(&showVersion, "version", false, "Print version and build information and exit")
+	flag.Parse()
+
+	if showVersion {
+		fmt.Println(version.Get())
+		return
+	}
+
 	provider, err := config.Load() // Load configuration
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
+	promptTemplate, err := config.LoadSystemPrompt()
+	if err != nil {
+		log.Fatalf("Failed to load system prompt: %v", err)
+	}
+	promptVars := config.LoadPromptVars()
+	systemPrompt, err := prompts.Render(promptTemplate, promptVars)
+	if err != nil {
+		log.Fatalf("Failed to render system prompt: %v", err)
+	}
+	settings := config.LoadSettings()
+	settings.Quiet = quiet
+	settings.JSONOutput = jsonOutput
+	settings.RenderMarkdown = settings.RenderMarkdown || markdown
+	settings.Debug = settings.Debug || debug
+	if settings.Debug {
+		api.SetDebugLogger(log.New(os.Stderr, "DEBUG ", log.LstdFlags|log.Lmicroseconds))
+	}
+	api.SetMaxStreamLine(settings.StreamMaxLineBytes)
+	api.SetMaxPayloadJoins(settings.StreamPayloadJoins)
+	api.SetUserAgent(settings.UserAgent)
+	if settings.EnableTools {
+		registry := tools.NewRegistry()
+		if err := registry.Register(tools.CurrentTime()); err != nil {
+			log.Fatalf("Failed to register tools: %v", err)
+		}
+		api.SetToolRegistry(registry)
+	}
+	state := &types.RuntimeState{
+		ProviderName: config.DefaultProviderName(provider),
+		Provider:     provider,
+		Providers:    config.LoadProviders(provider),
+		Settings:     settings,
+
+		SystemPromptTemplate: promptTemplate,
+		PromptVars:           promptVars,
+	}
+	if err := commands.LoadAliases(state); err != nil {
+		log.Printf("Warning: Could not load aliases: %v", err)
+	}
+
+	// Initialize conversation manager
+	newConversation := conversationFactory(state, systemPrompt)
+	conv, err := newConversation()
+	if err != nil {
+		log.Fatalf("Failed to configure context strategy: %v", err)
+	}
+
+	// Server mode: "chatbot serve" answers HTTP clients instead of the terminal
+	if flag.Arg(0) == "serve" {
+		runServe(flag.Args()[1:], state, func() *conversation.Conversation {
+			conv, _ := newConversation() // The configuration was validated above
+			return conv
+		})
+		return
+	}
+
+	// Piped (non-interactive) stdin is sent as the prompt, appended to any -p text
+	if !stdinIsTerminal() {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			log.Fatalf("Failed to read stdin: %v", err)
+		}
+		if text := strings.TrimSpace(string(data)); text != "" {
+			if prompt != "" {
+				prompt += "\n\n" + text
+			} else {
+				prompt = text
+			}
+		}
+		if prompt == "" {
+			log.Fatal("No prompt provided: stdin was empty and -p was not given.")
+		}
+	}
+
+	// One-shot mode: answer the prompt and exit without entering the REPL
+	if prompt != "" {
+		if err := api.QueryHandler(context.Background(), conv, prompt, state.Provider, settings, api.NewRenderer(state.Provider, settings)); err != nil {
+			if settings.JSONOutput {
+				os.Exit(1) // The error was already emitted as JSON
+			}
+			log.Fatalf("API Query Error: %v", err)
+		}
+		return
+	}
 
 	fmt.Println("Welcome to the Chatbot! Type '/exit' to quit.")
 	fmt.Println("Using Model:", provider.Model)
 	fmt.Println("--------------------------------------------")
 
-	reader := bufio.NewReader(os.Stdin)
-	// Initialize conversation manager
-	// Can pass initial system messages here if desired
-	truncationStrategy := &conversation.SimpleTruncationStrategy{}
-	maxTokens := 32000
-	conv := conversation.NewConversation("you are great as golang developer", truncationStrategy, maxTokens)
+	// Resume the previous conversation, and save it however the session ends
+	if settings.PersistSession {
+		loadSession(conv, settings.SessionFile)
+		commands.OnExit(func() { saveSession(conv, settings.SessionFile) })
+	}
+
+	reader := newLineReader()
+	commands.OnExit(func() { reader.Close() }) // Restores the terminal and closes the history file
+	commands.SetLineReader(reader.ReadLine)    // Commands share the reader for confirmations
+
+	var atPrompt atomic.Bool
+	go shutdownOnSignal(&atPrompt)
+
+	runLoop(reader, conv, state, &atPrompt)
+
+	// Input ended (Ctrl-D or piped input exhausted): exit cleanly, like /exit
+	fmt.Println()
+	commands.Shutdown(os.Stdout, 0)
+}
+
+// conversationFactory returns a function creating conversations for the
+// active provider. Each conversation gets its own strategy, since some
+// (summarize) keep state about the history, and a context budget sized for
+// the model in use when it is created, not the one at startup.
+func conversationFactory(state *types.RuntimeState, systemPrompt string) func() (*conversation.Conversation, error) {
+	return func() (*conversation.Conversation, error) {
+		settings := state.Settings
+		truncationStrategy, err := conversation.NewStrategy(settings.TruncationStrategy, settings.ContextTurns, !settings.SystemPromptInBudget, api.NewSummarizer(state))
+		if err != nil {
+			return nil, err
+		}
+		// Size the context budget from the model's known window, reserving completion headroom
+		maxTokens := models.ContextBudget(state.Provider.Model, settings.DefaultMaxTokens)
+		conv := conversation.NewConversation(systemPrompt, truncationStrategy, maxTokens)
+		conv.SetSystemReminder(settings.SystemReminderInterval, settings.SystemReminderText)
+		conv.SetMaxHistoryMessages(settings.MaxHistoryMessages)
+		return conv, nil
+	}
+}
+
+// shutdownOnSignal exits through commands.Shutdown, like /exit, on SIGTERM
+// or on SIGINT while waiting at the prompt. A SIGINT at any other time is
+// left to cancel the request in flight.
+func shutdownOnSignal(atPrompt *atomic.Bool) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	for sig := range signals {
+		if sig == syscall.SIGTERM || atPrompt.Load() {
+			fmt.Println()
+			commands.Shutdown(os.Stdout, 0)
+		}
+	}
+}
+
+// runLoop reads and handles user input until the reader reaches EOF or fails.
+// A final line without a trailing newline (Ctrl-D mid-line) is still handled.
+// atPrompt is set while waiting for input, for shutdownOnSignal.
+func runLoop(reader lineReader, conv *conversation.Conversation, state *types.RuntimeState, atPrompt *atomic.Bool) {
+	settings := state.Settings // Startup snapshot; queries use state.Settings, which commands may change
+	color := settings.Color && isTerminal(os.Stdout)
+
+	// Cached context size for the prompt; refreshed after each turn, not per keystroke
+	contextTokens := 0
+	if settings.PromptShowTokens {
+		contextTokens = conv.ContextTokens()
+	}
+
+	multiline := false // Toggled by /multiline, which belongs to the input loop
+	toggleMultiline := func(ctx *commands.CommandContext, args []string) error {
+		multiline = !multiline
+		if multiline {
+			fmt.Fprintln(ctx.Out, "Bot: Multiline mode on. End each message with a line containing only '.'.")
+		} else {
+			fmt.Fprintln(ctx.Out, "Bot: Multiline mode off.")
+		}
+		return nil
+	}
+	commands.Register(commands.Command{
+		Name:        "multiline",
+		Description: "Toggle multiline input (end each message with a line containing only '.'; a line ending in '\\' also continues).",
+		Run:         toggleMultiline,
+	})
+	cmdCtx := &commands.CommandContext{State: state, Conversation: conv, Out: os.Stdout}
 
 	for {
-		fmt.Print("You: ")
-		input, _ := reader.ReadString('\n')
+		prompt := settings.UserPrefix
+		if settings.PromptShowTokens {
+			prompt = formatPrompt(settings.UserPrefix, contextTokens, conv.MaxTokens())
+		}
+		prompt = api.Colorize(prompt, api.UserPromptColor, color)
+		atPrompt.Store(true)
+		input, readErr := readInput(reader, prompt, multiline)
+		atPrompt.Store(false)
+		if readErr != nil && readErr != io.EOF {
+			log.Printf("Error reading input: %v", readErr)
+			return
+		}
 		input = strings.TrimSpace(input)
+		if input != "" {
+			reader.AddHistory(input) // Both slash commands and queries are recalled
+		}
+
+		if expanded, ok := commands.ExpandAlias(state, input); ok {
+			input = expanded // An alias is sent as a regular query
+		}
 
 		if strings.HasPrefix(input, "/") {
-			// Pass provider info to command functions
-			// Commands handle their own output/errors internally for now
-			commands.RunCmd(strings.TrimPrefix(input, "/"), provider)
+			// Commands act on the runtime state and conversation and report their own errors
+			commands.RunCmd(cmdCtx, strings.TrimPrefix(input, "/"))
 		} else if input != "" {
-			// Handle regular chat query using the conversation object
-			err := api.QueryHandler(conv, input, provider) // Pass the conversation object
-			if err != nil {
-				// Print API errors directly to the user for now
-				// Log the detailed error as well
-				log.Printf("API Query Error: %v", err)
-				fmt.Printf("\nBot: Error communicating with API: %s\n", err) // Show simpler error to user
+			// Handle regular chat query using the conversation object.
+			// Ctrl-C while the request is in flight cancels it and returns to the prompt
+			// (at the prompt itself, the line editor handles Ctrl-C).
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+			err := api.QueryHandler(ctx, conv, input, state.Provider, state.Settings, api.NewRenderer(state.Provider, state.Settings)) // Pass the conversation object
+			stop()
+			if err != nil && !settings.JSONOutput { // JSON mode already emitted the error
+				printQueryError(err, state.Settings)
 			}
 		}
 		// No action for empty input to avoid clutter
+
+		if readErr == io.EOF {
+			return
+		}
+
+		if settings.PromptShowTokens && input != "" {
+			contextTokens = conv.ContextTokens()
+		}
+	}
+}
+
+// printQueryError reports a failed turn to the user in human-readable form.
+func printQueryError(err error, settings types.Settings) {
+	var idle *api.IdleTimeoutError
+	if errors.Is(err, context.Canceled) {
+		if settings.KeepPartialResponse {
+			fmt.Println("\nBot: Request cancelled. Any partial response was kept in history.")
+		} else {
+			fmt.Println("\nBot: Request cancelled.")
+		}
+	} else if errors.As(err, &idle) {
+		fmt.Printf("\nBot: The response stalled (no data for %s). Your message was kept in history.\n", idle.After)
+	} else if api.IsTimeout(err) {
+		fmt.Println("\nBot: The request timed out. Your message was kept in history.")
+	} else {
+		// Print API errors directly to the user for now
+		// Log the detailed error as well
+		log.Printf("API Query Error: %v", err)
+		fmt.Printf("\nBot: Error communicating with API: %s\n", err) // Show simpler error to user
+	}
+}
+
+// stdinIsTerminal reports whether stdin is an interactive terminal rather than a pipe or file.
+func stdinIsTerminal() bool {
+	info, err := os.Stdin.Stat()
+	if err != nil {
+		return true // Assume interactive if we can't tell
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// isTerminal reports whether f is an interactive terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// formatPrompt builds the input prompt including the context token estimate
+// before the prefix's trailing separator, e.g. "You [3.2k/32k]: ".
+func formatPrompt(prefix string, used, budget int) string {
+	return fmt.Sprintf("%s [%s/%s]: ", strings.TrimRight(prefix, ": "), formatTokenCount(used), formatTokenCount(budget))
+}
+
+// formatTokenCount renders a token count compactly (e.g. 950, 3.2k, 32k).
+func formatTokenCount(n int) string {
+	if n < 1000 {
+		return strconv.Itoa(n)
 	}
+	s := strconv.FormatFloat(float64(n)/1000, 'f', 1, 64)
+	return strings.TrimSuffix(s, ".0") + "k"
 }
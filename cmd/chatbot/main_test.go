@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/henryhwang/chatbot/internal/models"
+	"github.com/henryhwang/chatbot/internal/types"
+)
+
+func TestConversationFactoryUsesActiveModel(t *testing.T) {
+	state := &types.RuntimeState{
+		Provider: types.ModelProvider{Model: "gpt-4o"},
+		Settings: types.Settings{TruncationStrategy: "simple", DefaultMaxTokens: 1000},
+	}
+	newConversation := conversationFactory(state, "Be terse.")
+
+	for _, model := range []string{"gpt-4o", "claude-3-5-sonnet", "unknown-model"} {
+		state.Provider.Model = model // As /model or /provider would
+		conv, err := newConversation()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := models.ContextBudget(model, 1000); conv.MaxTokens() != want {
+			t.Errorf("%s: budget %d, want %d", model, conv.MaxTokens(), want)
+		}
+	}
+}
+
+func TestFormatPrompt(t *testing.T) {
+	tests := []struct {
+		prefix       string
+		used, budget int
+		want         string
+	}{
+		{"You: ", 0, 32000, "You [0/32k]: "},
+		{"You: ", 950, 32000, "You [950/32k]: "},
+		{"You: ", 3240, 32000, "You [3.2k/32k]: "},
+		{"You: ", 1000, 128000, "You [1k/128k]: "},
+		{"You: ", 31960, 111616, "You [32k/111.6k]: "},
+		{"Me:", 12, 4096, "Me [12/4.1k]: "},
+		{"> ", 12, 999, "> [12/999]: "},
+	}
+	for _, tt := range tests {
+		if got := formatPrompt(tt.prefix, tt.used, tt.budget); got != tt.want {
+			t.Errorf("formatPrompt(%q, %d, %d) = %q, want %q", tt.prefix, tt.used, tt.budget, got, tt.want)
+		}
+	}
+}
+
+// questionServer is a provider answering "ok" to every chat request and
+// recording the last user message of each.
+type questionServer struct {
+	mu        sync.Mutex
+	questions []string
+	tools     bool // Whether any request offered tools
+}
+
+func (s *questionServer) start(t *testing.T) types.ModelProvider {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload types.OpenAIRequest
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &payload); err == nil && len(payload.Messages) > 0 {
+			s.mu.Lock()
+			s.questions = append(s.questions, payload.Messages[len(payload.Messages)-1].Content)
+			s.tools = s.tools || len(payload.Tools) > 0
+			s.mu.Unlock()
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"ok\"}}]}\n\ndata: [DONE]\n\n")
+	}))
+	t.Cleanup(srv.Close)
+	return types.ModelProvider{UrlBase: srv.URL, APIs: map[string]string{"chat": "/chat"}, Model: "m", Format: "openai"}
+}
+
+func TestRunLoopStopsAtEOF(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string // Questions sent
+	}{
+		{"immediate EOF", "", nil},
+		{"blank lines", "\n  \n\n", nil},
+		{"question then EOF", "hello\n", []string{"hello"}},
+		{"EOF mid-line", "hello\nworld", []string{"hello", "world"}},
+		{"command then EOF", "/history\n", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var server questionServer
+			state := &types.RuntimeState{
+				Provider: server.start(t),
+				Settings: types.Settings{TruncationStrategy: "simple", UserPrefix: "You: ", BotPrefix: "Bot: ", Quiet: true},
+			}
+			conv, err := conversationFactory(state, "")()
+			if err != nil {
+				t.Fatal(err)
+			}
+			reader := &bufioLineReader{reader: bufio.NewReader(strings.NewReader(tt.input))}
+
+			done := make(chan struct{})
+			go func() {
+				runLoop(reader, conv, state, new(atomic.Bool))
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("the loop kept running after EOF")
+			}
+			if strings.Join(server.questions, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("sent %q, want %q", server.questions, tt.want)
+			}
+		})
+	}
+}
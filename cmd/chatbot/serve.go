@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/henryhwang/chatbot/internal/api"
+	"github.com/henryhwang/chatbot/internal/conversation"
+	"github.com/henryhwang/chatbot/internal/server"
+	"github.com/henryhwang/chatbot/internal/types"
+)
+
+// --- Server Mode ---
+
+// runServe handles "chatbot serve [-addr host:port]": the chatbot runs as an
+// HTTP service (see package server) with a conversation per session, until
+// SIGINT or SIGTERM shuts it down gracefully.
+func runServe(args []string, state *types.RuntimeState, newConversation func() *conversation.Conversation) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := flags.String("addr", state.Settings.ServeAddr, "Address to listen on (also SERVE_ADDR)")
+	flags.Parse(args)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	srv := newServer(state, newConversation)
+	log.Printf("Serving %s on http://%s (POST /chat, GET /models)", state.Provider.Model, *addr)
+	if err := srv.ListenAndServe(ctx, *addr); err != nil {
+		log.Fatalf("Server error: %v", err)
+	}
+	log.Print("Server stopped.")
+}
+
+// newServer returns the server for state's provider. Clients are remote and
+// unauthenticated, so unlike the terminal they get no access to this
+// machine: @path references are sent as written rather than read from disk
+// (which would also reveal which paths exist), and no tools are offered.
+func newServer(state *types.RuntimeState, newConversation func() *conversation.Conversation) *server.Server {
+	settings := state.Settings
+	settings.ExpandFileRefs = false
+	settings.StoreExpandedRefs = false
+	settings.EnableTools = false
+	api.SetToolRegistry(nil) // Registered at startup when TOOLS is on
+	return server.New(state.Provider, settings, newConversation)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/henryhwang/chatbot/internal/api"
+	"github.com/henryhwang/chatbot/internal/conversation"
+	"github.com/henryhwang/chatbot/internal/tools"
+	"github.com/henryhwang/chatbot/internal/types"
+)
+
+func TestServerKeepsLocalFilesAndToolsPrivate(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "id_rsa")
+	if err := os.WriteFile(secret, []byte("PRIVATE KEY"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	registry := tools.NewRegistry()
+	if err := registry.Register(tools.CurrentTime()); err != nil {
+		t.Fatal(err)
+	}
+	api.SetToolRegistry(registry) // As main does when TOOLS is on
+	defer api.SetToolRegistry(nil)
+
+	var provider questionServer
+	state := &types.RuntimeState{
+		Provider: provider.start(t),
+		// The terminal's settings, with everything the server must turn off
+		Settings: types.Settings{TruncationStrategy: "simple", ExpandFileRefs: true, StoreExpandedRefs: true, EnableTools: true},
+	}
+	newConversation := func() *conversation.Conversation {
+		conv, _ := conversationFactory(state, "")()
+		return conv
+	}
+	srv := httptest.NewServer(newServer(state, newConversation).Handler())
+	defer srv.Close()
+
+	for _, prompt := range []string{"@" + secret + " explain", "@/no/such/file explain"} {
+		res, err := http.Post(srv.URL+"/chat", "application/json", strings.NewReader(`{"prompt":"`+prompt+`"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if strings.Contains(string(stream), "event: warning") {
+			t.Errorf("%q: the client was told about the path:\n%s", prompt, stream)
+		}
+	}
+
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+	if want := []string{"@" + secret + " explain", "@/no/such/file explain"}; strings.Join(provider.questions, "\n") != strings.Join(want, "\n") {
+		t.Errorf("provider got %q, want the prompts verbatim %q", provider.questions, want)
+	}
+	if provider.tools {
+		t.Error("tools were offered to a server client")
+	}
+}
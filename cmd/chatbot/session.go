@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/henryhwang/chatbot/internal/conversation"
+)
+
+// --- Session Persistence ---
+
+// loadSession restores the conversation saved at path. A missing file is a
+// fresh start; an unreadable or corrupt one is reported and ignored.
+func loadSession(conv *conversation.Conversation, path string) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+	if err == nil {
+		err = conv.UnmarshalHistory(data)
+	}
+	if err != nil {
+		log.Printf("Warning: Could not restore session from %s, starting fresh: %v", path, err)
+		return
+	}
+	if n := len(conv.GetFullHistory()); n > 0 {
+		fmt.Printf("Restored %d messages from %s\n", n, path)
+	}
+}
+
+// saveSession writes the conversation to path, replacing the file atomically
+// so an interrupted save never leaves a corrupt session behind.
+func saveSession(conv *conversation.Conversation, path string) {
+	data, err := conv.MarshalHistory()
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(path), 0700)
+	}
+	if err == nil {
+		tmp := path + ".tmp"
+		if err = os.WriteFile(tmp, data, 0600); err == nil {
+			err = os.Rename(tmp, path)
+		}
+	}
+	if err != nil {
+		log.Printf("Warning: Could not save session to %s: %v", path, err)
+	}
+}
@@ -2,4 +2,12 @@ module github.com/henryhwang/chatbot
 
 go 1.23.0
 
-require github.com/joho/godotenv v1.5.1
+require (
+	github.com/chzyer/readline v1.5.1
+	github.com/joho/godotenv v1.5.1
+	golang.org/x/sync v0.10.0
+	golang.org/x/term v0.30.0
+	golang.org/x/time v0.11.0
+)
+
+require golang.org/x/sys v0.31.0 // indirect
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/henryhwang/chatbot/internal/types"
+)
+
+// --- Anthropic Messages API ---
+
+const (
+	anthropicVersion = "2023-06-01" // Value of the required anthropic-version header
+	// max_tokens is mandatory for Anthropic; used when RESPONSE_MAX_TOKENS is unset
+	anthropicDefaultMaxTokens = 4096
+)
+
+// anthropicProvider speaks the Anthropic Messages API (e.g. APIS=chat:/v1/messages).
+type anthropicProvider struct{}
+
+func (anthropicProvider) BuildRequest(ctx context.Context, provider types.ModelProvider, settings types.Settings, messages []types.Message) (*http.Request, error) {
+	if settings.DropEmptyAssistant {
+		messages = dropEmptyAssistant(messages)
+	}
+	messages = applyRoleContentPrefix(messages, provider.RoleContentPrefix)
+
+	requestPayload := types.AnthropicRequest{
+		Model:       provider.Model,
+		MaxTokens:   anthropicDefaultMaxTokens,
+		Stream:      true,
+		Temperature: settings.Sampling.Temperature,
+		TopP:        settings.Sampling.TopP,
+
+		StopSequences: settings.Sampling.Stop,
+	}
+	if settings.Sampling.MaxTokens != nil {
+		requestPayload.MaxTokens = *settings.Sampling.MaxTokens
+	}
+	requestPayload.System, requestPayload.Messages = toAnthropicMessages(messages)
+
+	requestBody, err := json.Marshal(requestPayload)
+	if err != nil {
+		return nil, fmt.Errorf("error preparing request payload: %w", err)
+	}
+	return anthropicProvider{}.NewRequest(ctx, provider, requestBody)
+}
+
+func (anthropicProvider) NewRequest(ctx context.Context, provider types.ModelProvider, body []byte) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", provider.UrlBase+provider.APIs["chat"], bytes.NewBuffer(body))
+	if err != nil {
+		return nil, fmt.Errorf("error creating HTTP request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Api-Key", provider.APIKey)
+	req.Header.Set("Anthropic-Version", anthropicVersion)
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("User-Agent", UserAgent())
+	SetExtraHeaders(req, provider)
+	return req, nil
+}
+
+// toAnthropicMessages moves system messages (including reminders) into the
+// top-level system field and merges consecutive messages of the same role,
+// since the Messages API only accepts alternating user/assistant turns.
+//
+// Tool calls and results (from turns under an OpenAI-format provider) are
+// written out as text: requests here offer no tools, and the API rejects
+// tool_use and tool_result blocks in a request that defines none, as well as
+// the "tool" role itself.
+func toAnthropicMessages(messages []types.Message) (string, []types.AnthropicMessage) {
+	var system []string
+	converted := []types.AnthropicMessage{}
+	toolNames := map[string]string{} // Tool call ID to function name, for the results
+	for _, msg := range messages {
+		role, content := msg.Role, msg.Content
+		switch role {
+		case "system":
+			system = append(system, content)
+			continue
+		case "assistant":
+			for _, call := range msg.ToolCalls {
+				toolNames[call.ID] = call.Function.Name
+				content = joinNonEmpty(content, fmt.Sprintf("[Called tool %s]", formatToolCall(call)))
+			}
+		case "tool":
+			role = "user" // Results go back to the model in the user's turn
+			name := toolNames[msg.ToolCallID]
+			if name == "" {
+				name = "tool"
+			}
+			content = fmt.Sprintf("[Result of %s: %s]", name, content)
+		}
+		if last := len(converted) - 1; last >= 0 && converted[last].Role == role {
+			converted[last].Content = joinNonEmpty(converted[last].Content, content)
+			continue
+		}
+		converted = append(converted, types.AnthropicMessage{Role: role, Content: content})
+	}
+	return strings.Join(system, "\n\n"), converted
+}
+
+// joinNonEmpty joins two message parts with a blank line, or returns the
+// non-empty one alone.
+func joinNonEmpty(a, b string) string {
+	if a == "" || b == "" {
+		return a + b
+	}
+	return a + "\n\n" + b
+}
+
+func (anthropicProvider) ParseStream(body io.Reader, renderer OutputRenderer) (StreamResult, error) {
+	return parseStream(body, anthropicStream(), renderer)
+}
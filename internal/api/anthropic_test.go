@@ -0,0 +1,86 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/henryhwang/chatbot/internal/types"
+)
+
+func TestToAnthropicMessages(t *testing.T) {
+	call := types.ToolCall{ID: "call_1", Type: "function", Function: types.ToolCallFunction{Name: "current_time", Arguments: `{"zone":"UTC"}`}}
+	tests := []struct {
+		name       string
+		messages   []types.Message
+		wantSystem string
+		want       []types.AnthropicMessage
+	}{
+		{
+			name: "system messages move to the system field",
+			messages: []types.Message{
+				{Role: "system", Content: "Be terse."},
+				{Role: "user", Content: "hi"},
+				{Role: "system", Content: "Reminder."},
+			},
+			wantSystem: "Be terse.\n\nReminder.",
+			want:       []types.AnthropicMessage{{Role: "user", Content: "hi"}},
+		},
+		{
+			name: "consecutive roles merge",
+			messages: []types.Message{
+				{Role: "user", Content: "one"},
+				{Role: "user", Content: "two"},
+				{Role: "assistant", Content: "ok"},
+			},
+			want: []types.AnthropicMessage{{Role: "user", Content: "one\n\ntwo"}, {Role: "assistant", Content: "ok"}},
+		},
+		{
+			name: "tool calls and results become text",
+			messages: []types.Message{
+				{Role: "user", Content: "What time is it?"},
+				{Role: "assistant", ToolCalls: []types.ToolCall{call}},
+				{Role: "tool", Content: "12:00", ToolCallID: "call_1"},
+				{Role: "assistant", Content: "It is noon."},
+				{Role: "user", Content: "Thanks"},
+			},
+			want: []types.AnthropicMessage{
+				{Role: "user", Content: "What time is it?"},
+				{Role: "assistant", Content: `[Called tool current_time({"zone":"UTC"})]`},
+				{Role: "user", Content: "[Result of current_time: 12:00]"},
+				{Role: "assistant", Content: "It is noon."},
+				{Role: "user", Content: "Thanks"},
+			},
+		},
+		{
+			name: "tool call with content and several results",
+			messages: []types.Message{
+				{Role: "user", Content: "Times?"},
+				{Role: "assistant", Content: "Checking.", ToolCalls: []types.ToolCall{call, {ID: "call_2", Function: types.ToolCallFunction{Name: "current_time", Arguments: "{}"}}}},
+				{Role: "tool", Content: "12:00", ToolCallID: "call_1"},
+				{Role: "tool", Content: "13:00", ToolCallID: "call_2"},
+				{Role: "tool", Content: "?", ToolCallID: "unknown"},
+			},
+			want: []types.AnthropicMessage{
+				{Role: "user", Content: "Times?"},
+				{Role: "assistant", Content: "Checking.\n\n[Called tool current_time({\"zone\":\"UTC\"})]\n\n[Called tool current_time({})]"},
+				{Role: "user", Content: "[Result of current_time: 12:00]\n\n[Result of current_time: 13:00]\n\n[Result of tool: ?]"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			system, got := toAnthropicMessages(tt.messages)
+			if system != tt.wantSystem {
+				t.Errorf("system = %q, want %q", system, tt.wantSystem)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("messages = %#v\nwant %#v", got, tt.want)
+			}
+			for _, msg := range got {
+				if msg.Role != "user" && msg.Role != "assistant" {
+					t.Errorf("role %q is not accepted by the Messages API", msg.Role)
+				}
+			}
+		})
+	}
+}
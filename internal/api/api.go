@@ -1,184 +1,313 @@
 package api
 
 import (
-	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/henryhwang/chatbot/internal/conversation" // Import the new package
+	"github.com/henryhwang/chatbot/internal/fileref"
 	"github.com/henryhwang/chatbot/internal/types"
+	"github.com/henryhwang/chatbot/internal/version"
 )
 
 // --- Core Query Handler (Handles Streaming) ---
 
 // QueryHandler sends the user input and conversation history to the LLM API
 // and processes the streaming response. It updates the conversation object
-// with the assistant's final response.
-func QueryHandler(conv *conversation.Conversation, input string, provider types.ModelProvider) error {
-	apiURL := provider.UrlBase + provider.APIs["chat"] // Ensure "chat" key exists in APIS map
-	apiKey := provider.APIKey
+// with the assistant's final response. Output is passed to renderer (see
+// NewRenderer for the default terminal/JSON choice).
+// Cancelling ctx aborts the request, including a stream in progress.
+// It is a consumer of Chat, turning its events into renderer calls.
+func QueryHandler(ctx context.Context, conv *conversation.Conversation, input string, provider types.ModelProvider, settings types.Settings, renderer OutputRenderer) error {
+	var err error
+	var reply string
+	for event := range Chat(ctx, conv, input, provider, settings) {
+		switch event := event.(type) {
+		case StartEvent:
+			renderer.OnStart()
+		case ReasoningEvent:
+			renderer.OnReasoning(event.Text)
+		case ContentEvent:
+			renderer.OnContent(event.Text)
+		case ToolCallEvent:
+			renderer.OnToolCall(event.Call.Function.Name, event.Call.Function.Arguments, event.Output)
+		case WarningEvent:
+			warn(settings, event.Text)
+		case NoticeEvent:
+			if settings.JSONOutput || settings.Quiet {
+				log.Println(event.Text)
+			} else {
+				fmt.Println(event.Text)
+			}
+		case DoneEvent:
+			renderer.OnDone(event.StreamResult, nil)
+			reply = event.Content
+		case ErrorEvent:
+			renderer.OnDone(event.Partial, event.Err)
+			err = event.Err
+		}
+	}
 
-	// Add user message to conversation history (handles truncation internally)
-	conv.AddMessage("user", input)
+	// A JSON response format is a request the model may not honour (e.g. a provider that ignores it)
+	if err == nil && settings.Sampling.ResponseFormat != nil && reply != "" && !json.Valid([]byte(reply)) {
+		warn(settings, "The reply is not valid JSON, although a JSON response format was requested.")
+	}
 
-	// --- Prepare the request payload ---
-	// Get the messages to send to the API (respecting the API context limit)
-	contextForLLM := conv.GetContext()
+	// Warn ahead of older messages being left out, once per crossing of the ratio
+	if tokens, crossed := conv.ContextNearlyFull(settings.ContextWarnRatio); crossed {
+		warn(settings, fmt.Sprintf("The context is at ~%d of %d tokens; older messages will soon be left out. Use /save to keep a copy or /clear to start afresh.", tokens, conv.MaxTokens()))
+	}
+	return err
+}
 
-	requestBody, err := prepareRequestPayload(provider, contextForLLM) // Pass the potentially limited slice
-	if err != nil {
-		// No need to manually remove the user message here,
-		// as it's already correctly added to the conversation history.
-		return fmt.Errorf("error preparing request payload: %w", err)
+// warn shows a warning about the turn, on stderr when stdout is reserved
+// for the answer itself (quiet or JSON output).
+func warn(settings types.Settings, warning string) {
+	if settings.JSONOutput || settings.Quiet {
+		log.Println("Warning:", warning)
+	} else {
+		fmt.Println("Bot: Warning:", warning)
 	}
+}
 
-	// Execute the API request and get the response
-	resp, err := executeAPIRequest(apiURL, requestBody, apiKey)
-	if err != nil {
-		// No need to manually remove the user message here.
-		return fmt.Errorf("error executing API request: %w", err) // Propagate error
+// runTurn performs one turn of the conversation, reporting its output to events.
+func runTurn(ctx context.Context, conv *conversation.Conversation, input string, provider types.ModelProvider, settings types.Settings, renderer *eventRenderer) error {
+	format := ProviderFor(provider.Format) // Request and stream format of the provider's API
+	asked := time.Now()
+
+	// Expand @file references; history keeps the raw text unless configured otherwise
+	outgoing := input
+	if settings.ExpandFileRefs {
+		var warnings []string
+		outgoing, warnings = fileref.Expand(input, conv.MaxTokens()-conv.ContextTokens())
+		for _, warning := range warnings {
+			renderer.send(WarningEvent{Text: warning})
+		}
+	}
+	stored := input
+	if settings.StoreExpandedRefs {
+		stored = outgoing
 	}
-	defer resp.Body.Close()
 
-	// --- Process the Streaming Response ---
-	fullResponse, assistantRole, reasoningPrinted, botPrefixPrinted, streamErr := handleStreamResponse(resp.Body) // Pass resp.Body
+	// Add user message to conversation history (handles truncation internally)
+	conv.AddMessage("user", stored)
 
-	// --- Cleanup after streaming finishes ---
+	// Select the client (network through any proxy, recording or replay)
+	client, err := HTTPClient(settings)
+	if err != nil {
+		renderer.OnDone(StreamResult{}, err)
+		return err
+	}
 
-	// Add a final newline for clean prompt display if anything was printed
-	if botPrefixPrinted || reasoningPrinted {
-		fmt.Println()
-	} else {
-		// Handle cases where stream ended early or with no valid data
-		// Only print this if the stream didn't encounter an error itself
-		if streamErr == nil {
-			fmt.Println("\nBot: Received no response content.")
+	// Each round sends the context; when the model calls tools, their results
+	// are added to the history and another round lets it continue
+	for round := 1; ; round++ {
+		// --- Prepare the request payload ---
+		// Get the messages to send to the API (respecting the API context limit)
+		contextForLLM, omitted, err := conv.RequestContext(ctx)
+		if err != nil {
+			if round == 1 {
+				conv.RollbackLastUserMessage() // Nothing was sent, so the message isn't left unanswered
+			}
+			err = fmt.Errorf("error building context: %w", err)
+			renderer.OnDone(StreamResult{}, err)
+			return err
+		}
+		if round == 1 && omitted > 0 {
+			renderer.send(NoticeEvent{Text: fmt.Sprintf("(note: %d older message(s) omitted for context limit)", omitted)})
+		}
+		if tokens := conversation.CountTokens(contextForLLM); round == 1 && tokens > conv.MaxTokens() {
+			renderer.send(NoticeEvent{Text: fmt.Sprintf("(note: your message alone is over the context limit, ~%d of %d tokens; sending it anyway)", tokens, conv.MaxTokens())})
 		}
-	}
 
-	// Check for errors during stream processing
-	if streamErr != nil {
-		// Don't add potentially incomplete response to history if stream errored
-		return fmt.Errorf("error reading stream: %w", streamErr) // Propagate stream error
-	}
+		// Send the expanded file contents even when history stores the raw references
+		if outgoing != stored {
+			expandUserMessage(contextForLLM, stored, outgoing)
+		}
 
-	// Add the complete assistant message (content only) to the conversation history
-	// Only add if there was actual content and no stream error
-	if fullResponse.Len() > 0 {
-		// Use the conversation's method to add the message (handles truncation)
-		conv.AddMessage(assistantRole, fullResponse.String())
-	} else if !reasoningPrinted {
-		// Only show this message if NO reasoning AND NO content was generated, and no stream error
-		fmt.Println("Bot: Finished processing, but no text content was generated.")
-	}
+		// Hooks may change what is sent; from here on each is told how it went
+		contextForLLM, err = beforeRequest(settings.Hooks, contextForLLM)
+		if err != nil {
+			afterResponse(settings.Hooks, StreamResult{}, err)
+			renderer.OnDone(StreamResult{}, err)
+			return err
+		}
 
-	return nil // Indicate success
-}
+		req, err := format.BuildRequest(ctx, provider, settings, contextForLLM) // Pass the potentially limited slice
+		if err != nil {
+			// No need to manually remove the user message here,
+			// as it's already correctly added to the conversation history.
+			err = fmt.Errorf("error preparing request: %w", err)
+			afterResponse(settings.Hooks, StreamResult{}, err)
+			renderer.OnDone(StreamResult{}, err)
+			return err
+		}
 
-// handleStreamResponse processes the SSE stream from the response body.
-// It prints reasoning and content chunks directly to stdout and accumulates
-// the final content response.
-// Returns the accumulated content, final assistant role, flags indicating if
-// reasoning/content was printed, and any error encountered during scanning.
-func handleStreamResponse(body io.Reader) (strings.Builder, string, bool, bool, error) {
-	var fullResponse strings.Builder
-	scanner := bufio.NewScanner(body) // Use the passed reader
-	assistantRole := "assistant"      // Default role
-	reasoningPrefix := "🤔 Reasoning: "
-	botPrefix := "Bot: "
-	currentlyReasoning := false
-	reasoningPrinted := false
-	botPrefixPrinted := false
+		// Execute the API request and get the response
+		renderer.OnStart()
+		resp, err := executeAPIRequest(ctx, client, settings, req)
+		if err != nil {
+			handleCancelledTurn(conv, settings, err)
+			err = fmt.Errorf("error executing API request: %w", err)
+			afterResponse(settings.Hooks, StreamResult{}, err)
+			renderer.OnDone(StreamResult{}, err)
+			return err // Propagate error
+		}
 
-	for scanner.Scan() {
-		line := scanner.Text()
+		// --- Process the Streaming Response ---
+		result, streamErr := format.ParseStream(resp.Body, renderer) // Pass resp.Body
+		resp.Body.Close()
+		if streamErr != nil {
+			streamErr = fmt.Errorf("error reading stream: %w", streamErr)
+		}
+		afterResponse(settings.Hooks, result, streamErr)
 
-		if strings.HasPrefix(line, "data: ") {
-			data := strings.TrimPrefix(line, "data: ")
+		// Reasoning is stored alongside the reply only when configured
+		keptReasoning := ""
+		if settings.KeepReasoning {
+			keptReasoning = result.Reasoning
+		}
 
-			if data == "[DONE]" {
-				break
+		// Check for errors during stream processing
+		if streamErr != nil {
+			renderer.OnDone(result, streamErr)
+			if settings.KeepPartialResponse && errors.Is(streamErr, context.Canceled) && !IsTimeout(streamErr) && result.Content != "" {
+				// The user stopped the generation: keep what was shown, with its question
+				conv.AddMessageWithReasoning(result.Role, result.Content, keptReasoning)
+				return streamErr
 			}
+			// Don't add potentially incomplete response to history if stream errored
+			handleCancelledTurn(conv, settings, streamErr)
+			return streamErr // Propagate stream error
+		}
 
-			var streamResp types.OpenAIStreamResponse
-			err := json.Unmarshal([]byte(data), &streamResp)
-			if err != nil {
-				// Log the error but attempt to continue processing the stream
-				log.Printf("Error unmarshalling stream data: %v. Data: '%s'", err, data)
-				continue
+		// Record token usage when the provider reported it
+		if result.Usage != nil {
+			conv.AddUsage(*result.Usage)
+		}
+
+		// Run requested tools and continue with their results
+		if len(result.ToolCalls) > 0 && toolRegistry != nil {
+			if round > maxToolRounds {
+				err := fmt.Errorf("stopped after %d rounds of tool calls", maxToolRounds)
+				renderer.OnDone(result, err)
+				return err
 			}
+			conv.AppendMessage(types.Message{Role: result.Role, Content: result.Content, Reasoning: keptReasoning, ToolCalls: result.ToolCalls})
+			runToolCalls(ctx, conv, result.ToolCalls, renderer)
+			continue
+		}
+		renderer.OnDone(result, nil)
+
+		// Add the complete assistant message (content only) to the conversation history
+		// Only add if there was actual content and no stream error
+		if result.Content != "" {
+			// Use the conversation's method to add the message (handles truncation)
+			conv.AddMessageWithReasoning(result.Role, result.Content, keptReasoning)
+		}
+		if settings.TranscriptFile != "" {
+			appendTranscript(settings.TranscriptFile, input, asked, result.Content, time.Now())
+		}
 
-			if len(streamResp.Choices) > 0 {
-				choice := streamResp.Choices[0]
-				delta := choice.Delta
-
-				if delta.Role != "" {
-					assistantRole = delta.Role
-				}
-
-				if delta.Reasoning != "" {
-
-					if !currentlyReasoning {
-
-						if botPrefixPrinted {
-							fmt.Println()
-						}
-						fmt.Print(reasoningPrefix)
-						currentlyReasoning = true
-						reasoningPrinted = true
-						botPrefixPrinted = false
-					}
-					fmt.Print(delta.Reasoning)
-
-				}
-
-				if delta.Content != "" {
-
-					if currentlyReasoning {
-						fmt.Println()
-						currentlyReasoning = false
-					}
-
-					if !botPrefixPrinted {
-						fmt.Print(botPrefix)
-						botPrefixPrinted = true
-					}
-					fmt.Print(delta.Content)
-					fullResponse.WriteString(delta.Content)
-				}
-
-				// Check for finish reason if needed (optional)
-				// if choice.FinishReason != nil {
-				//     log.Printf("Stream finished with reason: %s", *choice.FinishReason)
-				// }
+		return nil // Indicate success
+	}
+}
+
+// expandUserMessage replaces the most recent user message whose content is
+// stored (the raw @path text) with its expanded form.
+func expandUserMessage(messages []types.Message, stored, outgoing string) {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "user" {
+			if messages[i].Content == stored {
+				messages[i].Content = outgoing
 			}
+			return
 		}
 	}
+}
+
+// handleCancelledTurn applies the configured OnCancel policy when the user
+// cancelled the turn: "rollback" removes the dangling user message so history
+// stays consistent, "keep" leaves it in place for a retry. Timed-out turns
+// always keep the user message so it can be retried.
+func handleCancelledTurn(conv *conversation.Conversation, settings types.Settings, err error) {
+	if !errors.Is(err, context.Canceled) || IsTimeout(err) || settings.OnCancel != "rollback" {
+		return
+	}
+	conv.RollbackLastUserMessage()
+}
 
-	// Check for scanner errors after the loop finishes
-	if err := scanner.Err(); err != nil {
-		log.Printf("Error reading stream: %v", err)
-		return fullResponse, assistantRole, reasoningPrinted, botPrefixPrinted, err // Return scanner error
+// IsTimeout reports whether err was caused by a request deadline or timeout.
+func IsTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
 	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
 
-	return fullResponse, assistantRole, reasoningPrinted, botPrefixPrinted, nil // No error
+// StreamResult holds everything parsed from a streamed response.
+type StreamResult struct {
+	Content   string           // Accumulated final answer content
+	Reasoning string           // Accumulated reasoning/thinking content
+	Role      string           // Assistant role reported by the stream
+	Usage     *types.UsageInfo // Token usage, if the provider reported it
+	ToolCalls []types.ToolCall // Complete tool calls requested by the model
+	Choices   []string         // Every answer when several were requested (n > 1); Content is the first
+
+	FirstChunkAt time.Time // When the first reasoning or content arrived (zero if none did)
+	CompletedAt  time.Time // When the stream ended
 }
 
 // executeAPIRequest sends the prepared request to the API endpoint and checks the response status.
-func executeAPIRequest(apiURL string, requestBody []byte, apiKey string) (*http.Response, error) {
-	req, err := prepareRequest(apiURL, requestBody, apiKey)
-	if err != nil {
-		// No need to print here, error is returned
-		return nil, fmt.Errorf("failed to create request: %w", err)
+// The request should be bound to ctx and is limited by the client's timeout (see HTTPClient).
+// Transient failures are retried up to settings.MaxRetries times (see retry.go).
+func executeAPIRequest(ctx context.Context, client *http.Client, settings types.Settings, req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := sendOnce(client, req)
+		if err == nil {
+			// Return the successful response (caller is responsible for closing the body)
+			resp.Body = withIdleTimeout(resp.Body, settings.StreamIdleTimeout)
+			return resp, nil
+		}
+		if attempt >= settings.MaxRetries || !isRetryable(ctx, err) {
+			return nil, err
+		}
+
+		delay := backoffDelay(attempt, err)
+		log.Printf("Debug: Retrying request (attempt %d/%d) in %s after error: %v", attempt+2, settings.MaxRetries+1, delay.Round(time.Millisecond), err)
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("failed to contact LLM API: %w", ctx.Err())
+		case <-time.After(delay):
+		}
 	}
+}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+// sendOnce performs a single request attempt on a copy of req, since a body
+// can only be sent once. Non-OK responses are returned as *statusError so the
+// retry logic can inspect them.
+func sendOnce(client *http.Client, req *http.Request) (*http.Response, error) {
+	attempt := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			// No need to print here, error is returned
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		attempt.Body = body
+	}
+
+	resp, err := client.Do(attempt)
 	if err != nil {
 		// No need to print here, error is returned
 		return nil, fmt.Errorf("failed to contact LLM API: %w", err)
@@ -187,44 +316,135 @@ func executeAPIRequest(apiURL string, requestBody []byte, apiKey string) (*http.
 	// Check for non-OK status codes *before* trying to process the body
 	if resp.StatusCode != http.StatusOK {
 		defer resp.Body.Close() // Ensure body is closed even on error
+		statusErr := &statusError{StatusCode: resp.StatusCode, RetryAfter: resp.Header.Get("Retry-After")}
 		bodyBytes, readErr := io.ReadAll(resp.Body)
 		if readErr != nil {
 			// Log reading error, but return the original status error
 			log.Printf("Error reading error response body: %v", readErr)
-			return nil, fmt.Errorf("LLM API returned error status %d (failed to read body)", resp.StatusCode)
+			return nil, statusErr
 		}
 		// Return an error with the status code and response body
-		return nil, fmt.Errorf("LLM API returned error status %d: %s", resp.StatusCode, string(bodyBytes))
+		statusErr.Body = string(bodyBytes)
+		return nil, statusErr
 	}
 
-	// Return the successful response (caller is responsible for closing the body)
 	return resp, nil
 }
 
 // prepareRequestPayload creates the JSON body for the API request.
 // It now accepts a slice of messages directly, not a pointer to a slice.
-func prepareRequestPayload(provider types.ModelProvider, messages []types.Message) ([]byte, error) {
+// Any configured per-role content prefixes are applied to a copy of the
+// messages, so stored history stays unprefixed. Empty assistant messages are
+// dropped when settings.DropEmptyAssistant is set, since some backends reject them.
+func prepareRequestPayload(provider types.ModelProvider, settings types.Settings, messages []types.Message) ([]byte, error) {
+	if settings.DropEmptyAssistant {
+		messages = dropEmptyAssistant(messages)
+	}
+	messages = applyRoleContentPrefix(messages, provider.RoleContentPrefix)
+
 	requestPayload := types.OpenAIRequest{
-		Model:    provider.Model,
-		Messages: messages, // Use the passed slice directly
-		Stream:   true,
+		Model:          provider.Model,
+		Messages:       messages, // Use the passed slice directly
+		Stream:         true,
+		SamplingParams: settings.Sampling, // Unset parameters are omitted from the JSON
+	}
+	if toolRegistry != nil && toolRegistry.Len() > 0 {
+		requestPayload.Tools = toolRegistry.Definitions()
+	}
+	if settings.StreamUsage {
+		requestPayload.StreamOptions = &types.StreamOptions{IncludeUsage: true}
 	}
 
 	requestBody, err := json.Marshal(requestPayload)
 	return requestBody, err
 }
 
+// dropEmptyAssistant returns a copy of messages without assistant messages
+// whose content is empty or whitespace-only (tool call requests are kept,
+// since their results must follow them). A non-empty trailing assistant
+// message (an intentional prefill) is kept.
+func dropEmptyAssistant(messages []types.Message) []types.Message {
+	filtered := make([]types.Message, 0, len(messages))
+	for _, msg := range messages {
+		if msg.Role == "assistant" && strings.TrimSpace(msg.Content) == "" && len(msg.ToolCalls) == 0 {
+			continue
+		}
+		filtered = append(filtered, msg)
+	}
+	return filtered
+}
+
+// applyRoleContentPrefix returns a copy of messages with each role's configured
+// prefix placed on its own line before the content.
+func applyRoleContentPrefix(messages []types.Message, prefixes map[string]string) []types.Message {
+	if len(prefixes) == 0 {
+		return messages
+	}
+	prefixed := make([]types.Message, len(messages))
+	for i, msg := range messages {
+		if prefix, ok := prefixes[msg.Role]; ok {
+			msg.Content = prefix + "\n" + msg.Content
+		}
+		prefixed[i] = msg
+	}
+	return prefixed
+}
+
 // prepareRequest creates a new HTTP request object with necessary headers.
-func prepareRequest(apiURL string, requestBody []byte, apiKey string) (*http.Request, error) {
-	req, err := http.NewRequest("POST", apiURL, bytes.NewBuffer(requestBody))
+// The organization and project headers are only sent when configured.
+func prepareRequest(ctx context.Context, apiURL string, requestBody []byte, provider types.ModelProvider) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", apiURL, bytes.NewBuffer(requestBody))
 	if err != nil {
 		// Return error instead of printing and returning bool
 		return nil, fmt.Errorf("error creating HTTP request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+apiKey)
+	if provider.Format == "azure" {
+		req.Header.Set("Api-Key", provider.APIKey) // Azure OpenAI takes the key itself, not a bearer token
+	} else {
+		req.Header.Set("Authorization", "Bearer "+provider.APIKey)
+	}
+	if provider.OrgID != "" {
+		req.Header.Set("OpenAI-Organization", provider.OrgID)
+	}
+	if provider.ProjectID != "" {
+		req.Header.Set("OpenAI-Project", provider.ProjectID)
+	}
 	req.Header.Set("Accept", "text/event-stream") // Necessary for SSE
 	req.Header.Set("Connection", "keep-alive")    // Good practice for streaming
-	return req, nil                               // Return request and nil error
+	req.Header.Set("User-Agent", UserAgent())
+	SetExtraHeaders(req, provider)
+	return req, nil // Return request and nil error
+}
+
+// User-Agent sent with every provider request; empty means "chatbot/<version>"
+var userAgent string
+
+// SetUserAgent replaces the User-Agent header sent with provider requests
+// (USER_AGENT); an empty value restores the default, "chatbot/<version>".
+func SetUserAgent(agent string) {
+	userAgent = agent
+}
+
+// UserAgent returns the User-Agent header sent with provider requests.
+func UserAgent() string {
+	if userAgent != "" {
+		return userAgent
+	}
+	return "chatbot/" + version.Short()
+}
+
+// SetExtraHeaders adds the provider's EXTRA_HEADERS to req. They never
+// replace a header the request already has (such as Content-Type or
+// Authorization) unless the name is written with a "!" prefix, e.g.
+// "!Authorization:Basic ...", to make that intent explicit.
+func SetExtraHeaders(req *http.Request, provider types.ModelProvider) {
+	for name, value := range provider.ExtraHeaders {
+		if replaced := strings.TrimPrefix(name, "!"); replaced != name {
+			req.Header.Set(replaced, value)
+		} else if req.Header.Get(name) == "" {
+			req.Header.Set(name, value)
+		}
+	}
 }
@@ -0,0 +1,501 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/henryhwang/chatbot/internal/conversation"
+	"github.com/henryhwang/chatbot/internal/types"
+	"github.com/henryhwang/chatbot/internal/version"
+)
+
+// chatServer is a fake OpenAI-compatible provider that streams reply to
+// every chat request (or fails with status, when set) and records the
+// request bodies and headers. When first is set, it is sent as the raw
+// stream of the first reply instead, e.g. to call a tool.
+type chatServer struct {
+	*httptest.Server
+	reply  string
+	status int
+	first  string
+
+	mu      sync.Mutex
+	bodies  []string
+	headers []http.Header
+}
+
+func newChatServer(t *testing.T, reply string) *chatServer {
+	s := &chatServer{reply: reply}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		s.mu.Lock()
+		s.bodies = append(s.bodies, string(body))
+		s.headers = append(s.headers, r.Header.Clone())
+		first := len(s.bodies) == 1 && s.first != ""
+		s.mu.Unlock()
+		if s.status != 0 {
+			http.Error(w, `{"error":"failed"}`, s.status)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		if first {
+			io.WriteString(w, s.first)
+			return
+		}
+		io.WriteString(w, sseChunk(s.reply)+"data: [DONE]\n\n")
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// provider returns an OpenAI-format provider pointing at the server.
+func (s *chatServer) provider() types.ModelProvider {
+	return types.ModelProvider{
+		UrlBase: s.URL,
+		APIKey:  "test-key",
+		APIs:    map[string]string{"chat": "/v1/chat/completions", "models": "/v1/models"},
+		Model:   "test-model",
+		Format:  "openai",
+	}
+}
+
+// requests returns the bodies received so far.
+func (s *chatServer) requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.bodies...)
+}
+
+// lastHeader returns the headers of the latest request.
+func (s *chatServer) lastHeader() http.Header {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.headers) == 0 {
+		return nil
+	}
+	return s.headers[len(s.headers)-1]
+}
+
+// requestMessages decodes the messages of an OpenAI-format request body.
+func requestMessages(t *testing.T, body string) []types.Message {
+	t.Helper()
+	var payload types.OpenAIRequest
+	if err := json.Unmarshal([]byte(body), &payload); err != nil {
+		t.Fatalf("malformed request body: %v", err)
+	}
+	return payload.Messages
+}
+
+func TestDropEmptyAssistant(t *testing.T) {
+	tests := []struct {
+		name      string
+		drop      bool
+		assistant types.Message
+		wantSent  int // Messages in the request
+	}{
+		{"empty reply dropped", true, types.Message{Role: "assistant"}, 2},
+		{"whitespace reply dropped", true, types.Message{Role: "assistant", Content: " \n"}, 2},
+		{"empty reply kept when disabled", false, types.Message{Role: "assistant"}, 3},
+		{"tool call request kept", true, types.Message{Role: "assistant", ToolCalls: []types.ToolCall{{ID: "call_1", Type: "function"}}}, 3},
+		{"non-empty reply kept", true, types.Message{Role: "assistant", Content: "hello"}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newChatServer(t, "ok")
+			conv := conversation.NewConversation("", &conversation.SimpleTruncationStrategy{}, 10000)
+			conv.AddMessage("user", "first")
+			conv.AppendMessage(tt.assistant)
+			settings := types.Settings{DropEmptyAssistant: tt.drop}
+
+			if err := QueryHandler(context.Background(), conv, "second", srv.provider(), settings, &recordingRenderer{}); err != nil {
+				t.Fatal(err)
+			}
+			sent := requestMessages(t, srv.requests()[0])
+			if len(sent) != tt.wantSent {
+				t.Errorf("sent %d messages, want %d: %+v", len(sent), tt.wantSent, sent)
+			}
+			if history := conv.GetFullHistory(); len(history) != 4 || history[1].Role != "assistant" {
+				t.Errorf("history changed: %+v", history)
+			}
+		})
+	}
+}
+
+// rolePrefixes are the prefixes the role content prefix tests configure;
+// assistant messages have none.
+var rolePrefixes = map[string]string{"system": "[SYS]", "user": "/no_think"}
+
+func TestRoleContentPrefix(t *testing.T) {
+	srv := newChatServer(t, "ok")
+	provider := srv.provider()
+	provider.RoleContentPrefix = rolePrefixes
+	conv := conversation.NewConversation("Be terse.", &conversation.SimpleTruncationStrategy{}, 10000)
+	conv.AddMessage("user", "first")
+	conv.AddMessage("assistant", "reply")
+
+	if err := QueryHandler(context.Background(), conv, "second", provider, types.Settings{}, &recordingRenderer{}); err != nil {
+		t.Fatal(err)
+	}
+	var sent []string
+	for _, msg := range requestMessages(t, srv.requests()[0]) {
+		sent = append(sent, msg.Content)
+	}
+	if want := []string{"[SYS]\nBe terse.", "/no_think\nfirst", "reply", "/no_think\nsecond"}; strings.Join(sent, "|") != strings.Join(want, "|") {
+		t.Errorf("sent %q, want %q", sent, want)
+	}
+
+	var stored []string
+	for _, msg := range conv.GetFullHistory() {
+		stored = append(stored, msg.Content)
+	}
+	if want := []string{"first", "reply", "second", "ok"}; strings.Join(stored, "|") != strings.Join(want, "|") {
+		t.Errorf("history %q, want it unprefixed %q", stored, want)
+	}
+	if prompt := conv.GetSystemPrompt(); prompt != "Be terse." {
+		t.Errorf("system prompt stored as %q", prompt)
+	}
+}
+
+func TestAnthropicRoleContentPrefix(t *testing.T) {
+	provider := types.ModelProvider{UrlBase: "http://localhost", APIs: map[string]string{"chat": "/v1/messages"}, Model: "m", RoleContentPrefix: rolePrefixes}
+	messages := []types.Message{
+		{Role: "system", Content: "Be terse."},
+		{Role: "user", Content: "first"},
+		{Role: "assistant", Content: "reply"},
+		{Role: "user", Content: "second"},
+	}
+	req, err := anthropicProvider{}.BuildRequest(context.Background(), provider, types.Settings{}, messages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var payload types.AnthropicRequest
+	if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.System != "[SYS]\nBe terse." {
+		t.Errorf("system %q, want it prefixed", payload.System)
+	}
+	var sent []string
+	for _, msg := range payload.Messages {
+		sent = append(sent, msg.Content)
+	}
+	if want := []string{"/no_think\nfirst", "reply", "/no_think\nsecond"}; strings.Join(sent, "|") != strings.Join(want, "|") {
+		t.Errorf("sent %q, want %q", sent, want)
+	}
+	if messages[1].Content != "first" || messages[0].Content != "Be terse." {
+		t.Errorf("caller's messages were prefixed: %+v", messages)
+	}
+}
+
+func TestFileReferenceStorage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "main.go")
+	if err := os.WriteFile(path, []byte("package main\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	input := "explain @" + path
+	for _, storeExpanded := range []bool{false, true} {
+		t.Run(fmt.Sprintf("store expanded %v", storeExpanded), func(t *testing.T) {
+			srv := newChatServer(t, "ok")
+			conv := conversation.NewConversation("", &conversation.SimpleTruncationStrategy{}, 10000)
+			settings := types.Settings{ExpandFileRefs: true, StoreExpandedRefs: storeExpanded}
+			if err := QueryHandler(context.Background(), conv, input, srv.provider(), settings, &recordingRenderer{}); err != nil {
+				t.Fatal(err)
+			}
+
+			sent := requestMessages(t, srv.requests()[0])
+			if last := sent[len(sent)-1].Content; !strings.Contains(last, "package main") {
+				t.Errorf("file contents not sent: %q", last)
+			}
+			stored := conv.GetFullHistory()[0].Content
+			if expanded := strings.Contains(stored, "package main"); expanded != storeExpanded {
+				t.Errorf("stored %q; want it expanded: %v", stored, storeExpanded)
+			}
+		})
+	}
+}
+
+func TestOrganizationHeaders(t *testing.T) {
+	tests := []struct {
+		name         string
+		org, project string
+	}{
+		{name: "neither configured"},
+		{name: "organization only", org: "org-1"},
+		{name: "both", org: "org-1", project: "proj-1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newChatServer(t, "ok")
+			provider := srv.provider()
+			provider.OrgID, provider.ProjectID = tt.org, tt.project
+			conv := conversation.NewConversation("", &conversation.SimpleTruncationStrategy{}, 10000)
+			if err := QueryHandler(context.Background(), conv, "hi", provider, types.Settings{}, &recordingRenderer{}); err != nil {
+				t.Fatal(err)
+			}
+
+			header := srv.lastHeader()
+			want := map[string]string{"Openai-Organization": tt.org, "Openai-Project": tt.project}
+			for key, value := range want {
+				if _, present := header[key]; present != (value != "") || header.Get(key) != value {
+					t.Errorf("%s header is %q (present: %v), want %q", key, header.Get(key), present, value)
+				}
+			}
+		})
+	}
+}
+
+func TestDebugLogRedactsHeaders(t *testing.T) {
+	var logged strings.Builder
+	SetDebugLogger(log.New(&logged, "", 0))
+	defer SetDebugLogger(nil)
+
+	srv := newChatServer(t, "ok")
+	provider := srv.provider()
+	provider.OrgID, provider.ProjectID = "org-secret", "proj-secret"
+	conv := conversation.NewConversation("", &conversation.SimpleTruncationStrategy{}, 10000)
+	if err := QueryHandler(context.Background(), conv, "hi", provider, types.Settings{}, &recordingRenderer{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{provider.APIKey, "org-secret", "proj-secret"} {
+		if strings.Contains(logged.String(), secret) {
+			t.Errorf("debug log contains %q:\n%s", secret, logged.String())
+		}
+	}
+	if !strings.Contains(logged.String(), "Openai-Organization: REDACTED") {
+		t.Errorf("debug log does not show the redacted header:\n%s", logged.String())
+	}
+}
+
+func TestExtraHeaders(t *testing.T) {
+	extra := map[string]string{
+		"HTTP-Referer":   "https://example.com",
+		"api-version":    "2024-06-01",
+		"Content-Type":   "text/plain",  // Not replaced without "!"
+		"!Authorization": "Basic abc==", // Replaced on purpose
+	}
+	want := map[string]string{
+		"Http-Referer":  "https://example.com",
+		"Api-Version":   "2024-06-01",
+		"Authorization": "Basic abc==",
+	}
+
+	srv := newChatServer(t, "ok")
+	provider := srv.provider()
+	provider.ExtraHeaders = extra
+
+	chatReq, err := openAIProvider{}.BuildRequest(context.Background(), provider, types.Settings{}, []types.Message{{Role: "user", Content: "hi"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	listReq, err := NewModelListRequest(context.Background(), provider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, req := range map[string]*http.Request{"chat": chatReq, "model list": listReq} {
+		for key, value := range want {
+			if got := req.Header.Get(key); got != value {
+				t.Errorf("%s request: %s is %q, want %q", name, key, got, value)
+			}
+		}
+		if req.Header.Get("!Authorization") != "" {
+			t.Errorf("%s request: the \"!\" prefix was sent as part of the name", name)
+		}
+	}
+	if got := chatReq.Header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type replaced with %q", got)
+	}
+}
+
+func TestRequestPayloadStop(t *testing.T) {
+	tests := []struct {
+		name string
+		stop []string
+		want string // Expected "stop" member ("" for none)
+	}{
+		{name: "unset", stop: nil},
+		{name: "empty", stop: []string{}},
+		{name: "one", stop: []string{"###"}, want: `"stop":["###"]`},
+		{name: "several", stop: []string{"\n\n", "END"}, want: `"stop":["\n\n","END"]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := types.Settings{Sampling: types.SamplingParams{Stop: tt.stop}}
+			body, err := prepareRequestPayload(types.ModelProvider{Model: "m"}, settings, []types.Message{{Role: "user", Content: "hi"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.want == "" {
+				if strings.Contains(string(body), `"stop"`) {
+					t.Errorf("stop sent while unset: %s", body)
+				}
+			} else if !strings.Contains(string(body), tt.want) {
+				t.Errorf("body %s does not contain %s", body, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestPayloadSeed(t *testing.T) {
+	zero, answer := 0, 42
+	tests := []struct {
+		name string
+		seed *int
+		want string // Expected "seed" member ("" for none)
+	}{
+		{name: "unset", seed: nil},
+		{name: "zero", seed: &zero, want: `"seed":0`},
+		{name: "set", seed: &answer, want: `"seed":42`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := types.Settings{Sampling: types.SamplingParams{Seed: tt.seed}}
+			body, err := prepareRequestPayload(types.ModelProvider{Model: "m"}, settings, []types.Message{{Role: "user", Content: "hi"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.want == "" {
+				if strings.Contains(string(body), `"seed"`) {
+					t.Errorf("seed sent while unset: %s", body)
+				}
+			} else if !strings.Contains(string(body), tt.want) {
+				t.Errorf("body %s does not contain %s", body, tt.want)
+			}
+		})
+	}
+}
+
+func TestSystemFingerprintLogged(t *testing.T) {
+	var logged strings.Builder
+	SetDebugLogger(log.New(&logged, "", 0))
+	defer SetDebugLogger(nil)
+
+	chunk := func(fingerprint, content string) string {
+		return fmt.Sprintf("data: {\"system_fingerprint\":%q,\"choices\":[{\"index\":0,\"delta\":{\"content\":%q}}]}\n\n", fingerprint, content)
+	}
+	body := chunk("fp_1", "a") + chunk("fp_1", "b") + chunk("fp_2", "c") + "data: [DONE]\n\n"
+	if _, err := (openAIProvider{}).ParseStream(strings.NewReader(body), &recordingRenderer{}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := logged.String(), "system_fingerprint: fp_1\nsystem_fingerprint: fp_2\n"; got != want {
+		t.Errorf("logged %q, want %q", got, want)
+	}
+}
+
+func TestMultipleChoicesHistory(t *testing.T) {
+	srv := newChatServer(t, "")
+	srv.first = sseChoice(0, "Red") + sseChoice(1, "Green") + "data: [DONE]\n\n"
+	n := 2
+	settings := types.Settings{Sampling: types.SamplingParams{N: &n}}
+	conv := conversation.NewConversation("", &conversation.SimpleTruncationStrategy{}, 10000)
+	if err := QueryHandler(context.Background(), conv, "a colour?", srv.provider(), settings, &recordingRenderer{}); err != nil {
+		t.Fatal(err)
+	}
+	history := conv.GetFullHistory()
+	if len(history) != 2 || history[1].Role != "assistant" || history[1].Content != "Red" {
+		t.Errorf("history %+v, want the question and the first answer only", history)
+	}
+	if !strings.Contains(srv.requests()[0], `"n":2`) {
+		t.Errorf("request %s does not ask for 2 answers", srv.requests()[0])
+	}
+}
+
+func TestUserAgent(t *testing.T) {
+	tests := []struct {
+		name  string
+		agent string // USER_AGENT setting
+		want  string
+	}{
+		{name: "default", agent: "", want: "chatbot/" + version.Short()},
+		{name: "override", agent: "my-gateway-client/2.0", want: "my-gateway-client/2.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetUserAgent(tt.agent)
+			defer SetUserAgent("")
+
+			srv := newChatServer(t, "ok")
+			conv := conversation.NewConversation("", &conversation.SimpleTruncationStrategy{}, 10000)
+			if err := QueryHandler(context.Background(), conv, "hi", srv.provider(), types.Settings{}, &recordingRenderer{}); err != nil {
+				t.Fatal(err)
+			}
+			if got := srv.lastHeader().Get("User-Agent"); got != tt.want {
+				t.Errorf("chat request User-Agent %q, want %q", got, tt.want)
+			}
+			listReq, err := NewModelListRequest(context.Background(), srv.provider())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := listReq.Header.Get("User-Agent"); got != tt.want {
+				t.Errorf("model list request User-Agent %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONResponseFormat(t *testing.T) {
+	jsonFormat := &types.ResponseFormat{Type: "json_object"}
+	tests := []struct {
+		name     string
+		format   *types.ResponseFormat
+		reply    string
+		wantWarn bool
+	}{
+		{name: "valid JSON", format: jsonFormat, reply: `{"answer":42}`},
+		{name: "not JSON", format: jsonFormat, reply: "The answer is 42.", wantWarn: true},
+		{name: "no format", reply: "The answer is 42."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logged strings.Builder
+			log.SetOutput(&logged) // Quiet mode warns on the log
+			defer log.SetOutput(os.Stderr)
+
+			srv := newChatServer(t, tt.reply)
+			settings := types.Settings{Quiet: true, Sampling: types.SamplingParams{ResponseFormat: tt.format}}
+			conv := conversation.NewConversation("", &conversation.SimpleTruncationStrategy{}, 10000)
+			if err := QueryHandler(context.Background(), conv, "answer?", srv.provider(), settings, &recordingRenderer{}); err != nil {
+				t.Fatal(err)
+			}
+			sent := strings.Contains(srv.requests()[0], `"response_format":{"type":"json_object"}`)
+			if sent != (tt.format != nil) {
+				t.Errorf("request %s: response_format sent %v, want %v", srv.requests()[0], sent, tt.format != nil)
+			}
+			if warned := strings.Contains(logged.String(), "not valid JSON"); warned != tt.wantWarn {
+				t.Errorf("warned %v, want %v (log %q)", warned, tt.wantWarn, logged.String())
+			}
+		})
+	}
+}
+
+func TestSystemPromptTooLargeSendsNothing(t *testing.T) {
+	srv := newChatServer(t, "ok")
+	conv := conversation.NewConversation(strings.Repeat("always answer in great detail ", 100), &conversation.SimpleTruncationStrategy{}, 100)
+	conv.AddMessage("user", "earlier question")
+	conv.AddMessage("assistant", "earlier answer")
+
+	var renderer recordingRenderer
+	err := QueryHandler(context.Background(), conv, "hi", srv.provider(), types.Settings{}, &renderer)
+	if !errors.Is(err, conversation.ErrSystemPromptTooLarge) {
+		t.Fatalf("got %v, want ErrSystemPromptTooLarge", err)
+	}
+	if len(srv.requests()) != 0 {
+		t.Errorf("%d request(s) sent", len(srv.requests()))
+	}
+	if history := conv.GetFullHistory(); len(history) != 2 || history[1].Content != "earlier answer" {
+		t.Errorf("history %+v, want the new message rolled back", history)
+	}
+	if renderer.done != 1 {
+		t.Errorf("renderer told the turn ended %d time(s), want once", renderer.done)
+	}
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/henryhwang/chatbot/internal/conversation"
+	"github.com/henryhwang/chatbot/internal/types"
+)
+
+// stallingServer is a provider that streams chunk (or, when it is empty,
+// calls cancel before sending any headers) and then waits for the client to
+// go away.
+func stallingServer(t *testing.T, chunk string, cancel context.CancelFunc) types.ModelProvider {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		if chunk != "" {
+			w.Header().Set("Content-Type", "text/event-stream")
+			io.WriteString(w, sseChunk(chunk))
+			w.(http.Flusher).Flush()
+		} else {
+			cancel()
+		}
+		<-r.Context().Done()
+	}))
+	t.Cleanup(srv.Close)
+	return types.ModelProvider{UrlBase: srv.URL, APIs: map[string]string{"chat": "/chat"}, Model: "m", Format: "openai"}
+}
+
+// cancellingRenderer cancels the turn once content has been displayed, as
+// Ctrl-C during a stream would.
+type cancellingRenderer struct {
+	recordingRenderer
+	cancel context.CancelFunc
+}
+
+func (r *cancellingRenderer) OnContent(chunk string) {
+	r.recordingRenderer.OnContent(chunk)
+	r.cancel()
+}
+
+func TestCancelledTurnHistory(t *testing.T) {
+	tests := []struct {
+		name     string
+		onCancel string
+		chunk    string // Streamed before the cancel ("" to cancel while waiting for headers)
+		keepPart bool   // KEEP_PARTIAL_RESPONSE
+		want     []string
+	}{
+		{name: "rollback while waiting", onCancel: "rollback", want: []string{"user:earlier", "assistant:reply"}},
+		{name: "rollback mid-stream", onCancel: "rollback", chunk: "partial", want: []string{"user:earlier", "assistant:reply"}},
+		{name: "keep for retry while waiting", onCancel: "keep", want: []string{"user:earlier", "assistant:reply", "user:question"}},
+		{name: "keep for retry mid-stream", onCancel: "keep", chunk: "partial", want: []string{"user:earlier", "assistant:reply", "user:question"}},
+		{name: "partial response kept", onCancel: "rollback", chunk: "partial", keepPart: true, want: []string{"user:earlier", "assistant:reply", "user:question", "assistant:partial"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			provider := stallingServer(t, tt.chunk, cancel)
+			conv := conversation.NewConversation("", &conversation.SimpleTruncationStrategy{}, 10000)
+			conv.AddMessage("user", "earlier")
+			conv.AddMessage("assistant", "reply")
+			settings := types.Settings{OnCancel: tt.onCancel, KeepPartialResponse: tt.keepPart}
+
+			err := QueryHandler(ctx, conv, "question", provider, settings, &cancellingRenderer{cancel: cancel})
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("got error %v, want a cancellation", err)
+			}
+			var got []string
+			for _, msg := range conv.GetFullHistory() {
+				got = append(got, msg.Role+":"+msg.Content)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("history is %q, want %q", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("history is %q, want %q", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
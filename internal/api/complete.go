@@ -0,0 +1,101 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/henryhwang/chatbot/internal/conversation"
+	"github.com/henryhwang/chatbot/internal/types"
+)
+
+// --- Background Completions ---
+
+// Complete sends messages as a single request and returns the assistant's
+// whole reply once it has arrived, for callers that don't display it as it
+// comes (e.g. /compare or summarizing). The request is still streamed in the
+// provider's format, with nothing rendered. It does not touch any
+// conversation history.
+func Complete(ctx context.Context, provider types.ModelProvider, settings types.Settings, messages []types.Message) (string, error) {
+	result, _, err := complete(ctx, provider, settings, messages)
+	if err != nil {
+		return "", err
+	}
+	return result.Content, nil
+}
+
+// Timing measures one request from the moment it was sent.
+type Timing struct {
+	FirstChunk time.Duration // Until the first reasoning or content arrived
+	Total      time.Duration // Until the stream ended
+}
+
+// CompleteTimed is Complete, reporting how long the response took to start
+// and to finish instead of its content (e.g. for benchmarking a provider).
+func CompleteTimed(ctx context.Context, provider types.ModelProvider, settings types.Settings, messages []types.Message) (Timing, error) {
+	result, sent, err := complete(ctx, provider, settings, messages)
+	if err != nil {
+		return Timing{}, err
+	}
+	return Timing{FirstChunk: result.FirstChunkAt.Sub(sent), Total: result.CompletedAt.Sub(sent)}, nil
+}
+
+// complete sends messages as a single request and parses the reply, also
+// returning when the request was sent. A reply without content is an error.
+func complete(ctx context.Context, provider types.ModelProvider, settings types.Settings, messages []types.Message) (StreamResult, time.Time, error) {
+	format := ProviderFor(provider.Format)
+	req, err := format.BuildRequest(ctx, provider, settings, messages)
+	if err != nil {
+		return StreamResult{}, time.Time{}, fmt.Errorf("error preparing request: %w", err)
+	}
+
+	client, err := HTTPClient(settings)
+	if err != nil {
+		return StreamResult{}, time.Time{}, err
+	}
+	sent := time.Now()
+	resp, err := executeAPIRequest(ctx, client, settings, req)
+	if err != nil {
+		return StreamResult{}, sent, fmt.Errorf("error executing API request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	result, err := format.ParseStream(resp.Body, discardRenderer{})
+	if err != nil {
+		return result, sent, fmt.Errorf("error reading stream: %w", err)
+	}
+	if result.Content == "" {
+		return result, sent, fmt.Errorf("response contained no content")
+	}
+	return result, sent, nil
+}
+
+// discardRenderer ignores all output, for requests whose reply isn't displayed.
+type discardRenderer struct{}
+
+func (discardRenderer) OnStart()                          {}
+func (discardRenderer) OnToolCall(string, string, string) {}
+func (discardRenderer) OnReasoning(string)                {}
+func (discardRenderer) OnContent(string)                  {}
+func (discardRenderer) OnDone(StreamResult, error)        {}
+
+// Instruction given to the model when condensing older messages
+const summaryInstruction = "Summarize the following conversation concisely, preserving facts, decisions, " +
+	"requirements and code details that later messages may rely on. Reply with the summary only."
+
+// NewSummarizer returns a conversation.Summarizer that asks the active
+// provider (read from state at call time) to summarize messages.
+func NewSummarizer(state *types.RuntimeState) conversation.Summarizer {
+	return func(ctx context.Context, messages []types.Message) (string, error) {
+		var transcript strings.Builder
+		for _, msg := range messages {
+			fmt.Fprintf(&transcript, "%s: %s\n\n", msg.Role, msg.Content)
+		}
+		request := []types.Message{
+			{Role: "system", Content: summaryInstruction},
+			{Role: "user", Content: transcript.String()},
+		}
+		return Complete(ctx, state.Provider, state.Settings, request)
+	}
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// --- Debug Logging ---
+
+// debugLog receives full request and response payloads when debug mode is on.
+// It is nil (disabled) by default.
+var debugLog *log.Logger
+
+// SetDebugLogger enables logging of every request (headers with credentials
+// redacted, and the JSON body) and every raw response line to logger.
+// Passing nil disables it.
+func SetDebugLogger(logger *log.Logger) {
+	debugLog = logger
+}
+
+// debugTransport logs exchanges passing through to the next transport.
+type debugTransport struct {
+	next   http.RoundTripper
+	logger *log.Logger
+}
+
+// withDebugLogging wraps transport with payload logging if debug mode is on.
+func withDebugLogging(transport http.RoundTripper) http.RoundTripper {
+	if debugLog == nil {
+		return transport
+	}
+	return &debugTransport{next: transport, logger: debugLog}
+}
+
+func (t *debugTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.logger.Printf("> %s %s", req.Method, req.URL)
+	keys := make([]string, 0, len(req.Header))
+	for key := range req.Header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := req.Header.Get(key)
+		if sensitiveHeaders[http.CanonicalHeaderKey(key)] {
+			value = "REDACTED"
+		}
+		t.logger.Printf("> %s: %s", key, value)
+	}
+	if req.Body != nil {
+		body, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read request body for debug log: %w", err)
+		}
+		t.logger.Printf("> %s", body)
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		t.logger.Printf("< error: %v", err)
+		return nil, err
+	}
+	t.logger.Printf("< %s", resp.Status)
+
+	// Log each raw line as the caller reads it, so streaming isn't delayed
+	resp.Body = &debugBody{ReadCloser: resp.Body, logger: t.logger}
+	return resp, nil
+}
+
+// debugBody logs each complete line read from a response body.
+type debugBody struct {
+	io.ReadCloser
+	logger  *log.Logger
+	pending bytes.Buffer // Partial line awaiting its newline
+	once    sync.Once
+}
+
+func (b *debugBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.pending.Write(p[:n])
+	for {
+		line, readErr := b.pending.ReadString('\n')
+		if readErr != nil {
+			b.pending.WriteString(line) // Keep the partial line for the next read
+			break
+		}
+		b.logger.Printf("< %s", strings.TrimRight(line, "\r\n"))
+	}
+	return n, err
+}
+
+func (b *debugBody) Close() error {
+	b.once.Do(func() {
+		if b.pending.Len() > 0 {
+			b.logger.Printf("< %s", b.pending.String())
+		}
+	})
+	return b.ReadCloser.Close()
+}
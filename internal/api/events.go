@@ -0,0 +1,129 @@
+package api
+
+import (
+	"context"
+
+	"github.com/henryhwang/chatbot/internal/conversation"
+	"github.com/henryhwang/chatbot/internal/types"
+)
+
+// --- Streamed Turn Events ---
+
+// StreamEvent is one step of a turn as reported by Chat: one of the *Event
+// types below. A turn ends with exactly one DoneEvent or ErrorEvent.
+type StreamEvent interface {
+	streamEvent()
+}
+
+// StartEvent: a request was sent and no output has arrived yet (once per round).
+type StartEvent struct{}
+
+// ReasoningEvent carries a chunk of the model's reasoning.
+type ReasoningEvent struct {
+	Text string
+}
+
+// ContentEvent carries a chunk of the answer.
+type ContentEvent struct {
+	Text string
+}
+
+// ToolCallEvent reports a tool the model called, after it has run; another
+// round of the turn follows.
+type ToolCallEvent struct {
+	Call   types.ToolCall
+	Output string // What the tool returned (or "Error: ..." if it failed)
+}
+
+// NoticeEvent carries something worth telling the user, e.g. that older
+// messages were left out of the context.
+type NoticeEvent struct {
+	Text string
+}
+
+// WarningEvent describes a non-fatal problem, e.g. an unreadable @file reference.
+type WarningEvent struct {
+	Text string
+}
+
+// DoneEvent ends a successful turn with everything parsed from the stream,
+// including the token usage if the provider reported it.
+type DoneEvent struct {
+	StreamResult
+}
+
+// ErrorEvent ends a failed turn. Partial holds whatever arrived before the failure.
+type ErrorEvent struct {
+	Err     error
+	Partial StreamResult
+}
+
+func (StartEvent) streamEvent()     {}
+func (ReasoningEvent) streamEvent() {}
+func (ContentEvent) streamEvent()   {}
+func (ToolCallEvent) streamEvent()  {}
+func (NoticeEvent) streamEvent()    {}
+func (WarningEvent) streamEvent()   {}
+func (DoneEvent) streamEvent()      {}
+func (ErrorEvent) streamEvent()     {}
+
+// Chat sends input as the next user message of conv and streams the turn's
+// events on the returned channel instead of printing them. The channel is
+// closed exactly once, right after the final DoneEvent or ErrorEvent. The
+// conversation is updated like QueryHandler does and must not be used until
+// then. Cancelling ctx aborts the request and closes the channel promptly,
+// even if nobody is receiving any more.
+func Chat(ctx context.Context, conv *conversation.Conversation, input string, provider types.ModelProvider, settings types.Settings) <-chan StreamEvent {
+	events := make(chan StreamEvent)
+	go func() {
+		defer close(events)
+		runTurn(ctx, conv, input, provider, settings, &eventRenderer{ctx: ctx, events: events})
+	}()
+	return events
+}
+
+// eventRenderer is the OutputRenderer behind Chat: it forwards everything
+// the turn produces as events.
+type eventRenderer struct {
+	ctx    context.Context
+	events chan<- StreamEvent
+}
+
+// send delivers event, or drops it once ctx is cancelled and the receiver
+// has stopped listening, so the turn can always finish.
+func (r *eventRenderer) send(event StreamEvent) {
+	select {
+	case r.events <- event:
+		return
+	default:
+	}
+	select {
+	case r.events <- event:
+	case <-r.ctx.Done():
+	}
+}
+
+func (r *eventRenderer) OnStart() {
+	r.send(StartEvent{})
+}
+
+func (r *eventRenderer) OnReasoning(chunk string) {
+	r.send(ReasoningEvent{Text: chunk})
+}
+
+func (r *eventRenderer) OnContent(chunk string) {
+	r.send(ContentEvent{Text: chunk})
+}
+
+func (r *eventRenderer) OnToolCall(name, arguments, output string) {
+	call := types.ToolCall{Type: "function", Function: types.ToolCallFunction{Name: name, Arguments: arguments}}
+	r.send(ToolCallEvent{Call: call, Output: output})
+}
+
+func (r *eventRenderer) OnDone(result StreamResult, err error) {
+	if err != nil {
+		r.send(ErrorEvent{Err: err, Partial: result})
+		return
+	}
+	r.send(DoneEvent{StreamResult: result})
+}
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/henryhwang/chatbot/internal/conversation"
+	"github.com/henryhwang/chatbot/internal/types"
+)
+
+// drain receives every event until the channel closes, failing the test if
+// that takes longer than timeout.
+func drain(t *testing.T, events <-chan StreamEvent, timeout time.Duration) []StreamEvent {
+	t.Helper()
+	var got []StreamEvent
+	deadline := time.After(timeout)
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return got
+			}
+			got = append(got, event)
+		case <-deadline:
+			t.Fatalf("channel still open after %s (events so far: %v)", timeout, got)
+		}
+	}
+}
+
+// eventNames describes events by type, with their text where they carry one.
+func eventNames(events []StreamEvent) []string {
+	names := make([]string, len(events))
+	for i, event := range events {
+		switch event := event.(type) {
+		case StartEvent:
+			names[i] = "start"
+		case ReasoningEvent:
+			names[i] = "reasoning:" + event.Text
+		case ContentEvent:
+			names[i] = "content:" + event.Text
+		case DoneEvent:
+			names[i] = "done"
+		case ErrorEvent:
+			names[i] = "error"
+		default:
+			names[i] = fmt.Sprintf("%T", event)
+		}
+	}
+	return names
+}
+
+func TestChatEvents(t *testing.T) {
+	usage := `data: {"choices":[],"usage":{"prompt_tokens":5,"completion_tokens":2,"total_tokens":7}}` + "\n\n"
+	tests := []struct {
+		name   string
+		stream string // Raw reply stream ("" for a failing request)
+		want   []string
+	}{
+		{
+			name:   "canned stream",
+			stream: sseReasoning("hmm") + sseChunk("Hel") + sseChunk("lo") + usage + "data: [DONE]\n\n",
+			want:   []string{"start", "reasoning:hmm", "content:Hel", "content:lo", "done"},
+		},
+		{
+			name: "failed request",
+			want: []string{"start", "error"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newChatServer(t, "")
+			srv.first = tt.stream
+			if tt.stream == "" {
+				srv.status = http.StatusBadRequest
+			}
+			conv := conversation.NewConversation("", &conversation.SimpleTruncationStrategy{}, 10000)
+
+			events := drain(t, Chat(context.Background(), conv, "hi", srv.provider(), types.Settings{}), 5*time.Second)
+			if fmt.Sprint(eventNames(events)) != fmt.Sprint(tt.want) {
+				t.Fatalf("events %q, want %q", eventNames(events), tt.want)
+			}
+			switch last := events[len(events)-1].(type) {
+			case DoneEvent:
+				if last.Content != "Hello" || last.Usage == nil || last.Usage.TotalTokens != 7 {
+					t.Errorf("done event %+v, want the content and usage", last.StreamResult)
+				}
+			case ErrorEvent:
+				if last.Err == nil {
+					t.Error("error event without an error")
+				}
+			}
+		})
+	}
+}
+
+func TestChatCancelClosesChannel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	provider := stallingServer(t, "partial", func() {})
+	conv := conversation.NewConversation("", &conversation.SimpleTruncationStrategy{}, 10000)
+	events := Chat(ctx, conv, "hi", provider, types.Settings{})
+
+	// Stop listening after the first output, as a frontend closing a view would
+	for event := range events {
+		if _, ok := event.(ContentEvent); ok {
+			break
+		}
+	}
+	cancel()
+	time.Sleep(50 * time.Millisecond) // Let the turn finish with nobody receiving
+	got := drain(t, events, 2*time.Second)
+	for _, event := range got {
+		if _, ok := event.(DoneEvent); ok {
+			t.Errorf("a cancelled turn ended with DoneEvent: %q", eventNames(got))
+		}
+	}
+}
+
+func TestOversizedMessageNotice(t *testing.T) {
+	srv := newChatServer(t, "ok")
+	conv := conversation.NewConversation("", &conversation.SimpleTruncationStrategy{}, 50)
+	conv.AddMessage("user", "earlier question")
+	conv.AddMessage("assistant", "earlier answer")
+	huge := strings.Repeat("lorem ipsum dolor sit amet ", 50)
+
+	var notices []string
+	for _, event := range drain(t, Chat(context.Background(), conv, huge, srv.provider(), types.Settings{}), 5*time.Second) {
+		if notice, ok := event.(NoticeEvent); ok {
+			notices = append(notices, notice.Text)
+		}
+	}
+	if want := "your message alone is over the context limit"; len(notices) == 0 || !strings.Contains(strings.Join(notices, "\n"), want) {
+		t.Errorf("notices %q, want one saying %q", notices, want)
+	}
+	if sent := requestMessages(t, srv.requests()[0]); len(sent) != 1 || sent[0].Content != huge {
+		t.Errorf("sent %+v, want the oversized message alone", sent)
+	}
+}
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// --- Output Filter ---
+
+// How long after the filter exits or times out its output may stay open, e.g.
+// held by a background child of the shell, before it is closed regardless
+const filterWaitDelay = 500 * time.Millisecond
+
+// filterOutput pipes content through the configured shell command and returns
+// its stdout for display. On any failure (non-zero exit, timeout, empty output)
+// it logs a warning and returns the original content unchanged.
+func filterOutput(command string, content string, timeout time.Duration) string {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Stdin = strings.NewReader(content)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.WaitDelay = filterWaitDelay
+
+	// ErrWaitDelay means the command finished but a child it left running kept
+	// the output open; what it wrote is complete
+	if err := cmd.Run(); err != nil && !errors.Is(err, exec.ErrWaitDelay) {
+		if ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("timed out after %s", timeout)
+		}
+		log.Printf("Warning: Output filter failed (%v): %s", err, strings.TrimSpace(stderr.String()))
+		return content
+	}
+	if stdout.Len() == 0 {
+		log.Println("Warning: Output filter produced no output, showing original response.")
+		return content
+	}
+	return strings.TrimRight(stdout.String(), "\n")
+}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/henryhwang/chatbot/internal/conversation"
+	"github.com/henryhwang/chatbot/internal/types"
+)
+
+func TestFilterOutput(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		want    string
+	}{
+		{"transforms", "tr a-z A-Z", "HELLO WORLD"},
+		{"failure falls back", "echo oops >&2; exit 1", "hello world"},
+		{"empty output falls back", "true", "hello world"},
+		{"timeout falls back", "sleep 10; tr a-z A-Z", "hello world"},
+		{"background child holding stdout", "sleep 10 & tr a-z A-Z", "HELLO WORLD"},
+		{"timeout with a background child", "sleep 10 & sleep 10", "hello world"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			got := filterOutput(tt.command, "hello world", 200*time.Millisecond)
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("took %s, the timeout was not enforced", elapsed)
+			}
+		})
+	}
+}
+
+func TestOutputFilterDisplaysOnly(t *testing.T) {
+	srv := newChatServer(t, "hello there")
+	settings := types.Settings{BotPrefix: "Bot: ", OutputFilterCmd: "tr a-z A-Z", OutputFilterTimeout: 5 * time.Second}
+	conv := conversation.NewConversation("", &conversation.SimpleTruncationStrategy{}, 1000)
+	var out bytes.Buffer
+
+	if err := QueryHandler(context.Background(), conv, "hi", srv.provider(), settings, NewTerminalRenderer(&out, settings)); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "HELLO THERE") {
+		t.Errorf("displayed output %q is not filtered", out.String())
+	}
+	history := conv.GetFullHistory()
+	if last := history[len(history)-1]; last.Content != "hello there" {
+		t.Errorf("history has %q, want the original reply", last.Content)
+	}
+}
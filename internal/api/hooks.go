@@ -0,0 +1,28 @@
+package api
+
+import (
+	"fmt"
+
+	"github.com/henryhwang/chatbot/internal/types"
+)
+
+// --- Request Hooks ---
+
+// beforeRequest passes messages through each hook's BeforeRequest in turn,
+// stopping at the first error.
+func beforeRequest(hooks []types.Hook, messages []types.Message) ([]types.Message, error) {
+	for _, hook := range hooks {
+		var err error
+		if messages, err = hook.BeforeRequest(messages); err != nil {
+			return nil, fmt.Errorf("error in request hook: %w", err)
+		}
+	}
+	return messages, nil
+}
+
+// afterResponse tells every hook how the request went.
+func afterResponse(hooks []types.Hook, result StreamResult, err error) {
+	for _, hook := range hooks {
+		hook.AfterResponse(result.Content, result.Usage, err)
+	}
+}
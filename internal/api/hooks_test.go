@@ -0,0 +1,113 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/henryhwang/chatbot/internal/conversation"
+	"github.com/henryhwang/chatbot/internal/tools"
+	"github.com/henryhwang/chatbot/internal/types"
+)
+
+// hookLog records what the hooks of a turn saw.
+type hookLog struct {
+	before int
+	after  []string // Content, or "error" for a failed request
+}
+
+// redactingHook replaces "secret" in every message it is given, editing the
+// messages in place, and records each call in log.
+func redactingHook(log *hookLog) types.Hook {
+	return types.HookFuncs{
+		Before: func(messages []types.Message) ([]types.Message, error) {
+			log.before++
+			for i := range messages {
+				messages[i].Content = strings.ReplaceAll(messages[i].Content, "secret", "[redacted]")
+			}
+			return messages, nil
+		},
+		After: func(content string, usage *types.UsageInfo, err error) {
+			if err != nil {
+				content = "error"
+			}
+			log.after = append(log.after, content)
+		},
+	}
+}
+
+// toolCall is a stream whose reply calls the current-time tool.
+const toolCall = `data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"get_current_time","arguments":"{}"}}]}}]}` +
+	"\n\ndata: [DONE]\n\n"
+
+func TestHooks(t *testing.T) {
+	registry := tools.NewRegistry()
+	if err := registry.Register(tools.CurrentTime()); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		server    func(t *testing.T) *chatServer
+		rounds    int
+		wantAfter []string
+	}{
+		{
+			name:      "one round",
+			server:    func(t *testing.T) *chatServer { return newChatServer(t, "ok") },
+			rounds:    1,
+			wantAfter: []string{"ok"},
+		},
+		{
+			name: "tool round",
+			server: func(t *testing.T) *chatServer {
+				s := newChatServer(t, "it is noon")
+				s.first = toolCall
+				return s
+			},
+			rounds:    2,
+			wantAfter: []string{"", "it is noon"},
+		},
+		{
+			name: "failed request",
+			server: func(t *testing.T) *chatServer {
+				s := newChatServer(t, "ok")
+				s.status = http.StatusBadRequest
+				return s
+			},
+			rounds:    1,
+			wantAfter: []string{"error"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetToolRegistry(registry)
+			defer SetToolRegistry(nil)
+			srv := tt.server(t)
+			var log hookLog
+			settings := types.Settings{Hooks: []types.Hook{redactingHook(&log)}}
+			conv := conversation.NewConversation("", &conversation.SimpleTruncationStrategy{}, 10000)
+
+			for range Chat(context.Background(), conv, "my secret is 42", srv.provider(), settings) {
+			}
+
+			if log.before != tt.rounds || fmt.Sprint(log.after) != fmt.Sprint(tt.wantAfter) {
+				t.Errorf("BeforeRequest ran %d times and AfterResponse saw %q, want %d rounds and %q", log.before, log.after, tt.rounds, tt.wantAfter)
+			}
+			requests := srv.requests()
+			if len(requests) != tt.rounds {
+				t.Fatalf("got %d requests, want %d", len(requests), tt.rounds)
+			}
+			for _, body := range requests {
+				if strings.Contains(body, "secret") || !strings.Contains(body, "[redacted]") {
+					t.Errorf("request body not redacted: %s", body)
+				}
+			}
+			if history := conv.GetFullHistory(); len(history) == 0 || history[0].Content != "my secret is 42" {
+				t.Errorf("the hook changed the history: %+v", history)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package api
+
+import (
+	"os"
+	"regexp"
+	"strings"
+)
+
+// --- Markdown Code Block Rendering ---
+
+// ANSI escape sequences used for code highlighting
+const (
+	ansiReset   = "\033[0m"
+	ansiDim     = "\033[2m"
+	ansiCyan    = "\033[36m"
+	ansiGreen   = "\033[32m"
+	ansiMagenta = "\033[35m"
+
+	ansiBoldGreen = "\033[1;32m"
+	ansiBoldCyan  = "\033[1;36m"
+)
+
+// Keywords highlighted inside code blocks (a union across common languages)
+var codeKeywords = regexp.MustCompile(`\b(func|package|import|return|if|else|for|range|switch|case|default|break|continue|go|defer|select|chan|map|struct|interface|type|var|const|def|class|from|as|with|try|except|finally|raise|lambda|yield|function|let|new|this|async|await|throw|catch|fn|impl|pub|use|mut|match|while|do|in|not|and|or|nil|null|None|true|false|True|False)\b`)
+
+// String literals highlighted inside code blocks
+var codeStrings = regexp.MustCompile("\"(?:[^\"\\\\]|\\\\.)*\"|'(?:[^'\\\\]|\\\\.)*'|`[^`]*`")
+
+// MarkdownRenderer wraps a TerminalRenderer and highlights fenced code blocks
+// in the content. Because content arrives in arbitrary chunks, it holds back
+// text only while it can't yet tell whether a line is a fence, and buffers
+// whole lines inside code blocks so they can be highlighted.
+type MarkdownRenderer struct {
+	*TerminalRenderer
+
+	inCode      bool
+	pending     string // Start of the current line, held until it can be classified
+	lineStarted bool   // Part of the current line was already printed (so it isn't a fence)
+}
+
+// NewMarkdownRenderer wraps terminal with code block highlighting.
+func NewMarkdownRenderer(terminal *TerminalRenderer) *MarkdownRenderer {
+	return &MarkdownRenderer{TerminalRenderer: terminal}
+}
+
+func (m *MarkdownRenderer) OnContent(chunk string) {
+	m.pending += chunk
+	for {
+		newline := strings.IndexByte(m.pending, '\n')
+		if newline < 0 {
+			break
+		}
+		line := m.pending[:newline]
+		m.pending = m.pending[newline+1:]
+		m.emitLine(line, true)
+	}
+
+	// Outside code, a partial line can be printed as soon as it can't be a fence
+	if !m.inCode && m.pending != "" && !couldBeFence(m.pending) {
+		m.TerminalRenderer.OnContent(m.pending)
+		m.pending = ""
+		m.lineStarted = true
+	}
+}
+
+func (m *MarkdownRenderer) OnDone(result StreamResult, err error) {
+	if m.pending != "" {
+		m.emitLine(m.pending, false)
+		m.pending = ""
+	}
+	if m.inCode {
+		m.TerminalRenderer.OnContent(ansiReset)
+		m.inCode = false
+	}
+	m.TerminalRenderer.OnDone(result, err)
+}
+
+// emitLine renders one (possibly final, unterminated) line of content.
+func (m *MarkdownRenderer) emitLine(line string, newline bool) {
+	end := ""
+	if newline {
+		end = "\n"
+	}
+	continued := m.lineStarted
+	m.lineStarted = false
+
+	if !continued && isFence(line) {
+		m.inCode = !m.inCode
+		m.TerminalRenderer.OnContent(ansiDim + line + ansiReset + end)
+		return
+	}
+	if m.inCode {
+		m.TerminalRenderer.OnContent(highlightCode(line) + end)
+		return
+	}
+	m.TerminalRenderer.OnContent(line + end)
+}
+
+// isFence reports whether line opens or closes a fenced code block.
+func isFence(line string) bool {
+	return strings.HasPrefix(strings.TrimSpace(line), "```")
+}
+
+// couldBeFence reports whether a partial line might still turn out to be a fence.
+func couldBeFence(partial string) bool {
+	trimmed := strings.TrimLeft(partial, " \t")
+	if len(trimmed) < 3 {
+		return strings.HasPrefix("```", trimmed)
+	}
+	return strings.HasPrefix(trimmed, "```")
+}
+
+// highlightCode colours one line of code: comments dim, strings green,
+// keywords magenta and everything else cyan.
+func highlightCode(line string) string {
+	trimmed := strings.TrimSpace(line)
+	if strings.HasPrefix(trimmed, "//") || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, "--") {
+		return ansiDim + line + ansiReset
+	}
+
+	var out strings.Builder
+	last := 0
+	for _, loc := range codeStrings.FindAllStringIndex(line, -1) {
+		out.WriteString(highlightKeywords(line[last:loc[0]]))
+		out.WriteString(ansiGreen + line[loc[0]:loc[1]] + ansiReset)
+		last = loc[1]
+	}
+	out.WriteString(highlightKeywords(line[last:]))
+	return out.String()
+}
+
+// highlightKeywords colours keywords in a code fragment containing no strings.
+func highlightKeywords(fragment string) string {
+	if fragment == "" {
+		return ""
+	}
+	colored := codeKeywords.ReplaceAllString(fragment, ansiMagenta+"$1"+ansiCyan)
+	return ansiCyan + colored + ansiReset
+}
+
+// isTerminal reports whether f is an interactive terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
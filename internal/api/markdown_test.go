@@ -0,0 +1,85 @@
+package api
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/henryhwang/chatbot/internal/types"
+)
+
+// renderMarkdown returns the output of a quiet MarkdownRenderer given chunks.
+func renderMarkdown(chunks []string) string {
+	var out bytes.Buffer
+	renderer := NewMarkdownRenderer(NewTerminalRenderer(&out, types.Settings{Quiet: true}))
+	renderer.OnStart()
+	for _, chunk := range chunks {
+		renderer.OnContent(chunk)
+	}
+	renderer.OnDone(StreamResult{Content: strings.Join(chunks, "")}, nil)
+	return out.String()
+}
+
+func TestMarkdownFencesAcrossChunks(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		want    []string // Substrings of the output
+		notWant []string
+	}{
+		{
+			name: "code block",
+			text: "Intro\n```go\nfunc main() {}\n```\nbye",
+			want: []string{"Intro\n", ansiDim + "```go" + ansiReset + "\n", ansiMagenta + "func" + ansiCyan, ansiDim + "```" + ansiReset + "\n", "bye"},
+		},
+		{
+			name:    "backticks mid-line are not a fence",
+			text:    "Use ```x``` here\nplain",
+			want:    []string{"Use ```x``` here\nplain"},
+			notWant: []string{ansiDim, ansiCyan},
+		},
+		{
+			name: "unterminated block is reset",
+			text: "```\nreturn 1",
+			want: []string{ansiMagenta + "return" + ansiCyan, ansiReset},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			whole := renderMarkdown([]string{tt.text})
+			for _, want := range tt.want {
+				if !strings.Contains(whole, want) {
+					t.Errorf("output %q does not contain %q", whole, want)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(whole, notWant) {
+					t.Errorf("output %q contains %q", whole, notWant)
+				}
+			}
+
+			// Every way of splitting the text in two or three chunks renders the same
+			for i := 1; i < len(tt.text); i++ {
+				if got := renderMarkdown([]string{tt.text[:i], tt.text[i:]}); got != whole {
+					t.Fatalf("split at %d: got %q, want %q", i, got, whole)
+				}
+				for j := i + 1; j < len(tt.text); j++ {
+					if got := renderMarkdown([]string{tt.text[:i], tt.text[i:j], tt.text[j:]}); got != whole {
+						t.Fatalf("split at %d and %d: got %q, want %q", i, j, got, whole)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestMarkdownNeedsTerminal(t *testing.T) {
+	if isTerminal(os.Stdout) {
+		t.Skip("stdout is a terminal")
+	}
+	renderer := NewRenderer(types.ModelProvider{}, types.Settings{RenderMarkdown: true})
+	if _, plain := renderer.(*TerminalRenderer); !plain {
+		t.Errorf("got %T for redirected stdout, want plain *TerminalRenderer", renderer)
+	}
+}
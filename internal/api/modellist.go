@@ -0,0 +1,41 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/henryhwang/chatbot/internal/types"
+)
+
+// --- Model List Endpoint ---
+
+// DefaultModelsPath is the model list endpoint used when APIS defines none.
+const DefaultModelsPath = "/v1/models"
+
+// ModelListPath returns the model list endpoint from the provider's APIS,
+// keyed "list" or, as the configuration examples write it, "models"
+// (models:/v1/models). ok is false when neither is set and the default is used.
+func ModelListPath(provider types.ModelProvider) (path string, ok bool) {
+	for _, key := range []string{"list", "models"} {
+		if path, ok := provider.APIs[key]; ok {
+			return path, true
+		}
+	}
+	return DefaultModelsPath, false
+}
+
+// NewModelListRequest builds the GET request for the provider's model list,
+// with its credentials and extra headers.
+func NewModelListRequest(ctx context.Context, provider types.ModelProvider) (*http.Request, error) {
+	path, _ := ModelListPath(provider)
+	req, err := http.NewRequestWithContext(ctx, "GET", provider.UrlBase+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Authorization", "Bearer "+provider.APIKey)
+	req.Header.Set("User-Agent", UserAgent())
+	SetExtraHeaders(req, provider) // Gateways need the same extra headers here
+	// Some APIs might require Content-Type even for GET
+	// req.Header.Add("Content-Type", "application/json")
+	return req, nil
+}
@@ -0,0 +1,39 @@
+package api
+
+import (
+	"context"
+	"testing"
+
+	"github.com/henryhwang/chatbot/internal/types"
+)
+
+func TestModelListPath(t *testing.T) {
+	tests := []struct {
+		name   string
+		apis   map[string]string
+		want   string
+		wantOk bool
+	}{
+		{name: "list key", apis: map[string]string{"chat": "/chat", "list": "/list-models"}, want: "/list-models", wantOk: true},
+		{name: "models key", apis: map[string]string{"chat": "/chat", "models": "/api/tags"}, want: "/api/tags", wantOk: true},
+		{name: "list preferred", apis: map[string]string{"list": "/a", "models": "/b"}, want: "/a", wantOk: true},
+		{name: "default", apis: map[string]string{"chat": "/chat"}, want: DefaultModelsPath},
+		{name: "no apis", want: DefaultModelsPath},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := types.ModelProvider{UrlBase: "http://localhost:8080", APIs: tt.apis}
+			path, ok := ModelListPath(provider)
+			if path != tt.want || ok != tt.wantOk {
+				t.Errorf("got (%q, %v), want (%q, %v)", path, ok, tt.want, tt.wantOk)
+			}
+			req, err := NewModelListRequest(context.Background(), provider)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := req.URL.String(); got != provider.UrlBase+tt.want {
+				t.Errorf("request URL %q, want %q", got, provider.UrlBase+tt.want)
+			}
+		})
+	}
+}
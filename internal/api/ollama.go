@@ -0,0 +1,71 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/henryhwang/chatbot/internal/types"
+)
+
+// --- Ollama Native API ---
+
+// ollamaProvider speaks Ollama's native chat API (e.g. APIS=chat:/api/chat),
+// which streams newline-delimited JSON rather than SSE.
+type ollamaProvider struct{}
+
+func (ollamaProvider) BuildRequest(ctx context.Context, provider types.ModelProvider, settings types.Settings, messages []types.Message) (*http.Request, error) {
+	if settings.DropEmptyAssistant {
+		messages = dropEmptyAssistant(messages)
+	}
+	requestPayload := types.OllamaRequest{
+		Model:    provider.Model,
+		Messages: applyRoleContentPrefix(messages, provider.RoleContentPrefix),
+		Stream:   true,
+	}
+	sampling := settings.Sampling
+	if sampling.Temperature != nil || sampling.TopP != nil || sampling.MaxTokens != nil || sampling.PresencePenalty != nil || len(sampling.Stop) > 0 || sampling.Seed != nil {
+		requestPayload.Options = &types.OllamaOptions{
+			Temperature:     sampling.Temperature,
+			TopP:            sampling.TopP,
+			NumPredict:      sampling.MaxTokens,
+			PresencePenalty: sampling.PresencePenalty,
+			Stop:            sampling.Stop,
+			Seed:            sampling.Seed,
+		}
+	}
+	if format := sampling.ResponseFormat; format != nil {
+		if format.JSONSchema != nil {
+			requestPayload.Format = format.JSONSchema.Schema
+		} else {
+			requestPayload.Format = json.RawMessage(`"json"`)
+		}
+	}
+
+	requestBody, err := json.Marshal(requestPayload)
+	if err != nil {
+		return nil, fmt.Errorf("error preparing request payload: %w", err)
+	}
+	return ollamaProvider{}.NewRequest(ctx, provider, requestBody)
+}
+
+func (ollamaProvider) NewRequest(ctx context.Context, provider types.ModelProvider, body []byte) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", provider.UrlBase+provider.APIs["chat"], bytes.NewBuffer(body))
+	if err != nil {
+		return nil, fmt.Errorf("error creating HTTP request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if provider.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+provider.APIKey) // For Ollama behind an authenticating proxy
+	}
+	req.Header.Set("User-Agent", UserAgent())
+	SetExtraHeaders(req, provider)
+	return req, nil
+}
+
+func (ollamaProvider) ParseStream(body io.Reader, renderer OutputRenderer) (StreamResult, error) {
+	return parseStream(body, ollamaStream(), renderer)
+}
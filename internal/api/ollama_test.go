@@ -0,0 +1,106 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/henryhwang/chatbot/internal/types"
+)
+
+func TestOllamaParseStream(t *testing.T) {
+	tests := []struct {
+		name          string
+		body          string
+		wantContent   string
+		wantReasoning string
+		wantUsage     *types.UsageInfo
+		wantErr       string
+	}{
+		{
+			name: "content and done",
+			body: `{"message":{"role":"assistant","content":"Hel"},"done":false}` + "\n" +
+				`{"message":{"role":"assistant","content":"lo"},"done":false}` + "\n" +
+				`{"message":{"role":"assistant","content":""},"done":true,"prompt_eval_count":12,"eval_count":3}` + "\n",
+			wantContent: "Hello",
+			wantUsage:   &types.UsageInfo{PromptTokens: 12, CompletionTokens: 3, TotalTokens: 15},
+		},
+		{
+			name: "thinking",
+			body: `{"message":{"role":"assistant","thinking":"hmm"},"done":false}` + "\n" +
+				`{"message":{"role":"assistant","content":"yes"},"done":true}`,
+			wantContent:   "yes",
+			wantReasoning: "hmm",
+			wantUsage:     &types.UsageInfo{},
+		},
+		{
+			name:    "error line",
+			body:    `{"error":"model 'x' not found"}` + "\n",
+			wantErr: "model 'x' not found",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var renderer recordingRenderer
+			result, err := ollamaProvider{}.ParseStream(strings.NewReader(tt.body), &renderer)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one mentioning %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Content != tt.wantContent || renderer.content.String() != tt.wantContent {
+				t.Errorf("content %q (rendered %q), want %q", result.Content, renderer.content.String(), tt.wantContent)
+			}
+			if result.Reasoning != tt.wantReasoning {
+				t.Errorf("reasoning %q, want %q", result.Reasoning, tt.wantReasoning)
+			}
+			if (result.Usage == nil) != (tt.wantUsage == nil) || (result.Usage != nil && *result.Usage != *tt.wantUsage) {
+				t.Errorf("usage %+v, want %+v", result.Usage, tt.wantUsage)
+			}
+		})
+	}
+}
+
+func TestOllamaBuildRequest(t *testing.T) {
+	temperature := 0.2
+	provider := types.ModelProvider{UrlBase: "http://localhost:11434", APIs: map[string]string{"chat": "/api/chat"}, Model: "llama3", Format: "ollama"}
+	tests := []struct {
+		name        string
+		sampling    types.SamplingParams
+		wantOptions bool
+	}{
+		{name: "defaults omit options"},
+		{name: "sampling goes in options", sampling: types.SamplingParams{Temperature: &temperature}, wantOptions: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := ollamaProvider{}.BuildRequest(context.Background(), provider, types.Settings{Sampling: tt.sampling}, []types.Message{{Role: "user", Content: "hi"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if req.URL.String() != "http://localhost:11434/api/chat" {
+				t.Errorf("URL %s", req.URL)
+			}
+			body, _ := io.ReadAll(req.Body)
+			var payload types.OllamaRequest
+			if err := json.Unmarshal(body, &payload); err != nil {
+				t.Fatal(err)
+			}
+			if payload.Model != "llama3" || !payload.Stream || len(payload.Messages) != 1 {
+				t.Errorf("payload %s", body)
+			}
+			if (payload.Options != nil) != tt.wantOptions {
+				t.Errorf("options %+v, want present: %v", payload.Options, tt.wantOptions)
+			}
+			if tt.wantOptions && *payload.Options.Temperature != temperature {
+				t.Errorf("temperature %v, want %v", *payload.Options.Temperature, temperature)
+			}
+		})
+	}
+}
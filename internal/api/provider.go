@@ -0,0 +1,87 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/henryhwang/chatbot/internal/types"
+)
+
+// --- Provider API Formats ---
+
+// Provider adapts one vendor's chat API: how a streaming request is built and
+// how the streamed response is parsed. The format is chosen per provider by
+// PROVIDER_FORMAT (see ProviderFor).
+type Provider interface {
+	// BuildRequest creates a streaming chat request for messages, bound to ctx.
+	BuildRequest(ctx context.Context, provider types.ModelProvider, settings types.Settings, messages []types.Message) (*http.Request, error)
+	// NewRequest creates the request posting body to the chat endpoint, with
+	// the format's authentication and headers; BuildRequest uses it too.
+	NewRequest(ctx context.Context, provider types.ModelProvider, body []byte) (*http.Request, error)
+	// ParseStream reads the streamed response, passing chunks to renderer as they arrive.
+	ParseStream(body io.Reader, renderer OutputRenderer) (StreamResult, error)
+}
+
+// ProviderFor returns the implementation of the named API format. The
+// OpenAI-compatible format is the default.
+func ProviderFor(format string) Provider {
+	switch format {
+	case "anthropic":
+		return anthropicProvider{}
+	case "ollama":
+		return ollamaProvider{}
+	case "azure":
+		return azureProvider{}
+	default:
+		return openAIProvider{}
+	}
+}
+
+// openAIProvider speaks the OpenAI-compatible chat completions API.
+type openAIProvider struct{}
+
+func (openAIProvider) BuildRequest(ctx context.Context, provider types.ModelProvider, settings types.Settings, messages []types.Message) (*http.Request, error) {
+	requestBody, err := prepareRequestPayload(provider, settings, messages)
+	if err != nil {
+		return nil, fmt.Errorf("error preparing request payload: %w", err)
+	}
+	return openAIProvider{}.NewRequest(ctx, provider, requestBody)
+}
+
+func (openAIProvider) NewRequest(ctx context.Context, provider types.ModelProvider, body []byte) (*http.Request, error) {
+	return prepareRequest(ctx, provider.UrlBase+provider.APIs["chat"], body, provider) // Ensure "chat" key exists in APIS map
+}
+
+func (openAIProvider) ParseStream(body io.Reader, renderer OutputRenderer) (StreamResult, error) {
+	return parseStream(body, openAIStream(), renderer)
+}
+
+// azureProvider speaks Azure OpenAI: the OpenAI request and stream format,
+// addressed by deployment rather than model and authenticated with an
+// api-key header (see prepareRequest).
+type azureProvider struct{ openAIProvider }
+
+func (azureProvider) BuildRequest(ctx context.Context, provider types.ModelProvider, settings types.Settings, messages []types.Message) (*http.Request, error) {
+	requestBody, err := prepareRequestPayload(provider, settings, messages)
+	if err != nil {
+		return nil, fmt.Errorf("error preparing request payload: %w", err)
+	}
+	return azureProvider{}.NewRequest(ctx, provider, requestBody)
+}
+
+func (azureProvider) NewRequest(ctx context.Context, provider types.ModelProvider, body []byte) (*http.Request, error) {
+	return prepareRequest(ctx, azureChatURL(provider), body, provider)
+}
+
+// azureChatURL returns {base}/openai/deployments/{deployment}/chat/completions
+// with the api-version query parameter. A "chat" entry in APIS replaces the path.
+func azureChatURL(provider types.ModelProvider) string {
+	path, ok := provider.APIs["chat"]
+	if !ok {
+		path = "/openai/deployments/" + url.PathEscape(provider.Deployment) + "/chat/completions"
+	}
+	return provider.UrlBase + path + "?api-version=" + url.QueryEscape(provider.APIVersion)
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"context"
+	"testing"
+
+	"github.com/henryhwang/chatbot/internal/types"
+)
+
+func TestAzureRequest(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider types.ModelProvider
+		wantURL  string
+	}{
+		{
+			name:     "deployment URL",
+			provider: types.ModelProvider{UrlBase: "https://res.openai.azure.com", Deployment: "gpt4o-prod", APIVersion: "2024-06-01"},
+			wantURL:  "https://res.openai.azure.com/openai/deployments/gpt4o-prod/chat/completions?api-version=2024-06-01",
+		},
+		{
+			name:     "deployment name escaped",
+			provider: types.ModelProvider{UrlBase: "https://res.openai.azure.com", Deployment: "my deployment", APIVersion: "2024-06-01"},
+			wantURL:  "https://res.openai.azure.com/openai/deployments/my%20deployment/chat/completions?api-version=2024-06-01",
+		},
+		{
+			name:     "APIS path replaces the deployment path",
+			provider: types.ModelProvider{UrlBase: "https://gw.example.com", APIs: map[string]string{"chat": "/azure/chat"}, Deployment: "d", APIVersion: "2024-06-01"},
+			wantURL:  "https://gw.example.com/azure/chat?api-version=2024-06-01",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := tt.provider
+			provider.Format, provider.APIKey, provider.Model = "azure", "azure-key", "gpt-4o"
+			req, err := ProviderFor("azure").BuildRequest(context.Background(), provider, types.Settings{}, []types.Message{{Role: "user", Content: "hi"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if req.URL.String() != tt.wantURL {
+				t.Errorf("URL %s, want %s", req.URL, tt.wantURL)
+			}
+			if got := req.Header.Get("Api-Key"); got != "azure-key" {
+				t.Errorf("api-key header %q, want the key", got)
+			}
+			if got := req.Header.Get("Authorization"); got != "" {
+				t.Errorf("Authorization header %q sent to Azure", got)
+			}
+		})
+	}
+}
+
+func TestOpenAIRequestUnchanged(t *testing.T) {
+	provider := types.ModelProvider{UrlBase: "https://api.openai.com", APIs: map[string]string{"chat": "/v1/chat/completions"}, APIKey: "sk-key", Model: "gpt-4o", Format: "openai"}
+	req, err := ProviderFor("openai").BuildRequest(context.Background(), provider, types.Settings{}, []types.Message{{Role: "user", Content: "hi"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.URL.String() != "https://api.openai.com/v1/chat/completions" {
+		t.Errorf("URL %s", req.URL)
+	}
+	if req.Header.Get("Authorization") != "Bearer sk-key" || req.Header.Get("Api-Key") != "" {
+		t.Errorf("headers %v, want bearer auth only", req.Header)
+	}
+}
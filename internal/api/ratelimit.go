@@ -0,0 +1,47 @@
+package api
+
+import (
+	"net/http"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// --- Client-Side Rate Limiting ---
+
+// Limiters by requests per second, shared by every client with that rate so
+// the limit holds across chat, completion and model-list requests
+var (
+	limiterMu sync.Mutex
+	limiters  = map[float64]*rate.Limiter{}
+)
+
+// rateLimitedTransport waits for the limiter before each request, so bursts
+// are spread out instead of tripping the provider's quota.
+type rateLimitedTransport struct {
+	next    http.RoundTripper
+	limiter *rate.Limiter
+}
+
+func (t *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.limiter.Wait(req.Context()); err != nil {
+		return nil, err // Cancelled (or the deadline is too close) while waiting
+	}
+	return t.next.RoundTrip(req)
+}
+
+// withRateLimit limits requests through transport to rps per second, with no
+// bursts beyond a single request. An rps of 0 or less disables the limit.
+func withRateLimit(transport http.RoundTripper, rps float64) http.RoundTripper {
+	if rps <= 0 {
+		return transport
+	}
+	limiterMu.Lock()
+	defer limiterMu.Unlock()
+	limiter, ok := limiters[rps]
+	if !ok {
+		limiter = rate.NewLimiter(rate.Limit(rps), 1)
+		limiters[rps] = limiter
+	}
+	return &rateLimitedTransport{next: transport, limiter: limiter}
+}
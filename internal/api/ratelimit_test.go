@@ -0,0 +1,67 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// roundTripFunc adapts a function to http.RoundTripper.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+// okTransport answers every request with an empty 200 response.
+var okTransport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+})
+
+func TestRateLimitSpacesRequests(t *testing.T) {
+	const rps = 20 // Not used by other tests, so the shared limiter starts full
+	transport := withRateLimit(okTransport, rps)
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+		if _, err := transport.RoundTrip(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The first request goes at once, each later one a 1/rps interval after
+	if elapsed, want := time.Since(start), 4*time.Second/rps; elapsed < want-10*time.Millisecond {
+		t.Errorf("5 requests took %v, want at least %v", elapsed, want)
+	}
+}
+
+func TestRateLimitWaitHonoursCancel(t *testing.T) {
+	transport := withRateLimit(okTransport, 0.5) // One request every 2s
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	if _, err := transport.RoundTrip(req); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	req, _ = http.NewRequestWithContext(ctx, http.MethodGet, "http://example.com", nil)
+	_, err := transport.RoundTrip(req)
+	if err == nil {
+		t.Fatal("request sent despite cancellation")
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("cancelled wait took %v", elapsed)
+	}
+}
+
+func TestRateLimitDisabled(t *testing.T) {
+	for _, rps := range []float64{0, -1} {
+		if _, limited := withRateLimit(okTransport, rps).(*rateLimitedTransport); limited {
+			t.Errorf("rps %g: transport was wrapped", rps)
+		}
+	}
+}
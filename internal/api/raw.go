@@ -0,0 +1,59 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/henryhwang/chatbot/internal/types"
+)
+
+// --- Raw Requests ---
+
+// SendRaw posts body, a complete request payload, to the provider's chat
+// endpoint as is, with the usual authentication, headers and retries, and
+// reports the response to renderer like a turn. No conversation is involved.
+// A response that isn't streamed (e.g. for "stream": false) is passed on as
+// content in one piece, indented if it is JSON.
+func SendRaw(ctx context.Context, provider types.ModelProvider, settings types.Settings, body []byte, renderer OutputRenderer) error {
+	format := ProviderFor(provider.Format)
+	client, err := HTTPClient(settings)
+	if err != nil {
+		renderer.OnDone(StreamResult{}, err)
+		return err
+	}
+	req, err := format.NewRequest(ctx, provider, body)
+	if err != nil {
+		renderer.OnDone(StreamResult{}, err)
+		return err
+	}
+
+	renderer.OnStart()
+	resp, err := executeAPIRequest(ctx, client, settings, req)
+	if err != nil {
+		renderer.OnDone(StreamResult{}, err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			renderer.OnDone(StreamResult{}, err)
+			return err
+		}
+		var indented bytes.Buffer
+		if json.Indent(&indented, data, "", "  ") == nil {
+			data = indented.Bytes()
+		}
+		renderer.OnContent(string(data))
+		renderer.OnDone(StreamResult{Role: "assistant", Content: string(data)}, nil)
+		return nil
+	}
+
+	result, err := format.ParseStream(resp.Body, renderer)
+	renderer.OnDone(result, err)
+	return err
+}
@@ -0,0 +1,330 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/henryhwang/chatbot/internal/conversation"
+	"github.com/henryhwang/chatbot/internal/types"
+)
+
+// --- Output Renderers ---
+
+// OutputRenderer receives a turn's output as the stream is parsed, keeping
+// presentation separate from stream parsing. OnDone is called exactly once per
+// turn with the parsed result and any error (including errors that occurred
+// before streaming began).
+type OutputRenderer interface {
+	OnStart() // The request has been sent and no output has arrived yet
+	OnReasoning(chunk string)
+	OnContent(chunk string)
+	OnToolCall(name, arguments, output string) // A requested tool has run; another round follows
+	OnDone(result StreamResult, err error)
+}
+
+// NewRenderer returns the stdout renderer selected by settings: JSON when
+// settings.JSONOutput is set, otherwise the streaming terminal renderer. When
+// stdout is a terminal and no output filter is transforming the content, code
+// blocks are highlighted if settings.RenderMarkdown is set, and content is
+// word-wrapped to the terminal width if settings.Wrap is set.
+func NewRenderer(provider types.ModelProvider, settings types.Settings) OutputRenderer {
+	if settings.JSONOutput {
+		return NewJSONRenderer(os.Stdout, provider.Model)
+	}
+	terminal := NewTerminalRenderer(os.Stdout, settings)
+	if settings.OutputFilterCmd != "" || !isTerminal(os.Stdout) {
+		return terminal
+	}
+	var renderer OutputRenderer = terminal
+	if settings.RenderMarkdown {
+		renderer = NewMarkdownRenderer(terminal)
+	}
+	if width := terminalWidth(os.Stdout); settings.Wrap && width > 0 {
+		prefixWidth := 0
+		if !settings.Quiet {
+			prefixWidth = utf8.RuneCountInString(settings.BotPrefix)
+		}
+		renderer = NewWrapRenderer(renderer, width, prefixWidth)
+	}
+	return renderer
+}
+
+// TerminalRenderer streams human-readable output with a reasoning prefix and
+// a "Bot:" prefix. With an output filter configured, content is buffered and
+// displayed through the filter once complete. In quiet mode reasoning is not
+// printed and content has no prefix.
+//
+// With settings.ShowReasoning "collapsed", reasoning is shown as a single
+// line counting its tokens, updated in place on a terminal and printed once
+// the reasoning ends otherwise; with "hidden" it is not shown at all.
+type TerminalRenderer struct {
+	out             io.Writer
+	filterCmd       string
+	filterTimeout   time.Duration
+	quiet           bool
+	botPrefix       string // Prefixes, already coloured if colour is enabled
+	reasoningPrefix string
+	color           bool     // Dim reasoning output
+	tty             bool     // out is a terminal, so lines can be rewritten in place
+	showReasoning   string   // "full", "collapsed" or "hidden"
+	animate         bool     // Show a spinner while waiting for the first output
+	spinner         *spinner // Running spinner, if any
+	choices         int      // Answers requested per turn (n); above 1, each is labelled
+
+	reasoning          strings.Builder // Reasoning of the current round, counted in collapsed mode
+	currentlyReasoning bool
+	reasoningPrinted   bool
+	botPrefixPrinted   bool
+}
+
+// NewTerminalRenderer creates a terminal renderer writing to out. The waiting
+// spinner and colours are only used when out is a terminal; the spinner is
+// also suppressed in quiet mode.
+func NewTerminalRenderer(out io.Writer, settings types.Settings) *TerminalRenderer {
+	file, isFile := out.(*os.File)
+	tty := isFile && isTerminal(file)
+	color := settings.Color && tty
+	return &TerminalRenderer{
+		out:             out,
+		filterCmd:       settings.OutputFilterCmd,
+		filterTimeout:   settings.OutputFilterTimeout,
+		quiet:           settings.Quiet,
+		botPrefix:       Colorize(settings.BotPrefix, ansiBoldGreen, color),
+		reasoningPrefix: Colorize(settings.ReasoningPrefix, ansiDim, color),
+		color:           color,
+		tty:             tty,
+		showReasoning:   settings.ShowReasoning,
+		animate:         !settings.Quiet && tty,
+		choices:         intOr(settings.Sampling.N, 1),
+	}
+}
+
+// intOr returns *value, or fallback when value is nil.
+func intOr(value *int, fallback int) int {
+	if value == nil {
+		return fallback
+	}
+	return *value
+}
+
+// Colorize wraps text in an ANSI colour sequence when enabled is true.
+func Colorize(text, code string, enabled bool) string {
+	if !enabled || text == "" {
+		return text
+	}
+	return code + text + ansiReset
+}
+
+// UserPromptColor is the ANSI sequence used for the input prompt.
+const UserPromptColor = ansiBoldCyan
+
+func (t *TerminalRenderer) OnStart() {
+	if t.animate {
+		t.spinner.Stop() // A new round after tool calls restarts the spinner
+		t.spinner = startSpinner(t.out)
+	}
+}
+
+// OnToolCall prints a one-line summary of the call on its own line, so the
+// next round's output starts with a fresh prefix.
+func (t *TerminalRenderer) OnToolCall(name, arguments, output string) {
+	t.spinner.Stop()
+	t.finishReasoning()
+	if t.botPrefixPrinted || t.currentlyReasoning {
+		fmt.Fprintln(t.out)
+	}
+	t.currentlyReasoning, t.botPrefixPrinted = false, false
+	t.reasoningPrinted = true // Something was printed, so OnDone doesn't report an empty response
+	if !t.quiet {
+		call := types.ToolCall{Function: types.ToolCallFunction{Name: name, Arguments: arguments}}
+		fmt.Fprintln(t.out, Colorize(fmt.Sprintf("Tool call: %s -> %d bytes", formatToolCall(call), len(output)), ansiDim, t.color))
+	}
+}
+
+func (t *TerminalRenderer) OnReasoning(chunk string) {
+	if t.quiet || t.showReasoning == "hidden" {
+		return // Drained by the stream parser all the same; the spinner keeps running
+	}
+	t.spinner.Stop() // Erase the spinner before the first output
+	if !t.currentlyReasoning {
+		if t.botPrefixPrinted {
+			fmt.Fprintln(t.out)
+		}
+		if t.showReasoning != "collapsed" {
+			fmt.Fprint(t.out, t.reasoningPrefix)
+		}
+		t.reasoning.Reset()
+		t.currentlyReasoning = true
+		t.reasoningPrinted = true
+		t.botPrefixPrinted = false
+	}
+	if t.showReasoning != "collapsed" {
+		fmt.Fprint(t.out, Colorize(chunk, ansiDim, t.color))
+		return
+	}
+	t.reasoning.WriteString(chunk)
+	if t.tty {
+		fmt.Fprint(t.out, "\r\033[K"+t.reasoningSummary("thinking..."))
+	}
+}
+
+// finishReasoning replaces the collapsed reasoning line with its final token
+// count once the reasoning has ended, leaving the cursor on that line.
+func (t *TerminalRenderer) finishReasoning() {
+	if !t.currentlyReasoning || t.showReasoning != "collapsed" {
+		return
+	}
+	if t.tty {
+		fmt.Fprint(t.out, "\r\033[K")
+	}
+	fmt.Fprint(t.out, t.reasoningSummary("thought for"))
+}
+
+// reasoningSummary is the collapsed reasoning line after the reasoning
+// prefix, e.g. "Reasoning: (thinking... 142 tokens)".
+func (t *TerminalRenderer) reasoningSummary(label string) string {
+	return t.reasoningPrefix + Colorize(fmt.Sprintf("(%s %d tokens)", label, conversation.EstimateTokens(t.reasoning.String())), ansiDim, t.color)
+}
+
+func (t *TerminalRenderer) OnContent(chunk string) {
+	// Erase the spinner before printing; filtered content is only shown in OnDone
+	if t.filterCmd == "" || t.currentlyReasoning {
+		t.spinner.Stop()
+	}
+	if t.currentlyReasoning {
+		t.finishReasoning()
+		fmt.Fprintln(t.out)
+		t.currentlyReasoning = false
+	}
+	if t.filterCmd != "" {
+		return // Buffered; displayed through the filter in OnDone
+	}
+	if !t.botPrefixPrinted {
+		t.printPrefix(1)
+		t.botPrefixPrinted = true
+	}
+	fmt.Fprint(t.out, chunk)
+}
+
+// printPrefix prints the "Bot:" prefix, preceded by a "Choice N:" label line
+// when several answers were requested.
+func (t *TerminalRenderer) printPrefix(choice int) {
+	if t.quiet {
+		return
+	}
+	if t.choices > 1 {
+		fmt.Fprintln(t.out, Colorize(fmt.Sprintf("Choice %d:", choice), ansiDim, t.color))
+	}
+	fmt.Fprint(t.out, t.botPrefix)
+}
+
+func (t *TerminalRenderer) OnDone(result StreamResult, err error) {
+	t.spinner.Stop()
+	t.finishReasoning() // The turn ended while reasoning, e.g. on an error
+
+	// Display buffered content through the output filter (history keeps the original)
+	if t.filterCmd != "" && err == nil && result.Content != "" {
+		t.printPrefix(1)
+		fmt.Fprint(t.out, filterOutput(t.filterCmd, result.Content, t.filterTimeout))
+		t.botPrefixPrinted = true
+	}
+
+	// The other answers (n > 1) arrive interleaved with the first, so they are
+	// shown whole once the stream ends; only the first is kept in history
+	if err == nil && len(result.Choices) > 1 {
+		for i, content := range result.Choices[1:] {
+			if t.botPrefixPrinted || t.currentlyReasoning {
+				fmt.Fprintln(t.out)
+			}
+			t.currentlyReasoning = false
+			if t.filterCmd != "" {
+				content = filterOutput(t.filterCmd, content, t.filterTimeout)
+			}
+			t.printPrefix(i + 2)
+			fmt.Fprint(t.out, content)
+			t.botPrefixPrinted = true
+		}
+	}
+
+	// Tool calls that were not run (tool calling disabled) are listed after the content
+	toolCallsShown := false
+	if err == nil && len(result.ToolCalls) > 0 && !t.quiet {
+		if t.botPrefixPrinted || t.currentlyReasoning {
+			fmt.Fprintln(t.out)
+		}
+		for _, call := range result.ToolCalls {
+			fmt.Fprintln(t.out, Colorize("Tool call: "+formatToolCall(call), ansiDim, t.color))
+		}
+		toolCallsShown = true
+	}
+
+	// Add a final newline for clean prompt display if anything was printed
+	if toolCallsShown {
+		// Each summary line already ends with a newline
+	} else if t.botPrefixPrinted || t.reasoningPrinted {
+		fmt.Fprintln(t.out)
+	} else if err == nil && len(result.ToolCalls) == 0 {
+		// Handle cases where stream ended early or with no valid data
+		// Only print this if the stream didn't encounter an error itself
+		fmt.Fprintln(t.out, "\nBot: Received no response content.")
+	}
+
+	if err == nil && result.Content == "" && !t.reasoningPrinted && len(result.ToolCalls) == 0 {
+		// Only show this message if NO reasoning AND NO content was generated, and no stream error
+		fmt.Fprintln(t.out, "Bot: Finished processing, but no text content was generated.")
+	}
+}
+
+// jsonTurn is the JSON object emitted per turn by JSONRenderer.
+type jsonTurn struct {
+	Content   string           `json:"content"`
+	Reasoning string           `json:"reasoning,omitempty"`
+	Model     string           `json:"model"`
+	Usage     *types.UsageInfo `json:"usage,omitempty"`
+	ToolCalls []types.ToolCall `json:"tool_calls,omitempty"` // Requested calls that were not run
+	Choices   []string         `json:"choices,omitempty"`    // Every answer when n > 1; content is the first
+	Error     string           `json:"error,omitempty"`
+}
+
+// JSONRenderer prints nothing while streaming and emits a single JSON object
+// per turn (content, reasoning, model, usage, tool calls and error) when it finishes.
+type JSONRenderer struct {
+	out   io.Writer
+	model string
+}
+
+// NewJSONRenderer creates a JSON renderer writing to out.
+func NewJSONRenderer(out io.Writer, model string) *JSONRenderer {
+	return &JSONRenderer{out: out, model: model}
+}
+
+func (j *JSONRenderer) OnStart() {}
+
+func (j *JSONRenderer) OnToolCall(name, arguments, output string) {}
+
+func (j *JSONRenderer) OnReasoning(chunk string) {}
+
+func (j *JSONRenderer) OnContent(chunk string) {}
+
+func (j *JSONRenderer) OnDone(result StreamResult, err error) {
+	turn := jsonTurn{
+		Content:   result.Content,
+		Reasoning: result.Reasoning,
+		Model:     j.model,
+		Usage:     result.Usage,
+		ToolCalls: result.ToolCalls,
+		Choices:   result.Choices,
+	}
+	if err != nil {
+		turn.Error = err.Error()
+	}
+	if encodeErr := json.NewEncoder(j.out).Encode(turn); encodeErr != nil {
+		log.Printf("Error writing JSON output: %v", encodeErr)
+	}
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/henryhwang/chatbot/internal/types"
+)
+
+func TestTerminalRendererReasoning(t *testing.T) {
+	tests := []struct {
+		name    string
+		show    string
+		prefix  string
+		want    string
+		notWant string
+	}{
+		{name: "full", show: "full", prefix: "Reasoning: ", want: "Reasoning: let me think\nBot: answer"},
+		{name: "collapsed", show: "collapsed", prefix: "Reasoning: ", want: "Reasoning: (thought for 8 tokens)\nBot: answer", notWant: "let me think"},
+		{name: "collapsed with custom prefix", show: "collapsed", prefix: "> ", want: "> (thought for 8 tokens)\nBot: answer"},
+		{name: "hidden", show: "hidden", prefix: "Reasoning: ", want: "Bot: answer", notWant: "Reasoning"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			renderer := NewTerminalRenderer(&out, types.Settings{BotPrefix: "Bot: ", ReasoningPrefix: tt.prefix, ShowReasoning: tt.show})
+			renderer.OnStart()
+			renderer.OnReasoning("let me ")
+			renderer.OnReasoning("think")
+			renderer.OnContent("answer")
+			renderer.OnDone(StreamResult{Content: "answer", Reasoning: "let me think"}, nil)
+
+			got := out.String()
+			if !strings.Contains(got, tt.want) {
+				t.Errorf("got %q, want it to contain %q", got, tt.want)
+			}
+			if tt.notWant != "" && strings.Contains(got, tt.notWant) {
+				t.Errorf("got %q, want no %q", got, tt.notWant)
+			}
+			if strings.Contains(got, "🤔") {
+				t.Errorf("got %q, which bypasses the configured prefix", got)
+			}
+		})
+	}
+}
+
+func TestTerminalRendererStream(t *testing.T) {
+	body := sseReasoning("hmm") + sseChunk("Hello") + sseChunk(", world") + "data: [DONE]\n\n"
+	tests := []struct {
+		name     string
+		settings types.Settings
+		want     string
+	}{
+		{"prefixed", types.Settings{BotPrefix: "Bot: ", ReasoningPrefix: "Reasoning: ", ShowReasoning: "full"}, "Reasoning: hmm\nBot: Hello, world\n"},
+		{"quiet", types.Settings{BotPrefix: "Bot: ", ReasoningPrefix: "Reasoning: ", ShowReasoning: "full", Quiet: true}, "Hello, world\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			renderer := NewTerminalRenderer(&out, tt.settings)
+			renderer.OnStart()
+			result, err := openAIProvider{}.ParseStream(strings.NewReader(body), renderer)
+			renderer.OnDone(result, err)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := out.String(); !strings.HasSuffix(got, tt.want) {
+				t.Errorf("got %q, want it to end with %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTerminalRendererToolCall(t *testing.T) {
+	var out bytes.Buffer
+	renderer := NewTerminalRenderer(&out, types.Settings{BotPrefix: "Bot: "})
+	renderer.OnStart()
+	renderer.OnToolCall("get_weather", `{"city":"NYC"}`, "sunny")
+	if want := `Tool call: get_weather({"city":"NYC"}) -> 5 bytes` + "\n"; out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+}
+
+func TestTerminalRendererChoices(t *testing.T) {
+	body := sseChoice(0, "Red") + sseChoice(1, "Green") + "data: [DONE]\n\n"
+	n := 2
+	var out bytes.Buffer
+	renderer := NewTerminalRenderer(&out, types.Settings{BotPrefix: "Bot: ", Sampling: types.SamplingParams{N: &n}})
+	renderer.OnStart()
+	result, err := openAIProvider{}.ParseStream(strings.NewReader(body), renderer)
+	renderer.OnDone(result, err)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), "Choice 1:\nBot: Red\nChoice 2:\nBot: Green\n"; !strings.HasSuffix(got, want) {
+		t.Errorf("got %q, want it to end with %q", got, want)
+	}
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- Retry with Exponential Backoff ---
+
+const (
+	retryBaseDelay = 500 * time.Millisecond
+	retryMaxDelay  = 30 * time.Second
+)
+
+// statusError is returned when the API responds with a non-OK status.
+type statusError struct {
+	StatusCode int
+	Body       string
+	RetryAfter string // Raw Retry-After header, if any
+}
+
+func (e *statusError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("LLM API returned error status %d (failed to read body)", e.StatusCode)
+	}
+	return fmt.Sprintf("LLM API returned error status %d: %s", e.StatusCode, e.Body)
+}
+
+// Statuses worth retrying: rate limiting and transient server errors
+var retryableStatuses = map[int]bool{
+	http.StatusTooManyRequests:     true,
+	http.StatusInternalServerError: true,
+	http.StatusBadGateway:          true,
+	http.StatusServiceUnavailable:  true,
+	http.StatusGatewayTimeout:      true,
+}
+
+// isRetryable reports whether a failed attempt should be retried. Client
+// errors (400/401/403...) are not retried, nor are cancellations and timeouts,
+// since repeating those would only delay the inevitable.
+func isRetryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		return retryableStatuses[statusErr.StatusCode]
+	}
+	if errors.Is(err, context.Canceled) || IsTimeout(err) {
+		return false
+	}
+	// Remaining errors come from the transport (connection refused, reset, DNS...)
+	return true
+}
+
+// backoffDelay returns how long to wait before the next attempt. A Retry-After
+// header takes precedence; otherwise the delay doubles with each attempt, with
+// random jitter to avoid synchronized retries.
+func backoffDelay(attempt int, err error) time.Duration {
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		if delay, ok := parseRetryAfter(statusErr.RetryAfter); ok {
+			return delay
+		}
+	}
+
+	delay := retryBaseDelay << attempt
+	if delay <= 0 || delay > retryMaxDelay {
+		delay = retryMaxDelay
+	}
+	// Spread the delay across 75%-125% of its nominal value
+	jitter := time.Duration(rand.Int63n(int64(delay)/2 + 1))
+	return delay*3/4 + jitter
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds or as an HTTP date.
+func parseRetryAfter(value string) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return capDelay(time.Duration(seconds) * time.Second), true
+	}
+	if when, err := http.ParseTime(value); err == nil {
+		delay := time.Until(when)
+		if delay < 0 {
+			delay = 0
+		}
+		return capDelay(delay), true
+	}
+	return 0, false
+}
+
+// capDelay limits a server-requested delay to retryMaxDelay.
+func capDelay(delay time.Duration) time.Duration {
+	if delay > retryMaxDelay {
+		return retryMaxDelay
+	}
+	return delay
+}
@@ -0,0 +1,221 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// --- HTTP Session Recording and Replay ---
+
+// recordedExchange is one request/response pair in a session file (JSON Lines).
+type recordedExchange struct {
+	Time        time.Time         `json:"time"`
+	Method      string            `json:"method"`
+	URL         string            `json:"url"`
+	Headers     map[string]string `json:"headers"` // Request headers, with credentials redacted
+	RequestBody string            `json:"request_body"`
+	Status      int               `json:"status"`
+	ContentType string            `json:"content_type,omitempty"`
+	Response    string            `json:"response"` // Raw response body, including the full SSE stream
+}
+
+// Headers whose values must never be written to a recording or debug log
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Api-Key":             true,
+	"X-Api-Key":           true,
+	"Openai-Organization": true,
+	"Openai-Project":      true,
+}
+
+// recordingTransport forwards requests to the next transport and appends each
+// exchange to a session file once its response body has been fully read.
+type recordingTransport struct {
+	next http.RoundTripper
+	path string
+	mu   sync.Mutex
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	exchange := recordedExchange{
+		Time:    time.Now(),
+		Method:  req.Method,
+		URL:     req.URL.String(),
+		Headers: make(map[string]string),
+	}
+	for key := range req.Header {
+		value := req.Header.Get(key)
+		if sensitiveHeaders[http.CanonicalHeaderKey(key)] {
+			value = "REDACTED"
+		}
+		exchange.Headers[key] = value
+	}
+	if req.Body != nil {
+		body, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read request body for recording: %w", err)
+		}
+		exchange.RequestBody = string(body)
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	exchange.Status = resp.StatusCode
+	exchange.ContentType = resp.Header.Get("Content-Type")
+
+	// Tee the body so streaming still reaches the caller as it arrives
+	resp.Body = &recordingBody{ReadCloser: resp.Body, transport: t, exchange: exchange}
+	return resp, nil
+}
+
+// write appends an exchange to the session file.
+func (t *recordingTransport) write(exchange recordedExchange) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	line, err := json.Marshal(exchange)
+	if err != nil {
+		log.Printf("Warning: Failed to encode recorded exchange: %v", err)
+		return
+	}
+	f, err := os.OpenFile(t.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Printf("Warning: Failed to open session recording %s: %v", t.path, err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		log.Printf("Warning: Failed to write session recording %s: %v", t.path, err)
+	}
+}
+
+// recordingBody captures everything read from a response body and records the
+// exchange when the body is closed.
+type recordingBody struct {
+	io.ReadCloser
+	transport *recordingTransport
+	exchange  recordedExchange
+	captured  bytes.Buffer
+	once      sync.Once
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.captured.Write(p[:n])
+	return n, err
+}
+
+func (b *recordingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() {
+		b.exchange.Response = b.captured.String()
+		b.transport.write(b.exchange)
+	})
+	return err
+}
+
+// replayTransport serves responses from a session file in recorded order,
+// without touching the network.
+type replayTransport struct {
+	exchanges []recordedExchange
+	next      int
+	mu        sync.Mutex
+}
+
+// loadReplayTransport reads all exchanges from a session file.
+func loadReplayTransport(path string) (*replayTransport, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open session replay %s: %w", path, err)
+	}
+	defer f.Close()
+
+	transport := &replayTransport{}
+	reader := bufio.NewReader(f)
+	for {
+		line, readErr := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			var exchange recordedExchange
+			if err := json.Unmarshal(line, &exchange); err != nil {
+				return nil, fmt.Errorf("malformed entry in session replay %s: %w", path, err)
+			}
+			transport.exchanges = append(transport.exchanges, exchange)
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return nil, fmt.Errorf("failed to read session replay %s: %w", path, readErr)
+		}
+	}
+	return transport, nil
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.next >= len(t.exchanges) {
+		return nil, fmt.Errorf("session replay exhausted after %d recorded exchanges", len(t.exchanges))
+	}
+	exchange := t.exchanges[t.next]
+	t.next++
+
+	header := make(http.Header)
+	if exchange.ContentType != "" {
+		header.Set("Content-Type", exchange.ContentType)
+	}
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", exchange.Status, http.StatusText(exchange.Status)),
+		StatusCode: exchange.Status,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     header,
+		Body:       io.NopCloser(bytes.NewReader([]byte(exchange.Response))),
+		Request:    req,
+	}, nil
+}
+
+// Replay transports are shared across requests so exchanges are served in order
+var (
+	replayMu         sync.Mutex
+	replayTransports = map[string]*replayTransport{}
+)
+
+// sessionTransport returns the transport to use given the recording/replay
+// settings, with network carrying live requests. Replay takes precedence over recording.
+func sessionTransport(recordPath, replayPath string, network http.RoundTripper) (http.RoundTripper, error) {
+	if replayPath != "" {
+		replayMu.Lock()
+		defer replayMu.Unlock()
+		if transport, ok := replayTransports[replayPath]; ok {
+			return transport, nil
+		}
+		transport, err := loadReplayTransport(replayPath)
+		if err != nil {
+			return nil, err
+		}
+		replayTransports[replayPath] = transport
+		return transport, nil
+	}
+	if recordPath != "" {
+		return &recordingTransport{next: network, path: recordPath}, nil
+	}
+	return network, nil
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/henryhwang/chatbot/internal/conversation"
+	"github.com/henryhwang/chatbot/internal/types"
+)
+
+func TestSessionRecordAndReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+	srv := newChatServer(t, "")
+	provider := srv.provider()
+	replies := []string{"first answer", "second answer"}
+
+	// runTurns sends one question per reply and returns the rendered answers.
+	runTurns := func(settings types.Settings) []string {
+		conv := conversation.NewConversation("", &conversation.SimpleTruncationStrategy{}, 10000)
+		var got []string
+		for i, reply := range replies {
+			srv.reply = reply
+			var renderer recordingRenderer
+			if err := QueryHandler(context.Background(), conv, "question", provider, settings, &renderer); err != nil {
+				t.Fatalf("turn %d: %v", i+1, err)
+			}
+			got = append(got, renderer.content.String())
+		}
+		return got
+	}
+
+	recorded := runTurns(types.Settings{RecordSession: path})
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != len(replies) {
+		t.Errorf("recorded %d exchanges, want %d", lines, len(replies))
+	}
+	if strings.Contains(string(data), provider.APIKey) || !strings.Contains(string(data), "REDACTED") {
+		t.Errorf("API key not redacted in the recording:\n%s", data)
+	}
+
+	srv.Close() // Replay must not need the network
+	replayed := runTurns(types.Settings{ReplaySession: path})
+	for i := range replies {
+		if recorded[i] != replies[i] || replayed[i] != recorded[i] {
+			t.Errorf("turn %d: recorded %q, replayed %q, want %q", i+1, recorded[i], replayed[i], replies[i])
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// --- Waiting Indicator ---
+
+const spinnerInterval = 100 * time.Millisecond
+
+var spinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// spinner animates a "Thinking..." indicator on the current line until stopped.
+type spinner struct {
+	out  io.Writer
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// startSpinner starts animating on out in the background.
+func startSpinner(out io.Writer) *spinner {
+	s := &spinner{out: out, stop: make(chan struct{}), done: make(chan struct{})}
+	go s.run()
+	return s
+}
+
+func (s *spinner) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(spinnerInterval)
+	defer ticker.Stop()
+	for frame := 0; ; frame++ {
+		fmt.Fprintf(s.out, "\r%s Thinking...", spinnerFrames[frame%len(spinnerFrames)])
+		select {
+		case <-s.stop:
+			fmt.Fprint(s.out, "\r\033[K") // Erase the indicator, leaving the cursor at column 0
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Stop erases the indicator. It returns only once the animation has finished
+// writing, so output that follows can't be interleaved with it. Safe to call
+// more than once, and on a nil spinner.
+func (s *spinner) Stop() {
+	if s == nil {
+		return
+	}
+	s.once.Do(func() { close(s.stop) })
+	<-s.done
+}
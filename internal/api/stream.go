@@ -0,0 +1,381 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/henryhwang/chatbot/internal/types"
+)
+
+// --- Stream Parsing ---
+
+// Longest single line accepted in a stream. bufio.Scanner's 64KB default is
+// too small for some providers (e.g. large tool-call arguments or base64).
+var maxStreamLine = 1024 * 1024
+
+// SetMaxStreamLine changes the longest stream line accepted, in bytes.
+// Values below 64KB are raised to it.
+func SetMaxStreamLine(limit int) {
+	maxStreamLine = max(limit, bufio.MaxScanTokenSize)
+}
+
+// How many following payloads an undecodable payload may be joined with
+// before it is dropped. Some providers split one JSON object across payloads.
+var maxPayloadJoins = 2
+
+// SetMaxPayloadJoins changes how many following payloads an undecodable
+// payload is joined with before it is dropped; 0 drops it immediately.
+func SetMaxPayloadJoins(joins int) {
+	maxPayloadJoins = max(joins, 0)
+}
+
+// streamFraming is how a provider delimits the payloads of a streamed response.
+type streamFraming int
+
+const (
+	framingSSE    streamFraming = iota // Server-sent events: payloads on "data: " lines
+	framingNDJSON                      // Newline-delimited JSON: one payload per line
+)
+
+// streamFormat describes a provider's stream: its framing, an optional
+// sentinel payload ending it, and how to decode each payload.
+type streamFormat struct {
+	framing    streamFraming
+	doneMarker string // Payload that ends the stream, e.g. "[DONE]" ("" if none)
+	// decode turns one payload into a chunk; it may keep state across calls
+	// (e.g. usage split over several events), so use a fresh one per stream.
+	decode func(payload string) (streamChunk, error)
+}
+
+// streamChunk is the provider-neutral content of one stream payload.
+type streamChunk struct {
+	Role      string
+	Reasoning string
+	Content   string
+	Usage     *types.UsageInfo // Latest usage totals, if this payload reported any
+	ToolCalls []types.ToolCall // Tool call fragments, merged by index
+	Done      bool             // The payload ends the stream (e.g. Ollama's done:true)
+
+	OtherChoices map[int]string // Content for answers after the first (n > 1), by choice index
+	Err          error          // The provider reported an error mid-stream
+}
+
+// parseStream reads a streamed response in the given format. Reasoning and
+// content chunks are passed to renderer as they arrive, and the complete
+// response is returned along with any error encountered while reading.
+func parseStream(body io.Reader, format streamFormat, renderer OutputRenderer) (StreamResult, error) {
+	var content, reasoning strings.Builder
+	var otherChoices []string                 // Alternative answers (n > 1), by choice index - 1
+	result := StreamResult{Role: "assistant"} // Default role
+	var streamErr error
+
+	// An undecodable payload is held back and retried joined with the next
+	// one(s), in case the provider split a JSON object across payloads
+	pending, joins := "", 0
+
+	err := readStream(body, format.framing, func(payload string) bool {
+		if format.doneMarker != "" && payload == format.doneMarker {
+			return false
+		}
+
+		candidate := pending + payload
+		chunk, err := decodePayload(format, candidate)
+		if err != nil && pending != "" {
+			// The new payload may be complete on its own; then the fragment is lost
+			if own, ownErr := decodePayload(format, payload); ownErr == nil {
+				log.Printf("Dropping undecodable stream data: '%s'", pending)
+				chunk, err, candidate = own, nil, payload
+			}
+		}
+		if err != nil {
+			if joins < maxPayloadJoins {
+				pending, joins = candidate, joins+1
+				return true
+			}
+			// Log the error but attempt to continue processing the stream
+			log.Printf("Error unmarshalling stream data: %v. Data: '%s'", err, candidate)
+			pending, joins = "", 0
+			return true
+		}
+		pending, joins = "", 0
+		if chunk.Err != nil {
+			streamErr = chunk.Err
+			return false
+		}
+
+		if chunk.Role != "" {
+			result.Role = chunk.Role
+		}
+		if chunk.Usage != nil {
+			result.Usage = chunk.Usage
+		}
+		if result.FirstChunkAt.IsZero() && (chunk.Reasoning != "" || chunk.Content != "") {
+			result.FirstChunkAt = time.Now()
+		}
+		if chunk.Reasoning != "" {
+			reasoning.WriteString(chunk.Reasoning)
+			renderer.OnReasoning(chunk.Reasoning)
+		}
+		if chunk.Content != "" {
+			content.WriteString(chunk.Content)
+			renderer.OnContent(chunk.Content)
+		}
+		for _, fragment := range chunk.ToolCalls {
+			result.ToolCalls = mergeToolCall(result.ToolCalls, fragment)
+		}
+		for index, text := range chunk.OtherChoices {
+			for len(otherChoices) < index {
+				otherChoices = append(otherChoices, "")
+			}
+			otherChoices[index-1] += text
+		}
+		return !chunk.Done
+	})
+
+	if pending != "" {
+		log.Printf("Dropping undecodable stream data at end of stream: '%s'", pending)
+	}
+
+	result.Content = content.String()
+	result.Reasoning = reasoning.String()
+	result.CompletedAt = time.Now()
+	if len(otherChoices) > 0 {
+		result.Choices = append([]string{result.Content}, otherChoices...)
+	}
+	if err == nil {
+		err = streamErr
+	}
+	return result, err
+}
+
+// decodePayload decodes payload, retrying without line breaks if that fails:
+// an object split across SSE data lines is rejoined with newlines, which are
+// invalid inside a JSON string but can never be part of valid JSON content.
+func decodePayload(format streamFormat, payload string) (streamChunk, error) {
+	chunk, err := format.decode(payload)
+	if err != nil && strings.Contains(payload, "\n") {
+		if joined, joinErr := format.decode(strings.ReplaceAll(payload, "\n", "")); joinErr == nil {
+			return joined, nil
+		}
+	}
+	return chunk, err
+}
+
+// mergeToolCall adds a streamed tool call fragment to calls. A fragment with
+// a new index starts a call; later fragments of that index append to its
+// arguments (and fill in the ID or name if they arrive late).
+func mergeToolCall(calls []types.ToolCall, fragment types.ToolCall) []types.ToolCall {
+	index := len(calls) // Fragments without an index are complete calls
+	if fragment.Index != nil {
+		index = *fragment.Index
+	}
+	for len(calls) <= index {
+		calls = append(calls, types.ToolCall{Type: "function"})
+	}
+	call := &calls[index]
+	if fragment.ID != "" {
+		call.ID = fragment.ID
+	}
+	if fragment.Type != "" {
+		call.Type = fragment.Type
+	}
+	if fragment.Function.Name != "" {
+		call.Function.Name = fragment.Function.Name
+	}
+	call.Function.Arguments += fragment.Function.Arguments
+	return calls
+}
+
+// formatToolCall renders a call readably, e.g. get_weather({"city":"NYC"}).
+func formatToolCall(call types.ToolCall) string {
+	return fmt.Sprintf("%s(%s)", call.Function.Name, call.Function.Arguments)
+}
+
+// readStream reads a streamed response from body and calls handle with each
+// payload, as delimited by framing, until handle returns false or the stream ends.
+func readStream(body io.Reader, framing streamFraming, handle func(payload string) bool) error {
+	scanner := bufio.NewScanner(body) // Use the passed reader
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), maxStreamLine)
+
+	// An SSE event's data may span several "data:" lines; they are joined with
+	// newlines and dispatched at the blank line ending the event
+	var data []string
+	dispatch := func() bool {
+		if data == nil {
+			return true // No data fields, e.g. an event of only comments
+		}
+		payload := strings.Join(data, "\n")
+		data = nil
+		return handle(payload)
+	}
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if framing == framingNDJSON {
+			if strings.TrimSpace(line) != "" && !handle(line) {
+				break
+			}
+			continue
+		}
+
+		if line == "" {
+			if !dispatch() {
+				return nil
+			}
+			continue
+		}
+		// Comment lines (e.g. ": keep-alive") carry no payload and are skipped.
+		// The idle timeout (see idleTimeoutBody) counts the bytes read, not the
+		// payloads, so they still keep a slow-but-alive stream from stalling.
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+		// Fields other than data (event, id, retry) are ignored
+		field, value, _ := strings.Cut(line, ":")
+		if field == "data" {
+			data = append(data, strings.TrimPrefix(value, " "))
+		}
+	}
+
+	// Check for scanner errors after the loop finishes
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			err = fmt.Errorf("stream line longer than %d bytes (raise STREAM_MAX_LINE_BYTES): %w", maxStreamLine, err)
+		}
+		log.Printf("Error reading stream: %v", err)
+		return err // Return scanner error
+	}
+	dispatch() // Be lenient with a final event missing its blank line
+	return nil
+}
+
+// --- Provider Stream Formats ---
+
+// openAIStream is the OpenAI-compatible SSE stream terminated by "data: [DONE]".
+func openAIStream() streamFormat {
+	fingerprint := "" // Logged in debug mode whenever it first appears or changes
+	return streamFormat{
+		framing:    framingSSE,
+		doneMarker: "[DONE]",
+		decode: func(payload string) (streamChunk, error) {
+			var streamResp types.OpenAIStreamResponse
+			if err := json.Unmarshal([]byte(payload), &streamResp); err != nil {
+				return streamChunk{}, err
+			}
+			if streamResp.SystemFingerprint != "" && streamResp.SystemFingerprint != fingerprint {
+				fingerprint = streamResp.SystemFingerprint
+				if debugLog != nil {
+					debugLog.Printf("system_fingerprint: %s", fingerprint)
+				}
+			}
+			// Usage typically arrives in a final chunk with no choices
+			chunk := streamChunk{Usage: streamResp.Usage}
+			for _, choice := range streamResp.Choices {
+				delta := choice.Delta
+				if choice.Index > 0 { // An alternative answer (n > 1): content only
+					if delta.Content != "" {
+						if chunk.OtherChoices == nil {
+							chunk.OtherChoices = make(map[int]string)
+						}
+						chunk.OtherChoices[choice.Index] += delta.Content
+					}
+					continue
+				}
+				chunk.Role = delta.Role
+				chunk.Reasoning = delta.Reasoning
+				chunk.Content = delta.Content
+				chunk.ToolCalls = delta.ToolCalls
+			}
+			return chunk, nil
+		},
+	}
+}
+
+// anthropicStream is the Messages API event stream: text and thinking arrive
+// in content_block_delta events, token usage in message_start and
+// message_delta, and failures as an error event.
+func anthropicStream() streamFormat {
+	var usage types.UsageInfo // Input and output counts arrive in separate events
+	return streamFormat{
+		framing: framingSSE,
+		decode: func(payload string) (streamChunk, error) {
+			var event types.AnthropicStreamEvent
+			if err := json.Unmarshal([]byte(payload), &event); err != nil {
+				return streamChunk{}, err
+			}
+
+			var chunk streamChunk
+			switch event.Type {
+			case "message_start":
+				if event.Message != nil {
+					usage.PromptTokens = event.Message.Usage.InputTokens
+				}
+			case "content_block_delta":
+				if event.Delta != nil {
+					chunk.Reasoning = event.Delta.Thinking
+					chunk.Content = event.Delta.Text
+				}
+				return chunk, nil
+			case "message_delta":
+				if event.Usage != nil {
+					usage.CompletionTokens = event.Usage.OutputTokens
+				}
+			case "message_stop":
+				chunk.Done = true
+			case "error":
+				if event.Error != nil {
+					chunk.Err = fmt.Errorf("API error (%s): %s", event.Error.Type, event.Error.Message)
+				} else {
+					chunk.Err = fmt.Errorf("API error: %s", payload)
+				}
+				return chunk, nil
+			default:
+				return chunk, nil // ping, content_block_start/stop...
+			}
+
+			usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+			reported := usage
+			chunk.Usage = &reported
+			return chunk, nil
+		},
+	}
+}
+
+// ollamaStream is Ollama's NDJSON stream, ending with a done:true chunk that
+// carries the token counts.
+func ollamaStream() streamFormat {
+	return streamFormat{
+		framing: framingNDJSON,
+		decode: func(payload string) (streamChunk, error) {
+			var ollamaChunk types.OllamaStreamChunk
+			if err := json.Unmarshal([]byte(payload), &ollamaChunk); err != nil {
+				return streamChunk{}, err
+			}
+			if ollamaChunk.Error != "" {
+				return streamChunk{Err: fmt.Errorf("API error: %s", ollamaChunk.Error)}, nil
+			}
+
+			chunk := streamChunk{Done: ollamaChunk.Done}
+			if msg := ollamaChunk.Message; msg != nil {
+				chunk.Role = msg.Role
+				chunk.Reasoning = msg.Thinking
+				chunk.Content = msg.Content
+			}
+			if ollamaChunk.Done {
+				chunk.Usage = &types.UsageInfo{
+					PromptTokens:     ollamaChunk.PromptEvalCount,
+					CompletionTokens: ollamaChunk.EvalCount,
+					TotalTokens:      ollamaChunk.PromptEvalCount + ollamaChunk.EvalCount,
+				}
+			}
+			return chunk, nil
+		},
+	}
+}
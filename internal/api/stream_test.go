@@ -0,0 +1,454 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/henryhwang/chatbot/internal/types"
+)
+
+// recordingRenderer captures renderer calls, for asserting on a stream's output.
+type recordingRenderer struct {
+	calls     []string
+	reasoning strings.Builder
+	content   strings.Builder
+	tools     []string
+	result    StreamResult
+	err       error
+	done      int
+}
+
+func (r *recordingRenderer) OnStart() { r.calls = append(r.calls, "start") }
+
+func (r *recordingRenderer) OnReasoning(chunk string) {
+	r.calls = append(r.calls, "reasoning:"+chunk)
+	r.reasoning.WriteString(chunk)
+}
+
+func (r *recordingRenderer) OnContent(chunk string) {
+	r.calls = append(r.calls, "content:"+chunk)
+	r.content.WriteString(chunk)
+}
+
+func (r *recordingRenderer) OnToolCall(name, arguments, output string) {
+	r.tools = append(r.tools, fmt.Sprintf("%s(%s)=%s", name, arguments, output))
+}
+
+func (r *recordingRenderer) OnDone(result StreamResult, err error) {
+	r.calls = append(r.calls, "done")
+	r.result, r.err = result, err
+	r.done++
+}
+
+// sseChunk returns an OpenAI stream event carrying content.
+func sseChunk(content string) string {
+	return fmt.Sprintf("data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":%q}}]}\n\n", content)
+}
+
+func TestKeepAliveComments(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"comment between events", sseChunk("a") + ": ping\n\n" + sseChunk("b") + "data: [DONE]\n\n", "ab"},
+		{"comment inside an event", "data: {\"choices\":[{\"delta\":\n: ping\ndata: {\"content\":\"x\"}}]}\n\ndata: [DONE]\n\n", "x"},
+		{"comment with no space", ":keep-alive\n" + sseChunk("a") + "data: [DONE]\n\n", "a"},
+		{"only comments", ": ping\n\n: ping\n\n", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var renderer recordingRenderer
+			result, err := openAIProvider{}.ParseStream(strings.NewReader(tt.body), &renderer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Content != tt.want || renderer.content.String() != tt.want {
+				t.Errorf("got %q (rendered %q), want %q", result.Content, renderer.content.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestKeepAliveCommentsPreventIdleTimeout(t *testing.T) {
+	const idle = 150 * time.Millisecond
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, sseChunk("first"))
+		w.(http.Flusher).Flush()
+		// Silent for 4 idle periods, apart from keep-alives
+		for i := 0; i < 12; i++ {
+			time.Sleep(idle / 3)
+			fmt.Fprint(w, ": ping\n\n")
+			w.(http.Flusher).Flush()
+		}
+		fmt.Fprint(w, sseChunk(" second")+"data: [DONE]\n\n")
+	}))
+	defer srv.Close()
+
+	settings := types.Settings{StreamIdleTimeout: idle}
+	client, err := HTTPClient(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := executeAPIRequest(context.Background(), client, settings, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var renderer recordingRenderer
+	result, err := openAIProvider{}.ParseStream(resp.Body, &renderer)
+	if err != nil {
+		t.Fatalf("keep-alives did not count as activity: %v", err)
+	}
+	if result.Content != "first second" {
+		t.Errorf("got %q, want %q", result.Content, "first second")
+	}
+}
+
+// sseReasoning returns an OpenAI stream event carrying reasoning.
+func sseReasoning(reasoning string) string {
+	return fmt.Sprintf("data: {\"choices\":[{\"index\":0,\"delta\":{\"reasoning_content\":%q}}]}\n\n", reasoning)
+}
+
+func TestParseStreamRendererCalls(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{
+			name: "content only",
+			body: sseChunk("Hel") + sseChunk("lo") + "data: [DONE]\n\n",
+			want: []string{"content:Hel", "content:lo"},
+		},
+		{
+			name: "reasoning then content",
+			body: sseReasoning("think") + sseReasoning("ing") + sseChunk("answer") + "data: [DONE]\n\n",
+			want: []string{"reasoning:think", "reasoning:ing", "content:answer"},
+		},
+		{
+			name: "empty deltas are not rendered",
+			body: sseChunk("") + sseChunk("x") + "data: [DONE]\n\n",
+			want: []string{"content:x"},
+		},
+		{
+			name: "nothing after DONE",
+			body: sseChunk("a") + "data: [DONE]\n\n" + sseChunk("b"),
+			want: []string{"content:a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var renderer recordingRenderer
+			result, err := openAIProvider{}.ParseStream(strings.NewReader(tt.body), &renderer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(renderer.calls) != fmt.Sprint(tt.want) {
+				t.Errorf("calls %q, want %q", renderer.calls, tt.want)
+			}
+			if result.Content != renderer.content.String() || result.Reasoning != renderer.reasoning.String() {
+				t.Errorf("result %q/%q differs from what was rendered %q/%q", result.Reasoning, result.Content, renderer.reasoning.String(), renderer.content.String())
+			}
+		})
+	}
+}
+
+func TestReadStreamFraming(t *testing.T) {
+	tests := []struct {
+		name    string
+		framing streamFraming
+		body    string
+		stop    string // Payload at which handle stops the stream ("" to read it all)
+		want    []string
+	}{
+		{name: "sse events", framing: framingSSE, body: "data: a\n\ndata: b\n\n", want: []string{"a", "b"}},
+		{name: "sse multi-line data", framing: framingSSE, body: "data: {\"x\":\ndata: 1}\n\n", want: []string{"{\"x\":\n1}"}},
+		{name: "sse other fields ignored", framing: framingSSE, body: "event: ping\nid: 7\nretry: 10\ndata:no-space\n\n", want: []string{"no-space"}},
+		{name: "sse event without data", framing: framingSSE, body: "event: ping\n\ndata: a\n\n", want: []string{"a"}},
+		{name: "sse final event without blank line", framing: framingSSE, body: "data: a\n\ndata: b", want: []string{"a", "b"}},
+		{name: "sse stops when told", framing: framingSSE, body: "data: a\n\ndata: [DONE]\n\ndata: b\n\n", stop: "[DONE]", want: []string{"a", "[DONE]"}},
+		{name: "ndjson lines", framing: framingNDJSON, body: "{\"a\":1}\n\n{\"b\":2}\n", want: []string{"{\"a\":1}", "{\"b\":2}"}},
+		{name: "ndjson data prefix not special", framing: framingNDJSON, body: "data: x\n", want: []string{"data: x"}},
+		{name: "ndjson stops when told", framing: framingNDJSON, body: "1\n2\n3\n", stop: "2", want: []string{"1", "2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			err := readStream(strings.NewReader(tt.body), tt.framing, func(payload string) bool {
+				got = append(got, payload)
+				return payload != tt.stop
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprintf("%q", got) != fmt.Sprintf("%q", tt.want) {
+				t.Errorf("payloads %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProviderStreamFormats(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider Provider
+		body     string
+		want     string
+	}{
+		{
+			name:     "openai sse ends at [DONE]",
+			provider: openAIProvider{},
+			body:     sseChunk("Hi") + sseChunk("!") + "data: [DONE]\n\n" + sseChunk("ignored"),
+			want:     "Hi!",
+		},
+		{
+			name:     "anthropic sse ends at message_stop",
+			provider: anthropicProvider{},
+			body: "event: message_start\ndata: {\"type\":\"message_start\"}\n\n" +
+				"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"delta\":{\"type\":\"text_delta\",\"text\":\"Hi\"}}\n\n" +
+				"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"delta\":{\"type\":\"text_delta\",\"text\":\"!\"}}\n\n" +
+				"event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n" +
+				"data: {\"type\":\"content_block_delta\",\"delta\":{\"type\":\"text_delta\",\"text\":\"ignored\"}}\n\n",
+			want: "Hi!",
+		},
+		{
+			name:     "ollama ndjson ends at done",
+			provider: ollamaProvider{},
+			body:     "{\"message\":{\"content\":\"Hi\"}}\n{\"message\":{\"content\":\"!\"},\"done\":true}\n{\"message\":{\"content\":\"ignored\"}}\n",
+			want:     "Hi!",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := tt.provider.ParseStream(strings.NewReader(tt.body), &recordingRenderer{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Content != tt.want {
+				t.Errorf("got %q, want %q", result.Content, tt.want)
+			}
+		})
+	}
+}
+
+// sseToolCall returns an OpenAI stream event carrying a tool call fragment.
+func sseToolCall(index int, id, name, arguments string) string {
+	return fmt.Sprintf("data: {\"choices\":[{\"index\":0,\"delta\":{\"tool_calls\":[{\"index\":%d,\"id\":%q,\"type\":\"function\",\"function\":{\"name\":%q,\"arguments\":%q}}]}}]}\n\n",
+		index, id, name, arguments)
+}
+
+func TestToolCallFragments(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []string // formatToolCall of each assembled call, with its ID
+	}{
+		{
+			name: "arguments split mid-value",
+			body: sseToolCall(0, "call_1", "get_weather", "") +
+				sseToolCall(0, "", "", `{"ci`) +
+				sseToolCall(0, "", "", `ty":"N`) +
+				sseToolCall(0, "", "", `YC"}`),
+			want: []string{`call_1 get_weather({"city":"NYC"})`},
+		},
+		{
+			name: "interleaved calls by index",
+			body: sseToolCall(0, "call_1", "get_weather", `{"city":`) +
+				sseToolCall(1, "call_2", "get_time", `{"zone":`) +
+				sseToolCall(0, "", "", `"Paris"}`) +
+				sseToolCall(1, "", "", `"UTC"}`),
+			want: []string{`call_1 get_weather({"city":"Paris"})`, `call_2 get_time({"zone":"UTC"})`},
+		},
+		{
+			name: "name arriving after the first fragment",
+			body: sseToolCall(0, "call_1", "", `{}`) + sseToolCall(0, "", "get_time", ""),
+			want: []string{`call_1 get_time({})`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var renderer recordingRenderer
+			result, err := openAIProvider{}.ParseStream(strings.NewReader(tt.body+"data: [DONE]\n\n"), &renderer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, call := range result.ToolCalls {
+				got = append(got, call.ID+" "+formatToolCall(call))
+			}
+			if fmt.Sprintf("%q", got) != fmt.Sprintf("%q", tt.want) {
+				t.Errorf("tool calls %q, want %q", got, tt.want)
+			}
+			if result.Content != "" {
+				t.Errorf("tool call fragments leaked into content: %q", result.Content)
+			}
+		})
+	}
+}
+
+func TestLongStreamLines(t *testing.T) {
+	defer SetMaxStreamLine(maxStreamLine)
+	tests := []struct {
+		name    string
+		limit   int
+		content string
+		wantErr bool
+	}{
+		{name: "line over 64KB", limit: 1024 * 1024, content: strings.Repeat("x", 200*1024)},
+		{name: "limit below 64KB is raised to it", limit: 1, content: strings.Repeat("x", 60*1024)},
+		{name: "line over the limit", limit: 100 * 1024, content: strings.Repeat("x", 200*1024), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetMaxStreamLine(tt.limit)
+			body := sseChunk("start ") + sseChunk(tt.content) + "data: [DONE]\n\n"
+			result, err := openAIProvider{}.ParseStream(strings.NewReader(body), &recordingRenderer{})
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "STREAM_MAX_LINE_BYTES") {
+					t.Fatalf("got error %v, want one naming STREAM_MAX_LINE_BYTES", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Content != "start "+tt.content {
+				t.Errorf("got %d bytes of content, want %d", len(result.Content), len("start "+tt.content))
+			}
+		})
+	}
+}
+
+func TestMultiLineEvents(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{
+			name: "pretty-printed event",
+			body: "data: {\n" +
+				"data:   \"choices\": [{\"index\": 0,\n" +
+				"data:     \"delta\": {\"content\": \"Hello\"}}]\n" +
+				"data: }\n\n" + sseChunk(" there") + "data: [DONE]\n\n",
+			want: "Hello there",
+		},
+		{
+			name: "heartbeats between and inside events",
+			body: ": heartbeat\n\n" +
+				"data: {\"choices\":[{\"index\":0,\n" +
+				": heartbeat\n" +
+				"data: \"delta\":{\"content\":\"a\"}}]}\n\n" +
+				": heartbeat\n\n" + sseChunk("b") + "data: [DONE]\n\n",
+			want: "ab",
+		},
+		{
+			name: "event and id fields around data",
+			body: "event: chunk\nid: 1\n" + sseChunk("x") + "event: chunk\nid: 2\n" + sseChunk("y") + "data: [DONE]\n\n",
+			want: "xy",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := openAIProvider{}.ParseStream(strings.NewReader(tt.body), &recordingRenderer{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Content != tt.want {
+				t.Errorf("got %q, want %q", result.Content, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitPayloads(t *testing.T) {
+	defer SetMaxPayloadJoins(maxPayloadJoins)
+	first, second := `{"choices":[{"index":0,"delta":`, `{"content":"joined"}}]}`
+	tests := []struct {
+		name  string
+		joins int
+		body  string
+		want  string
+	}{
+		{
+			name:  "object split across two events",
+			joins: 2,
+			body:  sseChunk("a ") + "data: " + first + "\n\ndata: " + second + "\n\n" + sseChunk(" b"),
+			want:  "a joined b",
+		},
+		{
+			name:  "object split across three events",
+			joins: 2,
+			body:  "data: " + first[:10] + "\n\ndata: " + first[10:] + "\n\ndata: " + second + "\n\n",
+			want:  "joined",
+		},
+		{
+			name:  "more pieces than allowed joins are dropped",
+			joins: 1,
+			body:  "data: " + first[:10] + "\n\ndata: " + first[10:] + "\n\ndata: " + second + "\n\n" + sseChunk("after"),
+			want:  "after",
+		},
+		{
+			name:  "joining disabled",
+			joins: 0,
+			body:  "data: " + first + "\n\ndata: " + second + "\n\n" + sseChunk("after"),
+			want:  "after",
+		},
+		{
+			name:  "fragment followed by a complete payload",
+			joins: 2,
+			body:  "data: " + first + "\n\n" + sseChunk("whole") + sseChunk(" next"),
+			want:  "whole next",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetMaxPayloadJoins(tt.joins)
+			result, err := openAIProvider{}.ParseStream(strings.NewReader(tt.body+"data: [DONE]\n\n"), &recordingRenderer{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Content != tt.want {
+				t.Errorf("got %q, want %q", result.Content, tt.want)
+			}
+		})
+	}
+}
+
+// sseChoice returns an OpenAI stream event carrying content for one of
+// several answers (n > 1).
+func sseChoice(index int, content string) string {
+	return fmt.Sprintf("data: {\"choices\":[{\"index\":%d,\"delta\":{\"content\":%q}}]}\n\n", index, content)
+}
+
+func TestMultipleChoices(t *testing.T) {
+	// Answers arrive interleaved, as they do from the API
+	body := sseChoice(0, "Red") + sseChoice(1, "Gre") + sseChoice(2, "Blue") + sseChoice(1, "en") + sseChoice(0, "!") + "data: [DONE]\n\n"
+	var renderer recordingRenderer
+	result, err := openAIProvider{}.ParseStream(strings.NewReader(body), &renderer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Content != "Red!" {
+		t.Errorf("content %q, want the first answer only", result.Content)
+	}
+	if want := []string{"Red!", "Green", "Blue"}; !slices.Equal(result.Choices, want) {
+		t.Errorf("choices %q, want %q", result.Choices, want)
+	}
+	if renderer.content.String() != "Red!" {
+		t.Errorf("streamed %q, want only the first answer", renderer.content.String())
+	}
+}
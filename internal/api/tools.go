@@ -0,0 +1,39 @@
+package api
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/henryhwang/chatbot/internal/conversation"
+	"github.com/henryhwang/chatbot/internal/tools"
+	"github.com/henryhwang/chatbot/internal/types"
+)
+
+// --- Tool Calling ---
+
+// Limit on consecutive tool-call rounds in one turn, so a model that keeps
+// calling tools can't loop forever
+const maxToolRounds = 8
+
+// toolRegistry holds the tools offered with OpenAI-format requests; nil
+// (the default) disables tool calling.
+var toolRegistry *tools.Registry
+
+// SetToolRegistry offers the registry's tools to the model and runs the
+// calls it makes. Passing nil disables tool calling.
+func SetToolRegistry(registry *tools.Registry) {
+	toolRegistry = registry
+}
+
+// runToolCalls executes each call and appends its result as a "tool" message.
+// Failures are reported to the model as the result, so it can react to them.
+func runToolCalls(ctx context.Context, conv *conversation.Conversation, calls []types.ToolCall, renderer OutputRenderer) {
+	for _, call := range calls {
+		output, err := toolRegistry.Call(ctx, call.Function.Name, call.Function.Arguments)
+		if err != nil {
+			output = fmt.Sprintf("Error: %v", err)
+		}
+		renderer.OnToolCall(call.Function.Name, call.Function.Arguments, output)
+		conv.AppendMessage(types.Message{Role: "tool", Content: output, ToolCallID: call.ID})
+	}
+}
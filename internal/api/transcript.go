@@ -0,0 +1,50 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// --- Transcript Logging ---
+
+// transcriptDatePattern in TRANSCRIPT_FILE is replaced with the current date,
+// so e.g. "chat-{date}.log" starts a new file each day.
+const transcriptDatePattern = "{date}"
+
+// transcriptPath returns the file a turn finishing at now is appended to.
+func transcriptPath(pattern string, now time.Time) string {
+	return strings.ReplaceAll(pattern, transcriptDatePattern, now.Format("2006-01-02"))
+}
+
+// appendTranscript appends a completed turn (the user's message and the full
+// reply, each with its time) to the transcript file and syncs it to disk, so
+// the transcript survives a crash. Failures are logged, never fatal.
+func appendTranscript(pattern, question string, asked time.Time, answer string, answered time.Time) {
+	path := transcriptPath(pattern, answered)
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			log.Printf("Warning: Could not create transcript directory %s: %v", dir, err)
+			return
+		}
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Printf("Warning: Could not open transcript %s: %v", path, err)
+		return
+	}
+	defer f.Close()
+
+	const layout = "2006-01-02 15:04:05"
+	entry := fmt.Sprintf("[%s] You: %s\n[%s] Bot: %s\n\n", asked.Format(layout), question, answered.Format(layout), answer)
+	if _, err := f.WriteString(entry); err != nil {
+		log.Printf("Warning: Could not write transcript %s: %v", path, err)
+		return
+	}
+	if err := f.Sync(); err != nil {
+		log.Printf("Warning: Could not flush transcript %s: %v", path, err)
+	}
+}
@@ -0,0 +1,174 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/henryhwang/chatbot/internal/types"
+)
+
+// --- HTTP Transport ---
+
+// networkKey identifies a customised network transport.
+type networkKey struct {
+	proxyURL       string
+	connectTimeout time.Duration
+	headerTimeout  time.Duration
+}
+
+// Transports with an explicit PROXY_URL or timeouts, shared so connections are pooled
+var (
+	networkMu         sync.Mutex
+	networkTransports = map[networkKey]*http.Transport{}
+)
+
+// networkTransport returns the transport for real network requests. Without
+// proxyURL, the proxy comes from HTTP_PROXY, HTTPS_PROXY and NO_PROXY (as with
+// http.DefaultTransport); otherwise every request goes through proxyURL.
+// A connectTimeout above 0 limits how long establishing a connection may take,
+// and a headerTimeout above 0 how long the response headers may take after
+// the request is sent. Neither limits the body, so a long stream runs on.
+func networkTransport(proxyURL string, connectTimeout, headerTimeout time.Duration) (http.RoundTripper, error) {
+	if proxyURL == "" && connectTimeout <= 0 && headerTimeout <= 0 {
+		return http.DefaultTransport, nil
+	}
+	key := networkKey{proxyURL: proxyURL, connectTimeout: connectTimeout, headerTimeout: headerTimeout}
+	networkMu.Lock()
+	defer networkMu.Unlock()
+	if transport, ok := networkTransports[key]; ok {
+		return transport, nil
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if proxyURL != "" {
+		proxy, err := url.Parse(proxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL '%s': %w", proxyURL, err)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	if connectTimeout > 0 {
+		dialer := &net.Dialer{Timeout: connectTimeout, KeepAlive: 30 * time.Second} // Keep-alive as in http.DefaultTransport
+		transport.DialContext = dialer.DialContext
+	}
+	transport.ResponseHeaderTimeout = max(headerTimeout, 0)
+	networkTransports[key] = transport
+	return transport, nil
+}
+
+// transportFor returns the transport requests should use given settings:
+// replay, recording or the network, through the configured proxy. Network
+// requests are subject to RATE_LIMIT_RPS; replayed ones are not.
+func transportFor(settings types.Settings) (http.RoundTripper, error) {
+	network, err := networkTransport(settings.ProxyURL, settings.ConnectTimeout, settings.RequestTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return sessionTransport(settings.RecordSession, settings.ReplaySession, withRateLimit(network, settings.RateLimitRPS))
+}
+
+// clientKey is the part of the settings that determines how a client is built.
+type clientKey struct {
+	proxyURL       string
+	connectTimeout time.Duration
+	recordPath     string
+	replayPath     string
+	timeout        time.Duration
+	rateLimit      float64
+	debug          bool
+}
+
+// Clients built so far, so requests with the same settings share one client
+// (and its connection pool) rather than creating a client per call
+var (
+	clientMu sync.Mutex
+	clients  = map[clientKey]*http.Client{}
+)
+
+// HTTPClient returns the client every provider request goes through (chat,
+// completions and model lists), configured from settings: proxy, recording or
+// replay, rate limit, debug logging, ConnectTimeout and RequestTimeout (for
+// the response headers). It sets no overall timeout, which would cut off long
+// streams; StreamIdleTimeout catches stalled ones instead. It is built once
+// per configuration and reused after that.
+func HTTPClient(settings types.Settings) (*http.Client, error) {
+	key := clientKey{
+		proxyURL:       settings.ProxyURL,
+		connectTimeout: settings.ConnectTimeout,
+		recordPath:     settings.RecordSession,
+		replayPath:     settings.ReplaySession,
+		timeout:        settings.RequestTimeout,
+		rateLimit:      settings.RateLimitRPS,
+		debug:          debugLog != nil,
+	}
+	clientMu.Lock()
+	defer clientMu.Unlock()
+	if client, ok := clients[key]; ok {
+		return client, nil
+	}
+
+	transport, err := transportFor(settings)
+	if err != nil {
+		return nil, fmt.Errorf("error preparing HTTP transport: %w", err)
+	}
+	client := &http.Client{Transport: withDebugLogging(transport)}
+	clients[key] = client
+	return client, nil
+}
+
+// IdleTimeoutError reports a stream that stopped sending data. It is a
+// timeout (see IsTimeout), so the turn's user message is kept for a retry.
+type IdleTimeoutError struct {
+	After time.Duration // How long the stream was silent
+}
+
+func (e *IdleTimeoutError) Error() string {
+	return fmt.Sprintf("no data received for %s, the stream appears stalled", e.After)
+}
+
+func (e *IdleTimeoutError) Timeout() bool   { return true }
+func (e *IdleTimeoutError) Temporary() bool { return false }
+
+// idleTimeoutBody closes a response body when no bytes arrive for timeout, so
+// a stalled stream fails promptly while a long, active one may run on.
+type idleTimeoutBody struct {
+	io.ReadCloser
+	timeout time.Duration
+	timer   *time.Timer
+	expired atomic.Bool
+}
+
+// withIdleTimeout wraps body with idle detection; a timeout of 0 disables it.
+func withIdleTimeout(body io.ReadCloser, timeout time.Duration) io.ReadCloser {
+	if timeout <= 0 {
+		return body
+	}
+	b := &idleTimeoutBody{ReadCloser: body, timeout: timeout}
+	b.timer = time.AfterFunc(timeout, func() {
+		b.expired.Store(true)
+		body.Close() // Unblocks the pending Read
+	})
+	return b
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if b.expired.Load() {
+		return n, &IdleTimeoutError{After: b.timeout}
+	}
+	if n > 0 {
+		b.timer.Reset(b.timeout) // Any data, even an SSE keep-alive, counts as activity
+	}
+	return n, err
+}
+
+func (b *idleTimeoutBody) Close() error {
+	b.timer.Stop()
+	return b.ReadCloser.Close()
+}
@@ -0,0 +1,195 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/henryhwang/chatbot/internal/conversation"
+	"github.com/henryhwang/chatbot/internal/types"
+)
+
+// slowServer answers after headerDelay with chunks lines of data, one every
+// interval, then stalls for stall before ending the response.
+func slowServer(headerDelay time.Duration, chunks int, interval, stall time.Duration) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(headerDelay)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		for i := 0; i < chunks; i++ {
+			time.Sleep(interval)
+			fmt.Fprintf(w, "data: %d\n\n", i)
+			w.(http.Flusher).Flush()
+		}
+		time.Sleep(stall)
+	}))
+}
+
+func TestRequestTimeouts(t *testing.T) {
+	tests := []struct {
+		name        string
+		headerDelay time.Duration
+		chunks      int
+		interval    time.Duration
+		stall       time.Duration
+		wantErr     func(error) bool // nil means the whole body must arrive
+	}{
+		{
+			name:     "stream outlasting REQUEST_TIMEOUT keeps going while data flows",
+			chunks:   8,
+			interval: 50 * time.Millisecond, // 400ms in total, twice REQUEST_TIMEOUT
+		},
+		{
+			name:        "slow response headers hit REQUEST_TIMEOUT",
+			headerDelay: 500 * time.Millisecond,
+			wantErr:     IsTimeout,
+		},
+		{
+			name:     "stalled stream hits STREAM_IDLE_TIMEOUT",
+			chunks:   1,
+			interval: 10 * time.Millisecond,
+			stall:    500 * time.Millisecond,
+			wantErr: func(err error) bool {
+				var idle *IdleTimeoutError
+				return errors.As(err, &idle) && IsTimeout(err)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := slowServer(tt.headerDelay, tt.chunks, tt.interval, tt.stall)
+			defer srv.Close()
+			settings := types.Settings{RequestTimeout: 200 * time.Millisecond, StreamIdleTimeout: 150 * time.Millisecond}
+			client, err := HTTPClient(settings)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, srv.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var body []byte
+			resp, err := executeAPIRequest(context.Background(), client, settings, req)
+			if err == nil {
+				body, err = io.ReadAll(resp.Body)
+				resp.Body.Close()
+			}
+			if tt.wantErr != nil {
+				if err == nil || !tt.wantErr(err) {
+					t.Fatalf("got error %v, want a timeout", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("stream was cut off: %v", err)
+			}
+			if got := strings.Count(string(body), "data: "); got != tt.chunks {
+				t.Errorf("got %d events, want %d", got, tt.chunks)
+			}
+		})
+	}
+}
+
+func TestProxyURL(t *testing.T) {
+	// The proxy answers every request itself, recording the URLs it was asked for
+	var mu sync.Mutex
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		mu.Lock()
+		proxied = append(proxied, r.Method+" "+r.URL.String())
+		mu.Unlock()
+		if strings.HasSuffix(r.URL.Path, "/models") {
+			io.WriteString(w, `{"data":[{"id":"m"}]}`)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, sseChunk("via proxy")+"data: [DONE]\n\n")
+	}))
+	defer proxy.Close()
+
+	provider := types.ModelProvider{
+		UrlBase: "http://api.example.invalid",
+		APIs:    map[string]string{"chat": "/v1/chat/completions", "models": "/v1/models"},
+		Model:   "m",
+		Format:  "openai",
+	}
+	settings := types.Settings{ProxyURL: proxy.URL}
+
+	var renderer recordingRenderer
+	conv := conversation.NewConversation("", &conversation.SimpleTruncationStrategy{}, 10000)
+	if err := QueryHandler(context.Background(), conv, "hi", provider, settings, &renderer); err != nil {
+		t.Fatal(err)
+	}
+	if renderer.content.String() != "via proxy" {
+		t.Errorf("got %q, want the proxy's reply", renderer.content.String())
+	}
+
+	// The model list (as /list fetches it) goes through the same client
+	req, err := NewModelListRequest(context.Background(), provider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := HTTPClient(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	want := []string{"POST http://api.example.invalid/v1/chat/completions", "GET http://api.example.invalid/v1/models"}
+	if fmt.Sprint(proxied) != fmt.Sprint(want) {
+		t.Errorf("proxy saw %q, want %q", proxied, want)
+	}
+}
+
+func TestNetworkTransportProxy(t *testing.T) {
+	tests := []struct {
+		name      string
+		proxyURL  string
+		wantProxy string // "" for the environment's proxy settings
+		wantErr   bool
+	}{
+		{name: "environment", wantProxy: ""},
+		{name: "explicit", proxyURL: "http://proxy.example:3128", wantProxy: "http://proxy.example:3128"},
+		{name: "invalid", proxyURL: "http://[::1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt, err := networkTransport(tt.proxyURL, time.Second, 0)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("got no error for an invalid proxy URL")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			transport := rt.(*http.Transport)
+			if transport.Proxy == nil {
+				t.Fatal("transport has no proxy function, so HTTP_PROXY and HTTPS_PROXY are ignored")
+			}
+			if tt.wantProxy == "" {
+				return
+			}
+			req, _ := http.NewRequest(http.MethodGet, "https://api.example.com/v1/models", nil)
+			proxy, err := transport.Proxy(req)
+			if err != nil || proxy == nil || proxy.String() != tt.wantProxy {
+				t.Errorf("proxy for request is %v (%v), want %s", proxy, err, tt.wantProxy)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package api
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+)
+
+// --- Word Wrapping ---
+
+// WrapRenderer word-wraps content to a fixed width before passing it on.
+// Because content arrives in arbitrary chunks, each word is held until the
+// space or newline after it shows whether it still fits on the line. Fenced
+// code blocks are passed through unwrapped.
+type WrapRenderer struct {
+	OutputRenderer
+
+	width       int // Columns available
+	prefixWidth int // Columns taken by the "Bot:" prefix before the first line
+
+	started    bool // Content has begun since the last reasoning or tool call
+	col        int  // Current column
+	wordOnLine bool // A word was printed on the current line
+	inCode     bool
+	line       strings.Builder // Current line so far, to recognise fences
+	word       strings.Builder // Word held until it ends
+	spaces     string          // Whitespace held before the word
+}
+
+// NewWrapRenderer wraps next so content lines are at most width columns,
+// counting prefixWidth columns taken before the first line.
+func NewWrapRenderer(next OutputRenderer, width, prefixWidth int) *WrapRenderer {
+	return &WrapRenderer{OutputRenderer: next, width: width, prefixWidth: prefixWidth}
+}
+
+// terminalWidth returns the width of the terminal f, falling back to
+// $COLUMNS, or 0 if neither is known.
+func terminalWidth(f *os.File) int {
+	if width, _, err := term.GetSize(int(f.Fd())); err == nil && width > 0 {
+		return width
+	}
+	if width, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && width > 0 {
+		return width
+	}
+	return 0
+}
+
+func (w *WrapRenderer) OnContent(chunk string) {
+	if !w.started {
+		w.started = true
+		w.col, w.wordOnLine = w.prefixWidth, false
+	}
+
+	var out strings.Builder
+	for _, r := range chunk {
+		if w.inCode {
+			out.WriteRune(r)
+			if r == '\n' {
+				w.endLine()
+			} else {
+				w.line.WriteRune(r)
+			}
+			continue
+		}
+		switch r {
+		case '\n':
+			w.flushWord(&out)
+			w.spaces = "" // Trailing whitespace is dropped
+			out.WriteRune(r)
+			w.endLine()
+		case ' ', '\t':
+			w.flushWord(&out)
+			w.spaces += string(r)
+			w.line.WriteRune(r)
+		default:
+			w.word.WriteRune(r)
+			w.line.WriteRune(r)
+		}
+	}
+	if out.Len() > 0 {
+		w.OutputRenderer.OnContent(out.String())
+	}
+}
+
+func (w *WrapRenderer) OnReasoning(chunk string) {
+	w.flush()
+	w.started = false // The content that follows starts after a fresh prefix
+	w.OutputRenderer.OnReasoning(chunk)
+}
+
+func (w *WrapRenderer) OnToolCall(name, arguments, output string) {
+	w.flush()
+	w.started = false
+	w.OutputRenderer.OnToolCall(name, arguments, output)
+}
+
+func (w *WrapRenderer) OnDone(result StreamResult, err error) {
+	w.flush()
+	w.OutputRenderer.OnDone(result, err)
+}
+
+// flushWord writes the held word, starting a new line first if it doesn't
+// fit. A word longer than the whole width is printed on its own line as is.
+func (w *WrapRenderer) flushWord(out *strings.Builder) {
+	if w.word.Len() == 0 {
+		return
+	}
+	word := w.word.String()
+	w.word.Reset()
+	length := utf8.RuneCountInString(word)
+	if w.wordOnLine && w.col+len(w.spaces)+length > w.width {
+		out.WriteByte('\n')
+		w.col = 0
+	} else {
+		out.WriteString(w.spaces)
+		w.col += len(w.spaces)
+	}
+	w.spaces = ""
+	out.WriteString(word)
+	w.col += length
+	w.wordOnLine = true
+}
+
+// flush writes whatever is held, e.g. before output other than content.
+func (w *WrapRenderer) flush() {
+	var out strings.Builder
+	w.flushWord(&out)
+	out.WriteString(w.spaces)
+	w.spaces = ""
+	if out.Len() > 0 {
+		w.OutputRenderer.OnContent(out.String())
+	}
+}
+
+// endLine resets the column after a newline and tracks fenced code blocks.
+func (w *WrapRenderer) endLine() {
+	if isFence(w.line.String()) {
+		w.inCode = !w.inCode
+	}
+	w.line.Reset()
+	w.col, w.wordOnLine = 0, false
+}
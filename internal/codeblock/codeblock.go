@@ -0,0 +1,86 @@
+package codeblock
+
+import (
+	"strings"
+)
+
+// Block is a fenced code block found in markdown text.
+type Block struct {
+	Lang string // Language tag from the opening fence (may be empty)
+	Code string // Contents between the fences, without the fences themselves
+}
+
+// Extract returns all fenced (```) code blocks in text, in order.
+// An unterminated final block is returned with the content seen so far.
+func Extract(text string) []Block {
+	blocks := []Block{}
+	var current *Block
+	var body []string
+
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if current == nil {
+			if strings.HasPrefix(trimmed, "```") {
+				current = &Block{}
+				if info := strings.Fields(strings.TrimPrefix(trimmed, "```")); len(info) > 0 {
+					current.Lang = info[0]
+				}
+				body = nil
+			}
+			continue
+		}
+		if trimmed == "```" {
+			current.Code = strings.Join(body, "\n")
+			blocks = append(blocks, *current)
+			current = nil
+			continue
+		}
+		body = append(body, line)
+	}
+
+	if current != nil {
+		current.Code = strings.Join(body, "\n")
+		blocks = append(blocks, *current)
+	}
+	return blocks
+}
+
+// Map of common fence language tags to file extensions
+var extensions = map[string]string{
+	"go":         ".go",
+	"golang":     ".go",
+	"python":     ".py",
+	"py":         ".py",
+	"javascript": ".js",
+	"js":         ".js",
+	"typescript": ".ts",
+	"ts":         ".ts",
+	"rust":       ".rs",
+	"java":       ".java",
+	"c":          ".c",
+	"cpp":        ".cpp",
+	"c++":        ".cpp",
+	"ruby":       ".rb",
+	"sh":         ".sh",
+	"bash":       ".sh",
+	"shell":      ".sh",
+	"json":       ".json",
+	"yaml":       ".yaml",
+	"yml":        ".yaml",
+	"toml":       ".toml",
+	"html":       ".html",
+	"css":        ".css",
+	"sql":        ".sql",
+	"markdown":   ".md",
+	"md":         ".md",
+	"dockerfile": ".dockerfile",
+}
+
+// Extension returns the file extension for a fence language tag,
+// or ".txt" when the language is unknown or empty.
+func Extension(lang string) string {
+	if ext, ok := extensions[strings.ToLower(strings.TrimSpace(lang))]; ok {
+		return ext
+	}
+	return ".txt"
+}
@@ -0,0 +1,49 @@
+package codeblock
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtract(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []Block
+	}{
+		{"none", "Just prose.", []Block{}},
+		{"single", "Here:\n```go\nfunc main() {}\n```\nDone.", []Block{{Lang: "go", Code: "func main() {}"}}},
+		{
+			name: "several",
+			text: "```python\nprint(1)\n```\nand\n```\nplain\ntext\n```",
+			want: []Block{{Lang: "python", Code: "print(1)"}, {Lang: "", Code: "plain\ntext"}},
+		},
+		{"info string after the language", "```js title=\"a.js\"\nx()\n```", []Block{{Lang: "js", Code: "x()"}}},
+		{"indented fences", "  ```sh\n  ls\n  ```", []Block{{Lang: "sh", Code: "  ls"}}},
+		{"unterminated", "```rust\nfn main() {", []Block{{Lang: "rust", Code: "fn main() {"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Extract(tt.text); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Extract() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtension(t *testing.T) {
+	tests := map[string]string{
+		"go":      ".go",
+		"Python":  ".py",
+		" ts ":    ".ts",
+		"c++":     ".cpp",
+		"yml":     ".yaml",
+		"":        ".txt",
+		"unknown": ".txt",
+	}
+	for lang, want := range tests {
+		if got := Extension(lang); got != want {
+			t.Errorf("Extension(%q) = %q, want %q", lang, got, want)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package commands
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/henryhwang/chatbot/internal/types"
+)
+
+// --- Prompt Aliases ---
+
+// LoadAliases reads the aliases saved in state.Settings.AliasesFile into
+// state. A missing file simply means no aliases have been defined yet.
+func LoadAliases(state *types.RuntimeState) error {
+	path := state.Settings.AliasesFile
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	aliases := map[string]string{}
+	if err := json.Unmarshal(data, &aliases); err != nil {
+		return fmt.Errorf("invalid aliases file %s: %w", path, err)
+	}
+	state.Aliases = aliases
+	return nil
+}
+
+// saveAliases writes the aliases to state.Settings.AliasesFile, if set.
+func saveAliases(state *types.RuntimeState) error {
+	path := state.Settings.AliasesFile
+	if path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(state.Aliases, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0600)
+}
+
+// ExpandAlias turns "/name rest" into a prompt when name is an alias: the
+// alias text, followed by rest on a new line. It reports false for anything
+// else, including built-in commands, which aliases can never shadow.
+func ExpandAlias(state *types.RuntimeState, input string) (string, bool) {
+	if !strings.HasPrefix(input, "/") {
+		return "", false
+	}
+	fields := strings.Fields(strings.TrimPrefix(input, "/"))
+	if len(fields) == 0 {
+		return "", false
+	}
+	name := fields[0]
+	rest := strings.TrimPrefix(strings.TrimPrefix(input, "/"), name)
+	text, ok := state.Aliases[name]
+	if _, isCommand := commands[name]; !ok || isCommand {
+		return "", false
+	}
+	if rest = strings.TrimSpace(rest); rest != "" {
+		text += "\n" + rest
+	}
+	return text, true
+}
+
+// Command to list aliases or define one, e.g. /alias explain "Explain this code:"
+func defineAlias(ctx *CommandContext, args []string) error {
+	state := ctx.State
+	if len(args) == 0 {
+		if len(state.Aliases) == 0 {
+			fmt.Fprintln(ctx.Out, "Bot: No aliases defined. Use /alias <name> <text> to add one.")
+			return nil
+		}
+		names := make([]string, 0, len(state.Aliases))
+		for name := range state.Aliases {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Fprintln(ctx.Out, "Aliases:")
+		for _, name := range names {
+			fmt.Fprintf(ctx.Out, "  /%s - %s\n", name, state.Aliases[name])
+		}
+		return nil
+	}
+
+	name := strings.TrimPrefix(args[0], "/")
+	text := strings.TrimSpace(strings.TrimPrefix(ctx.ArgText, args[0]))
+	if len(text) >= 2 && text[0] == '"' && text[len(text)-1] == '"' {
+		text = text[1 : len(text)-1]
+	}
+	if text == "" {
+		return usageError("/alias <name> <text>")
+	}
+	if _, isCommand := commands[name]; isCommand {
+		return fmt.Errorf("/%s is a built-in command and can't be used as an alias", name)
+	}
+
+	if state.Aliases == nil {
+		state.Aliases = make(map[string]string)
+	}
+	state.Aliases[name] = text
+	if err := saveAliases(state); err != nil {
+		return fmt.Errorf("alias defined for this session, but saving it failed: %w", err)
+	}
+	fmt.Fprintf(ctx.Out, "Bot: Defined /%s. Use /%s <text> to send it followed by your text.\n", name, name)
+	return nil
+}
+
+// Command to remove an alias
+func removeAlias(ctx *CommandContext, args []string) error {
+	if len(args) != 1 {
+		return usageError("/unalias <name>")
+	}
+	name := strings.TrimPrefix(args[0], "/")
+	if _, ok := ctx.State.Aliases[name]; !ok {
+		fmt.Fprintf(ctx.Out, "Bot: No alias named '%s'. Use /alias to list aliases.\n", name)
+		return nil
+	}
+	delete(ctx.State.Aliases, name)
+	if err := saveAliases(ctx.State); err != nil {
+		return fmt.Errorf("alias removed for this session, but saving failed: %w", err)
+	}
+	fmt.Fprintf(ctx.Out, "Bot: Removed alias /%s.\n", name)
+	return nil
+}
@@ -0,0 +1,113 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/henryhwang/chatbot/internal/api"
+	"github.com/henryhwang/chatbot/internal/types"
+)
+
+// --- Provider Benchmark ---
+
+// Runs used by /bench when no count is given
+const defaultBenchRuns = 3
+
+// Command to measure the active provider's latency: the prompt is sent
+// several times, one after another, and time to first output and total time
+// are summarised. Bench requests are not added to the conversation.
+func benchProvider(ctx *CommandContext, args []string) error {
+	state := ctx.State
+	usage := usageError(`/bench "<prompt>" [runs]`)
+
+	prompt, runs := strings.TrimSpace(ctx.ArgText), defaultBenchRuns
+	if len(args) > 1 {
+		if n, err := strconv.Atoi(args[len(args)-1]); err == nil {
+			if n <= 0 {
+				return usage
+			}
+			prompt = strings.TrimSpace(strings.TrimSuffix(prompt, args[len(args)-1]))
+			runs = n
+		}
+	}
+	if unquoted, err := strconv.Unquote(prompt); err == nil {
+		prompt = unquoted
+	}
+	if prompt == "" {
+		return usage
+	}
+
+	messages := []types.Message{{Role: "user", Content: prompt}}
+	if system := ctx.Conversation.GetSystemPrompt(); system != "" {
+		messages = append([]types.Message{{Role: "system", Content: system}}, messages...)
+	}
+
+	// Ctrl-C stops the benchmark; completed runs are still summarised
+	benchCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	fmt.Fprintf(ctx.Out, "Bot: Benchmarking %s with %d run(s)...\n", state.Provider.Model, runs)
+	var firstChunks, totals []time.Duration
+	failures := 0
+	for i := 1; i <= runs; i++ {
+		timing, err := api.CompleteTimed(benchCtx, state.Provider, state.Settings, messages)
+		if errors.Is(err, context.Canceled) {
+			fmt.Fprintln(ctx.Out, "Bot: Benchmark cancelled.")
+			break
+		}
+		if err != nil {
+			failures++
+			fmt.Fprintf(ctx.Out, "  Run %d: error: %v\n", i, err)
+			continue
+		}
+		firstChunks = append(firstChunks, timing.FirstChunk)
+		totals = append(totals, timing.Total)
+		fmt.Fprintf(ctx.Out, "  Run %d: first output %s, total %s\n", i, formatLatency(timing.FirstChunk), formatLatency(timing.Total))
+	}
+
+	if len(totals) == 0 {
+		fmt.Fprintln(ctx.Out, "Bot: No run completed.")
+		return nil
+	}
+	w := tabwriter.NewWriter(ctx.Out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "\tmin\tavg\tp95\tmax")
+	for _, row := range []struct {
+		name    string
+		samples []time.Duration
+	}{{"First output", firstChunks}, {"Total", totals}} {
+		fastest, mean, p95, slowest := latencyStats(row.samples)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", row.name, formatLatency(fastest), formatLatency(mean), formatLatency(p95), formatLatency(slowest))
+	}
+	w.Flush()
+	if failures > 0 {
+		fmt.Fprintf(ctx.Out, "Bot: %d of %d run(s) failed and are not included.\n", failures, failures+len(totals))
+	}
+	return nil
+}
+
+// latencyStats returns the minimum, mean, 95th percentile (nearest rank) and
+// maximum of samples, which must not be empty.
+func latencyStats(samples []time.Duration) (fastest, mean, p95, slowest time.Duration) {
+	sorted := append([]time.Duration(nil), samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	var sum time.Duration
+	for _, d := range sorted {
+		sum += d
+	}
+	rank := int(math.Ceil(0.95*float64(len(sorted)))) - 1
+	return sorted[0], sum / time.Duration(len(sorted)), sorted[rank], sorted[len(sorted)-1]
+}
+
+// formatLatency renders a duration to the millisecond, e.g. "1.234s" or "87ms".
+func formatLatency(d time.Duration) string {
+	return d.Round(time.Millisecond).String()
+}
@@ -0,0 +1,82 @@
+package commands
+
+import (
+	"fmt"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/henryhwang/chatbot/internal/types"
+)
+
+// --- Conversation Branches ---
+
+// Command to save the conversation as a branch, e.g. before exploring a tangent
+func saveBranch(ctx *CommandContext, args []string) error {
+	if len(args) != 1 {
+		return usageError("/branch <name>")
+	}
+	state := ctx.State
+	name := args[0]
+	branch := ctx.Conversation.Branch()
+	branch.SystemPromptTemplate = state.SystemPromptTemplate
+	_, replaced := state.Branches[name]
+	if state.Branches == nil {
+		state.Branches = make(map[string]types.Branch)
+	}
+	state.Branches[name] = branch
+	state.CurrentBranch = name
+
+	verb := "Saved"
+	if replaced {
+		verb = "Updated"
+	}
+	fmt.Fprintf(ctx.Out, "Bot: %s branch '%s' (%d messages). Use /checkout %s to return to it.\n", verb, name, len(branch.History), name)
+	return nil
+}
+
+// Command to list the saved branches; the current one is marked with "*"
+func listBranches(ctx *CommandContext, args []string) error {
+	state := ctx.State
+	if len(state.Branches) == 0 {
+		fmt.Fprintln(ctx.Out, "Bot: No branches saved. Use /branch <name> to save one.")
+		return nil
+	}
+	names := make([]string, 0, len(state.Branches))
+	for name := range state.Branches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(ctx.Out, "Branches:")
+	table := tabwriter.NewWriter(ctx.Out, 0, 0, 2, ' ', 0)
+	for _, name := range names {
+		branch := state.Branches[name]
+		marker := " "
+		if name == state.CurrentBranch {
+			marker = "*"
+		}
+		fmt.Fprintf(table, "%s %s\t%d messages\tsaved %s\n", marker, name, len(branch.History), branch.Created.Format("15:04:05"))
+	}
+	table.Flush()
+	return nil
+}
+
+// Command to replace the conversation with a saved branch. The branch itself
+// is kept, so it can be checked out again after moving on from it.
+func checkoutBranch(ctx *CommandContext, args []string) error {
+	if len(args) != 1 {
+		return usageError("/checkout <name>")
+	}
+	state := ctx.State
+	name := args[0]
+	branch, ok := state.Branches[name]
+	if !ok {
+		fmt.Fprintf(ctx.Out, "Bot: No branch named '%s'. Use /branches to list them.\n", name)
+		return nil
+	}
+	ctx.Conversation.Checkout(branch)
+	state.SystemPromptTemplate = branch.SystemPromptTemplate
+	state.CurrentBranch = name
+	fmt.Fprintf(ctx.Out, "Bot: Checked out branch '%s' (%d messages).\n", name, len(branch.History))
+	return nil
+}
@@ -1,153 +1,932 @@
 package commands
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
 
+	"github.com/henryhwang/chatbot/internal/api"
+	"github.com/henryhwang/chatbot/internal/codeblock"
+	"github.com/henryhwang/chatbot/internal/config"
+	"github.com/henryhwang/chatbot/internal/conversation"
+	"github.com/henryhwang/chatbot/internal/export"
+	"github.com/henryhwang/chatbot/internal/models"
 	"github.com/henryhwang/chatbot/internal/types"
+	"github.com/henryhwang/chatbot/internal/version"
+
+	"golang.org/x/sync/errgroup"
 )
 
 // --- Command Handling ---
 
-// Define the type for command functions
-type CommandFunc func(...interface{})
+// CommandContext carries what commands act on: the runtime state (active
+// provider and settings), the conversation, and where to write output.
+type CommandContext struct {
+	State        *types.RuntimeState
+	Conversation *conversation.Conversation
+	Out          io.Writer
 
-// Map commands (strings) to their corresponding functions
-var commands = map[string]CommandFunc{
-	"list":      listModels,   // List available models from the provider
-	"show":      showProvider, // Show current provider configuration details
-	"showModel": showModel,    // Show the currently configured model name
-	"exit":      exitCmd,      // Exit the application
-	"help":      showHelp,     // Show available commands
-	// Add new commands here
+	ArgText string // Everything after the command name, spacing and newlines intact (set by RunCmd)
 }
 
-// Executes a command based on user input
-func RunCmd(command string, args ...interface{}) {
-	if cmdFunc, ok := commands[command]; ok {
-		cmdFunc(args...) // Pass the arguments (which include the provider)
-	} else {
-		fmt.Println("Bot: Unknown command:", command)
-		showHelp() // Show help on unknown command
-	}
+// CommandFunc runs a command with its arguments (the words after the command
+// name; commands taking free text use ctx.ArgText instead). A returned error
+// is reported to the user by RunCmd. Commands without arguments ignore args.
+type CommandFunc func(ctx *CommandContext, args []string) error
+
+// usageError is returned when a command is invoked with invalid arguments;
+// RunCmd prints it as a usage line rather than an error.
+type usageError string
+
+func (e usageError) Error() string { return "usage: " + string(e) }
+
+// Command describes a command for dispatch and for /help.
+type Command struct {
+	Name        string      // Invoked as /Name
+	Args        string      // Argument usage hint, e.g. "[N] [--full]" ("" if it takes none)
+	Description string      // One-line summary shown by /help
+	Run         CommandFunc // Runs the command
 }
 
-// --- Command Implementations ---
+// Registered commands by name
+var commands = map[string]Command{}
 
-// Command to list models (if supported by the API)
-func listModels(args ...interface{}) {
-	if len(args) == 0 {
-		fmt.Println("Bot: Internal error: Provider info missing for listModels.")
-		return
+// Register adds a command, replacing any command already registered under
+// its name. /help lists every registered command, so it never goes stale.
+func Register(cmd Command) {
+	commands[cmd.Name] = cmd
+}
+
+// Built-in commands
+func init() {
+	Register(Command{Name: "list", Description: "List available models from the provider.", Run: listModels})
+	Register(Command{Name: "show", Description: "Show the current provider configuration.", Run: showProvider})
+	Register(Command{Name: "showModel", Description: "Show the currently selected model.", Run: showModel})
+	Register(Command{Name: "provider", Args: "[name]", Description: "List configured providers, or switch to the named one.", Run: switchProvider})
+	Register(Command{Name: "model", Args: "[name]", Description: "Show the current model, or switch the active provider to another model.", Run: switchModel})
+	Register(Command{Name: "ping", Description: "Check that the provider is reachable and accepts the API key, with the request's status and latency.", Run: pingProvider})
+	Register(Command{Name: "bench", Args: `"<prompt>" [runs]`, Description: "Send a prompt several times and summarise time to first output and total time (not added to history).", Run: benchProvider})
+	Register(Command{Name: "compare", Args: "<models...> [-- prompt]", Description: "Ask two or more models the same prompt (not added to history).", Run: compareModels})
+	Register(Command{Name: "system", Args: "[text]", Description: "Show the system prompt, or replace it ('/system -' removes it; {{.Name}} uses a /vars value).", Run: systemPrompt})
+	Register(Command{Name: "vars", Args: "[name value|-]", Description: "List, set or remove ('-') variables for {{.Name}} placeholders in the system prompt.", Run: promptVars})
+	Register(Command{Name: "history", Args: "[N] [--full] [--tokens]", Description: "Show the stored history (last N messages; --full disables truncation; --tokens shows token counts and the context cutoff).", Run: showHistory})
+	Register(Command{Name: "clear", Description: "Clear the conversation history (the system prompt is kept).", Run: clearConversation})
+	Register(Command{Name: "tokens", Description: "Show the estimated context size against the token budget.", Run: showTokens})
+	Register(Command{Name: "usage", Description: "Show token usage for the last request and the session total.", Run: showUsage})
+	Register(Command{Name: "set", Args: "[param value]", Description: "Show or set temperature, top_p, max_tokens, presence_penalty, seed, n (answers per request), stop (comma-separated) or format (json, schema <file> or text); 'off' unsets.", Run: setParam})
+	Register(Command{Name: "regenerate-with", Args: "<param=value>...", Description: "Resend your last message with parameters overridden for that request only (e.g. temperature=1.2).", Run: regenerateWith})
+	Register(Command{Name: "file", Args: "[--truncate] <path> <question>", Description: "Ask about a text file: its contents are sent in a code block, followed by your question.", Run: askAboutFile})
+	Register(Command{Name: "files", Args: "<pattern> <question>", Description: "Ask about the files matching a glob (e.g. src/*.go), each in its own code block, as many as fit in the context budget.", Run: askAboutFiles})
+	Register(Command{Name: "edit", Description: "Edit your last message in $EDITOR (or inline) and send it again in place of the original turn.", Run: editLastMessage})
+	Register(Command{Name: "branch", Args: "<name>", Description: "Save a copy of the conversation under name, to return to with /checkout.", Run: saveBranch})
+	Register(Command{Name: "branches", Description: "List the branches saved with /branch.", Run: listBranches})
+	Register(Command{Name: "checkout", Args: "<name>", Description: "Replace the conversation with the named branch (save the current one with /branch first to keep it).", Run: checkoutBranch})
+	Register(Command{Name: "raw", Args: "[file]", Description: "Send a JSON request body (from file, or typed in) to the chat endpoint as is and stream the response (not added to history).", Run: sendRaw})
+	Register(Command{Name: "save", Args: "[file]", Description: "Save the conversation history as JSON (default: chat-<timestamp>.json).", Run: saveConversation})
+	Register(Command{Name: "export", Args: "[file]", Description: "Export the conversation as markdown (default: chat-<timestamp>.md).", Run: exportMarkdown})
+	Register(Command{Name: "profile", Args: "context", Description: "Time context assembly and token counting over the history.", Run: profile})
+	Register(Command{Name: "write", Args: "[-a] [-f] <file>", Description: "Save code from the last response (-a all blocks, -f overwrite).", Run: writeCode})
+	Register(Command{Name: "alias", Args: "[name text]", Description: "List prompt aliases, or define /name as a shortcut that sends text before your input.", Run: defineAlias})
+	Register(Command{Name: "unalias", Args: "<name>", Description: "Remove a prompt alias.", Run: removeAlias})
+	Register(Command{Name: "version", Description: "Show the version, commit and Go toolchain of this build.", Run: showVersion})
+	Register(Command{Name: "help", Description: "Display this help message.", Run: showHelp})
+	Register(Command{Name: "exit", Description: "Quit the chatbot.", Run: exitCmd})
+}
+
+// readLine reads one line of input after showing a prompt. It defaults to
+// plain stdin; the REPL replaces it so commands share its line editor.
+var readLine = func(prompt string) (string, error) {
+	fmt.Print(prompt)
+	return bufio.NewReader(os.Stdin).ReadString('\n')
+}
+
+// SetLineReader makes commands share the REPL's reader so buffered input isn't lost.
+func SetLineReader(fn func(prompt string) (string, error)) {
+	readLine = fn
+}
+
+// confirm asks a yes/no question and reports whether the user answered yes.
+func confirm(question string) bool {
+	answer, err := readLine(question + " [y/N]: ")
+	if err != nil && answer == "" {
+		fmt.Println()
+		return false
 	}
-	provider, ok := args[0].(types.ModelProvider)
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// Executes a command based on user input.
+// The input is split into the command name and its arguments, and any error
+// the command returns is reported in the same form for every command.
+func RunCmd(ctx *CommandContext, input string) {
+	fields := strings.Fields(input)
+	command := ""
+	if len(fields) > 0 {
+		command = fields[0]
+	}
+	ctx.ArgText = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(input), command))
+	cmd, ok := commands[command]
 	if !ok {
-		fmt.Println("Bot: Internal error: Invalid argument type for listModels.")
+		fmt.Fprintln(ctx.Out, "Bot: Unknown command:", command)
+		showHelp(ctx, nil) // Show help on unknown command
 		return
 	}
 
-	// Check if a specific 'models' endpoint is defined in APIS map
-	modelsPath, pathOk := provider.APIs["list"]
-	if !pathOk {
-		// Fallback to a common default path, adjust if needed
-		modelsPath = "/v1/models"
-		log.Printf("Warning: 'models' endpoint not explicitly defined in APIS env var, trying default '%s'", modelsPath)
+	var usage usageError
+	if err := cmd.Run(ctx, fields[1:]); errors.As(err, &usage) {
+		fmt.Fprintln(ctx.Out, "Bot: Usage:", string(usage))
+	} else if err != nil {
+		fmt.Fprintln(ctx.Out, "Bot: Error:", err)
 	}
-	url := provider.UrlBase + modelsPath
+}
 
-	req, err := http.NewRequest("GET", url, nil)
+// --- Command Implementations ---
+
+// Command to list models (if supported by the API)
+func listModels(ctx *CommandContext, args []string) error {
+	state := ctx.State
+	provider := state.Provider
+
+	if _, pathOk := api.ModelListPath(provider); !pathOk {
+		log.Printf("Warning: 'models' endpoint not explicitly defined in APIS env var, trying default '%s'", api.DefaultModelsPath)
+	}
+	// Ctrl-C abandons a slow or unresponsive endpoint
+	listCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	req, err := api.NewModelListRequest(listCtx, provider)
 	if err != nil {
-		fmt.Printf("Bot: Error creating model list request: %v\n", err)
-		return
+		return fmt.Errorf("creating model list request: %w", err)
 	}
-	req.Header.Add("Authorization", "Bearer "+provider.APIKey)
-	// Some APIs might require Content-Type even for GET
-	// req.Header.Add("Content-Type", "application/json")
 
-	res, err := http.DefaultClient.Do(req)
+	// Same proxy, timeout and recording setup as chat requests
+	client, err := api.HTTPClient(state.Settings)
 	if err != nil {
-		fmt.Printf("Bot: Error fetching models: %v\n", err)
-		return
+		return fmt.Errorf("fetching models: %w", err)
+	}
+	res, err := client.Do(req)
+	if errors.Is(err, context.Canceled) {
+		fmt.Fprintln(ctx.Out, "Bot: Listing models cancelled.")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("fetching models: %w", err)
 	}
 	defer res.Body.Close()
 
 	body, err := io.ReadAll(res.Body)
+	if errors.Is(err, context.Canceled) {
+		fmt.Fprintln(ctx.Out, "Bot: Listing models cancelled.")
+		return nil
+	}
 	if err != nil {
-		fmt.Printf("Bot: Error reading models response body: %v\n", err)
-		return
+		return fmt.Errorf("reading models response body: %w", err)
 	}
 
 	if res.StatusCode != http.StatusOK {
-		fmt.Printf("Bot: Error fetching models (Status %d): %s\n", res.StatusCode, string(body))
-		return
+		return fmt.Errorf("fetching models failed (status %d): %s", res.StatusCode, string(body))
 	}
 
-	// Attempt to pretty-print the JSON response
-	var prettyJSON bytes.Buffer
-	err = json.Indent(&prettyJSON, body, "", "  ") // Use two spaces for indentation
-	if err == nil {
-		fmt.Println("Available Models:\n", prettyJSON.String())
-	} else {
-		// If not valid JSON or Indent fails, print the raw response
-		fmt.Println("Available Models (raw response):\n", string(body))
+	list, err := parseModelList(body)
+	if err != nil {
+		// Unrecognised shape: pretty-print the raw JSON if possible
+		var prettyJSON bytes.Buffer
+		if json.Indent(&prettyJSON, body, "", "  ") == nil { // Use two spaces for indentation
+			fmt.Fprintln(ctx.Out, "Available Models:\n", prettyJSON.String())
+		} else {
+			fmt.Fprintln(ctx.Out, "Available Models (raw response):\n", string(body))
+		}
+		return nil
+	}
+
+	// Cache the list so /model can validate names against it
+	if state.ModelLists == nil {
+		state.ModelLists = make(map[string]types.ModelList)
 	}
+	state.ModelLists[state.ProviderName] = list
+
+	fmt.Fprintf(ctx.Out, "Available Models (%d):\n", len(list.Data))
+	table := tabwriter.NewWriter(ctx.Out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "  ID\tOWNER")
+	for _, model := range list.Data {
+		owner := model.OwnedBy
+		if owner == "" {
+			owner = "-"
+		}
+		fmt.Fprintf(table, "  %s\t%s\n", model.ID, owner)
+	}
+	table.Flush()
+	return nil
 }
 
-// Command to show current provider configuration
-func showProvider(args ...interface{}) {
-	if len(args) == 0 {
-		fmt.Println("Bot: Internal error: Provider info missing for showProvider.")
-		return
+// parseModelList decodes a model list in either OpenAI's {"data": [...]}
+// shape or as a bare array, sorted alphabetically by ID.
+func parseModelList(body []byte) (types.ModelList, error) {
+	var list types.ModelList
+	if err := json.Unmarshal(body, &list); err != nil || list.Data == nil {
+		// Some providers return the array directly
+		if arrErr := json.Unmarshal(body, &list.Data); arrErr != nil {
+			return types.ModelList{}, fmt.Errorf("unrecognised model list format")
+		}
 	}
-	provider, ok := args[0].(types.ModelProvider)
-	if !ok {
-		fmt.Println("Bot: Internal error: Invalid argument type for showProvider.")
-		return
+	for _, model := range list.Data {
+		if model.ID == "" {
+			return types.ModelList{}, fmt.Errorf("model list entry without an id")
+		}
 	}
+	sort.Slice(list.Data, func(i, j int) bool { return list.Data[i].ID < list.Data[j].ID })
+	return list, nil
+}
 
-	fmt.Println("--- Current Provider Configuration ---")
-	fmt.Println("Provider Name:", provider.Provider) // Might be empty if not set in env
-	fmt.Println("Base URL:", provider.UrlBase)
-	fmt.Println("API Key:", "***********"+provider.APIKey[len(provider.APIKey)-4:]) // Mask key
-	fmt.Println("Configured Model:", provider.Model)
-	fmt.Println("API Endpoints:")
+// Command to show current provider configuration
+func showProvider(ctx *CommandContext, args []string) error {
+	provider := ctx.State.Provider
+
+	fmt.Fprintln(ctx.Out, "--- Current Provider Configuration ---")
+	fmt.Fprintln(ctx.Out, "Provider Name:", provider.Provider) // Might be empty if not set in env
+	fmt.Fprintln(ctx.Out, "Base URL:", provider.UrlBase)
+	fmt.Fprintln(ctx.Out, "API Key:", provider.MaskedKey()) // Never print the full key
+	fmt.Fprintln(ctx.Out, "Configured Model:", provider.Model)
+	fmt.Fprintln(ctx.Out, "API Format:", provider.Format)
+	fmt.Fprintln(ctx.Out, "API Endpoints:")
 	for key, path := range provider.APIs {
-		fmt.Printf("  - %s: %s\n", key, path)
+		fmt.Fprintf(ctx.Out, "  - %s: %s\n", key, path)
 	}
-	fmt.Println("------------------------------------")
+	fmt.Fprintln(ctx.Out, "------------------------------------")
+	return nil
 }
 
 // Command to show the currently configured model
-func showModel(args ...interface{}) {
+func showModel(ctx *CommandContext, args []string) error {
+	provider := ctx.State.Provider
+	fmt.Fprintln(ctx.Out, "Bot: Current model configured:", provider.Model)
+	return nil
+}
+
+// Command to show which build is running, for bug reports
+func showVersion(ctx *CommandContext, args []string) error {
+	fmt.Fprintln(ctx.Out, "Bot: "+strings.ReplaceAll(version.Get().String(), "\n", "\n  "))
+	return nil
+}
+
+// Command to display help information
+func showHelp(ctx *CommandContext, args []string) error {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(ctx.Out, "Available commands:")
+	table := tabwriter.NewWriter(ctx.Out, 0, 0, 1, ' ', 0)
+	for _, name := range names {
+		cmd := commands[name]
+		fmt.Fprintf(table, "  %s\t- %s\n", strings.TrimSpace("/"+name+" "+cmd.Args), cmd.Description)
+	}
+	table.Flush()
+	return nil
+}
+
+// Command to write code block(s) from the last assistant message to a file
+func writeCode(ctx *CommandContext, args []string) error {
+	conv := ctx.Conversation
+
+	// Parse flags and the target path
+	all, force, path := false, false, ""
+	for _, arg := range args {
+		switch arg {
+		case "-a":
+			all = true
+		case "-f":
+			force = true
+		default:
+			path = arg
+		}
+	}
+	if path == "" {
+		return usageError("/write [-a] [-f] <file>")
+	}
+
+	lastReply, found := conv.LastAssistantMessage()
+	if !found {
+		fmt.Fprintln(ctx.Out, "Bot: No assistant response to write from yet.")
+		return nil
+	}
+	blocks := codeblock.Extract(lastReply.Content)
+	if len(blocks) == 0 {
+		fmt.Fprintln(ctx.Out, "Bot: The last response contains no code blocks.")
+		return nil
+	}
+	if !all {
+		blocks = blocks[:1]
+	}
+
+	// Infer the extension from the first block's language when none is given
+	if filepath.Ext(path) == "" {
+		path += codeblock.Extension(blocks[0].Lang)
+	}
+
+	if _, err := os.Stat(path); err == nil && !force {
+		fmt.Fprintf(ctx.Out, "Bot: %s already exists. Use /write -f to overwrite.\n", path)
+		return nil
+	}
+
+	codes := make([]string, len(blocks))
+	for i, block := range blocks {
+		codes[i] = block.Code
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(codes, "\n\n")+"\n"), 0644); err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	fmt.Fprintf(ctx.Out, "Bot: Wrote %d code block(s) to %s\n", len(blocks), path)
+	return nil
+}
+
+// Command to profile performance; currently supports "/profile context"
+func profile(ctx *CommandContext, args []string) error {
+	conv := ctx.Conversation
+	if len(args) == 0 || args[0] != "context" {
+		return usageError("/profile context")
+	}
+
+	history := conv.GetFullHistory()
+
+	// Time context assembly (uses cached per-message token counts)
+	start := time.Now()
+	context, err := conv.GetContext()
+	assembly := time.Since(start)
+	if err != nil {
+		return err
+	}
+
+	// Time raw token counting over the full history, bypassing the cache
+	start = time.Now()
+	totalTokens := 0
+	for _, msg := range history {
+		totalTokens += conversation.EstimateTokens(msg.Content)
+	}
+	counting := time.Since(start)
+	perMessage := time.Duration(0)
+	if len(history) > 0 {
+		perMessage = counting / time.Duration(len(history))
+	}
+
+	fmt.Fprintln(ctx.Out, "--- Context Profile ---")
+	fmt.Fprintf(ctx.Out, "History: %d messages, ~%d tokens\n", len(history), totalTokens)
+	fmt.Fprintf(ctx.Out, "GetContext(): %s (%d messages selected)\n", assembly, len(context))
+	fmt.Fprintf(ctx.Out, "Token counting (uncached): %s total, %s per message\n", counting, perMessage)
+	fmt.Fprintln(ctx.Out, "-----------------------")
+	return nil
+}
+
+// Command to list providers or switch the active one (history is kept)
+func switchProvider(ctx *CommandContext, args []string) error {
+	state := ctx.State
+	conv := ctx.Conversation
+
 	if len(args) == 0 {
-		fmt.Println("Bot: Internal error: Provider info missing for showModel.")
-		return
+		names := make([]string, 0, len(state.Providers))
+		for name := range state.Providers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Fprintln(ctx.Out, "Configured providers:")
+		for _, name := range names {
+			marker := " "
+			if name == state.ProviderName {
+				marker = "*"
+			}
+			fmt.Fprintf(ctx.Out, " %s %s (%s)\n", marker, name, state.Providers[name].Model)
+		}
+		return nil
 	}
-	provider, ok := args[0].(types.ModelProvider)
-	if !ok {
-		fmt.Println("Bot: Internal error: Invalid argument type for showModel.")
-		return
+
+	name := strings.ToLower(args[0])
+	provider, found := state.Providers[name]
+	if !found {
+		fmt.Fprintf(ctx.Out, "Bot: Provider '%s' is not configured. Use /provider to list configured providers.\n", name)
+		return nil
 	}
-	fmt.Println("Bot: Current model configured:", provider.Model)
+	state.ProviderName = name
+	state.Provider = provider
+	conv.SetMaxTokens(models.ContextBudget(provider.Model, state.Settings.DefaultMaxTokens))
+	fmt.Fprintf(ctx.Out, "Bot: Switched to provider '%s' (model %s). Conversation history kept.\n", name, provider.Model)
+	return nil
 }
 
-// Command to display help information
-func showHelp(args ...interface{}) {
-	fmt.Println("Available commands:")
-	fmt.Println("  /list      - List available models from the provider.")
-	fmt.Println("  /show      - Show the current provider configuration.")
-	fmt.Println("  /showModel - Show the currently selected model.")
-	fmt.Println("  /help      - Display this help message.")
-	fmt.Println("  /exit      - Quit the chatbot.")
+// Command to show or switch the model used by the active provider
+func switchModel(ctx *CommandContext, args []string) error {
+	state := ctx.State
+	conv := ctx.Conversation
+
+	if len(args) == 0 {
+		fmt.Fprintf(ctx.Out, "Bot: Current model: %s (provider '%s')\n", state.Provider.Model, state.ProviderName)
+		return nil
+	}
+	if len(args) != 1 {
+		return usageError("/model <name>") // Model names never contain spaces
+	}
+	model := args[0]
+
+	// Cross-check against the provider's model list if /list has fetched it
+	if list, cached := state.ModelLists[state.ProviderName]; cached && !hasModel(list, model) {
+		fmt.Fprintf(ctx.Out, "Bot: Model '%s' is not offered by provider '%s'. Use /list to see available models.\n", model, state.ProviderName)
+		return nil
+	}
+
+	state.Provider.Model = model
+	state.Providers[state.ProviderName] = state.Provider // Keep the choice when switching providers back and forth
+	// The new model may have a different context window
+	conv.SetMaxTokens(models.ContextBudget(model, state.Settings.DefaultMaxTokens))
+	fmt.Fprintf(ctx.Out, "Bot: Switched to model '%s' (context budget %d tokens). Conversation history kept.\n", model, conv.MaxTokens())
+	return nil
+}
+
+// hasModel reports whether the model list contains id.
+func hasModel(list types.ModelList, id string) bool {
+	for _, model := range list.Data {
+		if model.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Command to clear the conversation history while keeping the system prompt
+func clearConversation(ctx *CommandContext, args []string) error {
+	conv := ctx.Conversation
+
+	conv.Reset()
+	fmt.Fprintln(ctx.Out, "Bot: Conversation cleared.")
+	if prompt := conv.GetSystemPrompt(); prompt != "" {
+		fmt.Fprintln(ctx.Out, "Bot: System prompt retained:", prompt)
+	}
+	return nil
+}
+
+// Command to show or replace the system prompt
+func systemPrompt(ctx *CommandContext, args []string) error {
+	conv := ctx.Conversation
+
+	if len(args) == 0 {
+		if prompt := conv.GetSystemPrompt(); prompt != "" {
+			fmt.Fprintln(ctx.Out, "Bot: Current system prompt:", prompt)
+		} else {
+			fmt.Fprintln(ctx.Out, "Bot: No system prompt is set.")
+		}
+		return nil
+	}
+
+	text := ctx.ArgText // Keep the prompt's own line breaks and spacing
+	if text == "-" {
+		text = "" // Empty prompt removes the system message entirely
+	}
+	if err := setSystemPrompt(ctx, text); err != nil {
+		return fmt.Errorf("could not set system prompt: %w", err)
+	}
+	if text == "" {
+		fmt.Fprintln(ctx.Out, "Bot: System prompt removed.")
+	} else {
+		fmt.Fprintln(ctx.Out, "Bot: System prompt updated.")
+	}
+	return nil
+}
+
+// Command to show the estimated size of the context that would be sent next
+func showTokens(ctx *CommandContext, args []string) error {
+	conv := ctx.Conversation
+
+	context, omitted, err := conv.GetContextWithOmitted()
+	if err != nil {
+		return err
+	}
+	systemTokens, conversationTokens := 0, 0
+	for _, msg := range context {
+		if msg.Role == "system" {
+			systemTokens += conversation.EstimateTokens(msg.Content)
+		} else {
+			conversationTokens += conversation.EstimateTokens(msg.Content)
+		}
+	}
+
+	fmt.Fprintf(ctx.Out, "Bot: Using %d / %d tokens across %d messages (estimated)\n", systemTokens+conversationTokens, conv.MaxTokens(), len(context))
+	fmt.Fprintf(ctx.Out, "  System prompt: %d tokens\n", systemTokens)
+	fmt.Fprintf(ctx.Out, "  Conversation:  %d tokens\n", conversationTokens)
+	if omitted > 0 {
+		fmt.Fprintf(ctx.Out, "  (%d older messages are outside the context window)\n", omitted)
+	}
+	if last, _ := conv.Usage(); last != nil {
+		fmt.Fprintf(ctx.Out, "  Last API-reported prompt size: %d tokens\n", last.PromptTokens)
+	}
+	return nil
+}
+
+// Command to show token usage reported by the API
+func showUsage(ctx *CommandContext, args []string) error {
+	conv := ctx.Conversation
+
+	last, total := conv.Usage()
+	if last == nil {
+		fmt.Fprintln(ctx.Out, "Bot: Token usage not reported by the provider.")
+		return nil
+	}
+	fmt.Fprintln(ctx.Out, "--- Token Usage ---")
+	fmt.Fprintf(ctx.Out, "Last request: prompt %d, completion %d, total %d\n", last.PromptTokens, last.CompletionTokens, last.TotalTokens)
+	fmt.Fprintf(ctx.Out, "Session:      prompt %d, completion %d, total %d\n", total.PromptTokens, total.CompletionTokens, total.TotalTokens)
+	fmt.Fprintln(ctx.Out, "-------------------")
+	return nil
+}
+
+// Command to save the full conversation history to a JSON file
+func saveConversation(ctx *CommandContext, args []string) error {
+	conv := ctx.Conversation
+
+	path := fmt.Sprintf("chat-%s.json", time.Now().Format("20060102-150405"))
+	if len(args) > 0 {
+		path = args[0]
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		if !confirm(fmt.Sprintf("Bot: %s already exists. Overwrite?", path)) {
+			fmt.Fprintln(ctx.Out, "Bot: Save cancelled.")
+			return nil
+		}
+	}
+
+	data, err := conv.MarshalHistory()
+	if err != nil {
+		return fmt.Errorf("encoding conversation: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	fmt.Fprintf(ctx.Out, "Bot: Saved %d messages to %s\n", len(conv.GetFullHistory()), path)
+	return nil
+}
+
+// Command to send the same prompt to several models of the active provider
+// concurrently and print the answers together. The current context is
+// included, but neither the prompt nor the answers are added to history.
+func compareModels(ctx *CommandContext, args []string) error {
+	state := ctx.State
+	conv := ctx.Conversation
+
+	// Models come first; the prompt follows "--" or is asked for
+	models, prompt := args, ""
+	for i, arg := range args {
+		if arg == "--" {
+			models, prompt = args[:i], strings.Join(args[i+1:], " ")
+			break
+		}
+	}
+	if len(models) < 2 {
+		return usageError("/compare <model> <model>... [-- prompt]")
+	}
+	if prompt == "" {
+		line, _ := readLine("Prompt: ")
+		prompt = strings.TrimSpace(line)
+	}
+	if prompt == "" {
+		fmt.Fprintln(ctx.Out, "Bot: Compare cancelled: no prompt given.")
+		return nil
+	}
+
+	// Ctrl-C abandons every request still in progress
+	compareCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	messages, _, err := conv.RequestContext(compareCtx)
+	if err != nil {
+		return err
+	}
+	messages = append(messages, types.Message{Role: "user", Content: prompt})
+	answers := make([]string, len(models))
+	failures := make([]error, len(models))
+
+	fmt.Fprintf(ctx.Out, "Bot: Asking %d models...\n", len(models))
+	var group errgroup.Group
+	for i, model := range models {
+		provider := state.Provider // Copy of the active provider with another model
+		provider.Model = model
+		group.Go(func() error {
+			// Failures are reported per model rather than aborting the others
+			answers[i], failures[i] = api.Complete(compareCtx, provider, state.Settings, messages)
+			return nil
+		})
+	}
+	group.Wait()
+	if compareCtx.Err() != nil {
+		fmt.Fprintln(ctx.Out, "\nBot: Compare cancelled.")
+		return nil
+	}
+
+	for i, model := range models {
+		fmt.Fprintf(ctx.Out, "\n=== %s ===\n", model)
+		if failures[i] != nil {
+			fmt.Fprintf(ctx.Out, "Error: %v\n", failures[i])
+			continue
+		}
+		fmt.Fprintln(ctx.Out, answers[i])
+	}
+	return nil
+}
+
+// Messages longer than this are shortened by /history unless --full is given
+const historyPreviewChars = 300
+
+// Command to show the full stored history, not just the current context
+func showHistory(ctx *CommandContext, args []string) error {
+	conv := ctx.Conversation
+
+	// Parse the optional count and flags, in any order
+	limit, full, tokens := 0, false, false
+	for _, arg := range args {
+		switch arg {
+		case "--full":
+			full = true
+			continue
+		case "--tokens":
+			tokens = true
+			continue
+		}
+		n, err := strconv.Atoi(arg)
+		if err != nil || n <= 0 {
+			return usageError("/history [N] [--full] [--tokens]")
+		}
+		limit = n
+	}
+
+	history := conv.GetFullHistory()
+	if len(history) == 0 {
+		fmt.Fprintln(ctx.Out, "Bot: The conversation is empty.")
+		return nil
+	}
+	start := 0
+	if limit > 0 && limit < len(history) {
+		start = len(history) - limit
+	}
+
+	// With --tokens: estimates with a running total from the first message,
+	// and a marker where the context strategy currently cuts off older messages
+	cumulative, omitted := 0, 0
+	if tokens {
+		var err error
+		if _, omitted, err = conv.GetContextWithOmitted(); err != nil {
+			return err
+		}
+		for _, msg := range history[:start] {
+			cumulative += conversation.EstimateTokens(msg.Content)
+		}
+		systemTokens := 0
+		if prompt := conv.GetSystemPrompt(); prompt != "" {
+			systemTokens = conversation.EstimateTokens(prompt)
+		}
+		fmt.Fprintf(ctx.Out, "Bot: System prompt ~%d tokens; context budget %d tokens.\n", systemTokens, conv.MaxTokens())
+	}
+
+	fmt.Fprintf(ctx.Out, "--- Conversation history (messages %d-%d of %d) ---\n", start+1, len(history), len(history))
+	if tokens && omitted > 0 && omitted <= start {
+		fmt.Fprintf(ctx.Out, "=== Context cutoff: messages 1-%d (not shown) are left out of the context ===\n", omitted)
+	}
+	for i, msg := range history[start:] {
+		if tokens && omitted > start && start+i == omitted {
+			fmt.Fprintf(ctx.Out, "=== Context cutoff: messages 1-%d above are left out of the context ===\n", omitted)
+		} else if i > 0 && msg.Role == "user" {
+			fmt.Fprintln(ctx.Out, "------------------------------------") // Separate turns
+		}
+		label := map[string]string{"user": "You", "assistant": "Bot", "system": "System", "tool": "Tool"}[msg.Role]
+		if label == "" {
+			label = msg.Role
+		}
+		content := msg.Content
+		if runes := []rune(content); !full && len(runes) > historyPreviewChars {
+			content = string(runes[:historyPreviewChars]) + "…"
+		}
+		annotation := ""
+		if tokens {
+			count := conversation.EstimateTokens(msg.Content)
+			cumulative += count
+			annotation = fmt.Sprintf(" [~%d tokens, %d total]", count, cumulative)
+		}
+		fmt.Fprintf(ctx.Out, "[%d] %s (%s)%s:\n%s\n", start+i+1, label, msg.Timestamp.Format("2006-01-02 15:04:05"), annotation, content)
+	}
+	if tokens && omitted == len(history) && omitted > start {
+		fmt.Fprintln(ctx.Out, "=== Context cutoff: no stored message fits in the context ===")
+	}
+	fmt.Fprintln(ctx.Out, "------------------------------------")
+	return nil
+}
+
+// Command to export the conversation as a markdown transcript
+func exportMarkdown(ctx *CommandContext, args []string) error {
+	conv := ctx.Conversation
+
+	now := time.Now()
+	path := fmt.Sprintf("chat-%s.md", now.Format("20060102-150405"))
+	if len(args) > 0 {
+		path = args[0]
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		if !confirm(fmt.Sprintf("Bot: %s already exists. Overwrite?", path)) {
+			fmt.Fprintln(ctx.Out, "Bot: Export cancelled.")
+			return nil
+		}
+	}
+
+	// Include the system prompt so the transcript shows the full setup
+	messages := conv.GetFullHistory()
+	if prompt := conv.GetSystemPrompt(); prompt != "" {
+		messages = append([]types.Message{{Role: "system", Content: prompt}}, messages...)
+	}
+	if err := os.WriteFile(path, []byte(export.Markdown(messages, now)), 0644); err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	fmt.Fprintf(ctx.Out, "Bot: Exported %d messages to %s\n", len(messages), path)
+	return nil
+}
+
+// Functions run by Shutdown before the process exits (e.g. saving the session)
+var (
+	exitHooks    []func()
+	shutdownOnce sync.Once
+)
+
+// OnExit registers fn to run on shutdown, however it is triggered.
+func OnExit(fn func()) {
+	exitHooks = append(exitHooks, fn)
+}
+
+// Shutdown is the single exit path shared by /exit, the end of input and
+// termination signals: it runs the exit hooks, says goodbye and exits with
+// code. It runs only once; a concurrent caller (e.g. a signal arriving during
+// /exit) blocks until the process exits.
+func Shutdown(out io.Writer, code int) {
+	shutdownOnce.Do(func() {
+		for _, hook := range exitHooks {
+			hook()
+		}
+		fmt.Fprintln(out, "Bot: Goodbye!")
+		os.Exit(code)
+	})
 }
 
 // Command to exit the application
-func exitCmd(args ...interface{}) {
-	fmt.Println("Bot: Goodbye!")
-	os.Exit(0) // Exit gracefully
+func exitCmd(ctx *CommandContext, args []string) error {
+	Shutdown(ctx.Out, 0)
+	return nil
+}
+
+// Command to show or change the sampling parameters sent with each request
+func setParam(ctx *CommandContext, args []string) error {
+	state := ctx.State
+
+	if len(args) == 0 {
+		fmt.Fprintln(ctx.Out, "Bot: Request parameters:", config.FormatSamplingParams(state.Settings.Sampling))
+		return nil
+	}
+	name, value := strings.ToLower(args[0]), ""
+	if (name == "stop" || name == "format") && len(args) > 1 {
+		value = strings.TrimSpace(strings.TrimPrefix(ctx.ArgText, args[0])) // Sequences may contain spaces, formats a file name
+	} else if len(args) == 2 {
+		value = args[1]
+	} else {
+		return usageError(fmt.Sprintf("/set <%s> <value|off>", strings.Join(config.SamplingParamNames(), "|")))
+	}
+
+	if err := config.SetSamplingParam(&state.Settings.Sampling, name, value); err != nil {
+		return err
+	}
+	fmt.Fprintln(ctx.Out, "Bot: Request parameters:", config.FormatSamplingParams(state.Settings.Sampling))
+	return nil
+}
+
+// Command to replace the last reply with one generated using different
+// parameters. The overrides apply to a copy of the settings, so the
+// defaults shown by /set are unchanged afterwards.
+func regenerateWith(ctx *CommandContext, args []string) error {
+	state := ctx.State
+	conv := ctx.Conversation
+
+	usage := usageError(fmt.Sprintf("/regenerate-with <%s>=<value|off>...", strings.Join(config.SamplingParamNames(), "|")))
+	if len(args) == 0 {
+		return usage
+	}
+	settings := state.Settings // SetSamplingParam replaces fields rather than writing through them
+	for _, arg := range args {
+		name, value, ok := strings.Cut(arg, "=")
+		if !ok || value == "" {
+			return usage
+		}
+		if err := config.SetSamplingParam(&settings.Sampling, strings.ToLower(name), value); err != nil {
+			return err
+		}
+	}
+
+	input, ok := conv.PopLastTurn()
+	if !ok {
+		fmt.Fprintln(ctx.Out, "Bot: Nothing to regenerate yet.")
+		return nil
+	}
+	fmt.Fprintln(ctx.Out, "Bot: Regenerating with", config.FormatSamplingParams(settings.Sampling))
+	return resend(ctx, input, settings)
+}
+
+// resend sends input as a new turn on behalf of a command, e.g. after the
+// previous one was removed from history. Ctrl-C cancels the request, as for
+// a regular query.
+func resend(ctx *CommandContext, input string, settings types.Settings) error {
+	state := ctx.State
+	queryCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	err := api.QueryHandler(queryCtx, ctx.Conversation, input, state.Provider, settings, api.NewRenderer(state.Provider, settings))
+	if errors.Is(err, context.Canceled) {
+		fmt.Fprintln(ctx.Out, "\nBot: Request cancelled.")
+		return nil
+	}
+	if settings.JSONOutput {
+		return nil // The error was already emitted as JSON
+	}
+	return err
+}
+
+// Command to fix the last user message and send it again in place of the
+// original turn. The message is edited in $EDITOR, or re-entered inline when
+// it isn't set; leaving it unchanged does nothing.
+func editLastMessage(ctx *CommandContext, args []string) error {
+	conv := ctx.Conversation
+
+	last, ok := conv.LastUserMessage()
+	if !ok {
+		fmt.Fprintln(ctx.Out, "Bot: There is no message to edit yet.")
+		return nil
+	}
+
+	var edited string
+	var err error
+	if editor := strings.TrimSpace(os.Getenv("EDITOR")); editor != "" {
+		edited, err = editInEditor(editor, last.Content)
+		if err != nil {
+			return err
+		}
+	} else {
+		fmt.Fprintln(ctx.Out, "Bot: $EDITOR is not set. Your last message was:")
+		fmt.Fprintln(ctx.Out, last.Content)
+		edited, err = readLine("Replacement (empty to cancel): ")
+		if err != nil && edited == "" {
+			fmt.Fprintln(ctx.Out)
+		}
+	}
+
+	edited = strings.TrimSpace(edited)
+	if edited == "" || edited == strings.TrimSpace(last.Content) {
+		fmt.Fprintln(ctx.Out, "Bot: Message unchanged.")
+		return nil
+	}
+	conv.PopLastTurn() // The stale reply goes with the original message
+	return resend(ctx, edited, ctx.State.Settings)
+}
+
+// editInEditor opens text in editor (which may include arguments, e.g.
+// "code -w") via a temporary file and returns the saved result.
+func editInEditor(editor, text string) (string, error) {
+	file, err := os.CreateTemp("", "chatbot-edit-*.md")
+	if err != nil {
+		return "", fmt.Errorf("creating temporary file: %w", err)
+	}
+	path := file.Name()
+	defer os.Remove(path)
+	_, err = file.WriteString(text)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("writing temporary file: %w", err)
+	}
+
+	cmd := exec.Command("sh", "-c", editor+` "$1"`, "sh", path)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("editor '%s' failed: %w", editor, err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("reading edited message: %w", err)
+	}
+	return string(data), nil
 }
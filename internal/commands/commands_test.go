@@ -0,0 +1,224 @@
+package commands
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/henryhwang/chatbot/internal/conversation"
+	"github.com/henryhwang/chatbot/internal/models"
+	"github.com/henryhwang/chatbot/internal/types"
+)
+
+// newTestContext returns a command context for a conversation with provider,
+// collecting output in the returned buffer.
+func newTestContext(provider types.ModelProvider) (*CommandContext, *bytes.Buffer) {
+	var out bytes.Buffer
+	return &CommandContext{
+		State:        &types.RuntimeState{Provider: provider, Settings: types.Settings{BotPrefix: "Bot: "}},
+		Conversation: conversation.NewConversation("", &conversation.SimpleTruncationStrategy{}, 10000),
+		Out:          &out,
+	}, &out
+}
+
+// interruptingServer is a provider that, once a request arrives, sends the
+// test process SIGINT (as Ctrl-C would) and then never answers. The signal
+// is sent once: another one after the command has stopped listening for it
+// would end the test process.
+func interruptingServer(t *testing.T) types.ModelProvider {
+	var once sync.Once
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body) // Lets the server notice the client going away
+		once.Do(func() { syscall.Kill(os.Getpid(), syscall.SIGINT) })
+		<-r.Context().Done()
+	}))
+	t.Cleanup(srv.Close)
+	return types.ModelProvider{
+		UrlBase: srv.URL,
+		APIs:    map[string]string{"chat": "/chat", "models": "/models"},
+		Model:   "m",
+		Format:  "openai",
+	}
+}
+
+func TestCtrlCCancelsSlowRequests(t *testing.T) {
+	tests := []struct {
+		command string
+		want    string
+	}{
+		{"compare a b -- hello", "Bot: Compare cancelled."},
+		{"list", "Bot: Listing models cancelled."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			ctx, out := newTestContext(interruptingServer(t))
+			done := make(chan struct{})
+			go func() {
+				RunCmd(ctx, tt.command)
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("Ctrl-C did not abort the request")
+			}
+			if !strings.Contains(out.String(), tt.want) {
+				t.Errorf("got %q, want %q", out.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestSwitchingModelResizesBudget(t *testing.T) {
+	tests := []struct {
+		command string
+		model   string
+	}{
+		{"model claude-3-5-sonnet", "claude-3-5-sonnet"},
+		{"model unknown-model", "unknown-model"},
+		{"provider other", "gpt-4o-mini"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			ctx, out := newTestContext(types.ModelProvider{Model: "gpt-4o"})
+			ctx.State.ProviderName = "default"
+			ctx.State.Providers = map[string]types.ModelProvider{"default": ctx.State.Provider, "other": {Model: "gpt-4o-mini"}}
+			ctx.State.Settings.DefaultMaxTokens = 1000
+			ctx.Conversation.SetMaxTokens(models.ContextBudget("gpt-4o", 1000))
+
+			RunCmd(ctx, tt.command)
+			if ctx.State.Provider.Model != tt.model {
+				t.Fatalf("model is %q, want %q (output %q)", ctx.State.Provider.Model, tt.model, out.String())
+			}
+			if want := models.ContextBudget(tt.model, 1000); ctx.Conversation.MaxTokens() != want {
+				t.Errorf("budget %d, want %d", ctx.Conversation.MaxTokens(), want)
+			}
+		})
+	}
+}
+
+func TestWriteCode(t *testing.T) {
+	reply := "First:\n```go\npackage main\n```\nThen:\n```sh\necho hi\n```"
+	tests := []struct {
+		name     string
+		args     string
+		existing string // Content already at the target path ("" for none)
+		path     string // Path written, relative to the temporary directory
+		want     string // Expected file content
+		wantOut  string
+	}{
+		{name: "first block", args: "out.go", path: "out.go", want: "package main\n", wantOut: "Wrote 1 code block(s)"},
+		{name: "all blocks", args: "-a out.txt", path: "out.txt", want: "package main\n\necho hi\n", wantOut: "Wrote 2 code block(s)"},
+		{name: "extension from language", args: "main", path: "main.go", want: "package main\n", wantOut: "main.go"},
+		{name: "existing file kept", args: "out.go", existing: "old\n", path: "out.go", want: "old\n", wantOut: "already exists"},
+		{name: "existing file overwritten with -f", args: "-f out.go", existing: "old\n", path: "out.go", want: "package main\n", wantOut: "Wrote 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, tt.path)
+			if tt.existing != "" {
+				if err := os.WriteFile(path, []byte(tt.existing), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			ctx, out := newTestContext(types.ModelProvider{})
+			ctx.Conversation.AddMessage("user", "code please")
+			ctx.Conversation.AddMessage("assistant", reply)
+
+			args := strings.Fields(tt.args)
+			args[len(args)-1] = filepath.Join(dir, args[len(args)-1])
+			RunCmd(ctx, "write "+strings.Join(args, " "))
+			got, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("%v (output %q)", err, out.String())
+			}
+			if string(got) != tt.want {
+				t.Errorf("wrote %q, want %q", got, tt.want)
+			}
+			if !strings.Contains(out.String(), tt.wantOut) {
+				t.Errorf("output %q does not mention %q", out.String(), tt.wantOut)
+			}
+		})
+	}
+}
+
+func TestProfileContext(t *testing.T) {
+	tests := []struct {
+		command string
+		want    []string
+	}{
+		{"profile context", []string{"History: 2 messages", "GetContext(): ", "(2 messages selected)", "per message"}},
+		{"profile", []string{"Usage: /profile context"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			ctx, out := newTestContext(types.ModelProvider{})
+			ctx.Conversation.AddMessage("user", "hello")
+			ctx.Conversation.AddMessage("assistant", "hi there")
+			RunCmd(ctx, tt.command)
+			for _, want := range tt.want {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("output %q does not contain %q", out.String(), want)
+				}
+			}
+		})
+	}
+}
+
+func TestShowMasksKey(t *testing.T) {
+	for _, key := range []string{"", "abc", "sk-secret-key-1234"} {
+		t.Run(key, func(t *testing.T) {
+			ctx, out := newTestContext(types.ModelProvider{APIKey: key, Model: "m"})
+			RunCmd(ctx, "show")
+			if key != "" && strings.Contains(out.String(), key) {
+				t.Errorf("output %q contains the key", out.String())
+			}
+			if want := "API Key: " + ctx.State.Provider.MaskedKey(); !strings.Contains(out.String(), want) {
+				t.Errorf("output %q does not contain %q", out.String(), want)
+			}
+		})
+	}
+}
+
+func TestHelpListsEveryCommand(t *testing.T) {
+	ctx, out := newTestContext(types.ModelProvider{})
+	RunCmd(ctx, "help")
+	help := out.String()
+
+	lines := strings.Split(help, "\n")
+	for name, cmd := range commands {
+		usage := strings.TrimSpace("/" + name + " " + cmd.Args)
+		found := false
+		for _, line := range lines {
+			if strings.HasPrefix(strings.TrimSpace(line), usage+" ") && strings.HasSuffix(line, "- "+cmd.Description) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("help has no line for %q with its description", usage)
+		}
+	}
+
+	// Listed in order of name
+	previous := ""
+	for _, line := range lines[1:] {
+		name, _, _ := strings.Cut(strings.TrimSpace(line), " ")
+		if name == "" {
+			continue
+		}
+		if name < previous {
+			t.Errorf("%s listed after %s", name, previous)
+		}
+		previous = name
+	}
+}
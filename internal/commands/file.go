@@ -0,0 +1,149 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/henryhwang/chatbot/internal/conversation"
+	"github.com/henryhwang/chatbot/internal/fileref"
+)
+
+// --- Questions About a File ---
+
+// Command to ask about a file: its contents are sent in a fenced block
+// labelled with the path, followed by the question. A file that doesn't fit
+// in what is left of the context budget is refused unless --truncate is
+// given, which sends as many whole lines from its start as fit.
+func askAboutFile(ctx *CommandContext, args []string) error {
+	usage := usageError("/file [--truncate] <path> <question>")
+	rest := ctx.ArgText
+	truncate := len(args) > 0 && args[0] == "--truncate"
+	if truncate {
+		args = args[1:]
+		rest = strings.TrimSpace(strings.TrimPrefix(rest, "--truncate"))
+	}
+	if len(args) < 2 {
+		return usage
+	}
+	path := args[0]
+	question := strings.TrimSpace(strings.TrimPrefix(rest, path))
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory", path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+	if fileref.IsBinary(data) {
+		fmt.Fprintf(ctx.Out, "Bot: %s looks like a binary file, so it wasn't sent. /file only sends text files.\n", path)
+		return nil
+	}
+
+	conv := ctx.Conversation
+	content := string(data)
+	budget := conv.MaxTokens() - conv.ContextTokens() - conversation.EstimateTokens(question)
+	if tokens := conversation.EstimateTokens(fileref.Fence(path, content)); tokens > budget {
+		if !truncate {
+			fmt.Fprintf(ctx.Out, "Bot: %s is ~%d tokens, more than the ~%d left in the context budget. Use /file --truncate %s <question> to send only its start.\n", path, tokens, max(budget, 0), path)
+			return nil
+		}
+		var kept, total int
+		content, kept, total = truncateLines(path, content, budget)
+		if kept == 0 {
+			fmt.Fprintf(ctx.Out, "Bot: Not even the first line of %s fits in the ~%d tokens left in the context budget.\n", path, max(budget, 0))
+			return nil
+		}
+		fmt.Fprintf(ctx.Out, "Bot: Sending the first %d of %d lines of %s to fit the context budget.\n", kept, total, path)
+	}
+
+	return resend(ctx, fileref.Fence(path, content)+"\n\n"+question, ctx.State.Settings)
+}
+
+// truncateLines returns the longest run of content's leading lines whose
+// fenced block, with a note saying how much was left out, fits in budget
+// tokens, along with the number of lines kept and the total.
+func truncateLines(path, content string, budget int) (string, int, int) {
+	lines := strings.Split(strings.TrimRight(content, "\n"), "\n")
+	truncated := func(kept int) string {
+		return fmt.Sprintf("%s\n[... truncated: %d of %d lines shown]", strings.Join(lines[:kept], "\n"), kept, len(lines))
+	}
+	// The first line count that no longer fits; every count below it does
+	kept := sort.Search(len(lines)+1, func(n int) bool {
+		return n > 0 && conversation.EstimateTokens(fileref.Fence(path, truncated(n))) > budget
+	}) - 1
+	if kept <= 0 {
+		return "", 0, len(lines)
+	}
+	return truncated(kept), kept, len(lines)
+}
+
+// Command to ask about every file matching a glob pattern, e.g.
+// /files src/*.go summarize the package. Each file is sent in its own block,
+// in sorted order, while they fit in what is left of the context budget;
+// files that don't fit, binary files and directories are left out, and what
+// was included and omitted is reported before the message is sent.
+func askAboutFiles(ctx *CommandContext, args []string) error {
+	if len(args) < 2 {
+		return usageError("/files <pattern> <question>")
+	}
+	pattern := args[0]
+	question := strings.TrimSpace(strings.TrimPrefix(ctx.ArgText, pattern))
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid pattern '%s': %w", pattern, err)
+	}
+	sort.Strings(paths) // Glob sorts within a directory, not across them
+
+	conv := ctx.Conversation
+	budget := conv.MaxTokens() - conv.ContextTokens() - conversation.EstimateTokens(question)
+	var blocks, included, omitted []string
+	used := 0
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil || info.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			omitted = append(omitted, fmt.Sprintf("%s (%v)", path, err))
+			continue
+		}
+		if fileref.IsBinary(data) {
+			omitted = append(omitted, path+" (binary)")
+			continue
+		}
+		block := fileref.Fence(path, string(data))
+		tokens := conversation.EstimateTokens(block)
+		if used+tokens > budget {
+			omitted = append(omitted, fmt.Sprintf("%s (~%d tokens, over the budget)", path, tokens))
+			continue
+		}
+		used += tokens
+		blocks = append(blocks, block)
+		included = append(included, path)
+	}
+
+	if len(included) == 0 && len(omitted) == 0 {
+		fmt.Fprintf(ctx.Out, "Bot: No files match '%s'.\n", pattern)
+		return nil
+	}
+	if len(included) > 0 {
+		fmt.Fprintf(ctx.Out, "Bot: Including %d file(s), ~%d tokens: %s\n", len(included), used, strings.Join(included, ", "))
+	}
+	if len(omitted) > 0 {
+		fmt.Fprintf(ctx.Out, "Bot: Omitted %d file(s): %s\n", len(omitted), strings.Join(omitted, ", "))
+	}
+	if len(blocks) == 0 {
+		fmt.Fprintln(ctx.Out, "Bot: Nothing to send.")
+		return nil
+	}
+	return resend(ctx, strings.Join(blocks, "\n\n")+"\n\n"+question, ctx.State.Settings)
+}
@@ -0,0 +1,67 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/henryhwang/chatbot/internal/api"
+)
+
+// --- Provider Health Check ---
+
+// Command to check connectivity and authentication before a session: the
+// model list endpoint is requested with the provider's credentials, through
+// the shared client, and the outcome is reported with the HTTP status and
+// latency. Nothing is cached or added to the conversation.
+func pingProvider(ctx *CommandContext, args []string) error {
+	state := ctx.State
+	provider := state.Provider
+
+	client, err := api.HTTPClient(state.Settings)
+	if err != nil {
+		return fmt.Errorf("preparing request: %w", err)
+	}
+	// Ctrl-C abandons a ping to an unresponsive endpoint
+	pingCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	req, err := api.NewModelListRequest(pingCtx, provider)
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+
+	start := time.Now()
+	res, err := client.Do(req)
+	latency := time.Since(start)
+	switch {
+	case errors.Is(err, context.Canceled):
+		fmt.Fprintln(ctx.Out, "Bot: Ping cancelled.")
+		return nil
+	case api.IsTimeout(err):
+		fmt.Fprintf(ctx.Out, "Bot: Unreachable: %s did not answer within %s.\n", req.URL.Host, formatLatency(latency))
+		return nil
+	case err != nil:
+		fmt.Fprintf(ctx.Out, "Bot: Unreachable: could not connect to %s: %v\n", req.URL.Host, err)
+		return nil
+	}
+	io.Copy(io.Discard, res.Body) // Drain so the connection is reused
+	res.Body.Close()
+
+	fmt.Fprintf(ctx.Out, "Bot: GET %s -> %s in %s\n", req.URL.Redacted(), res.Status, formatLatency(latency))
+	switch {
+	case res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden:
+		fmt.Fprintf(ctx.Out, "Bot: Authentication failed: the endpoint is reachable but rejected the API key for provider '%s'.\n", state.ProviderName)
+	case res.StatusCode >= 200 && res.StatusCode < 300:
+		fmt.Fprintf(ctx.Out, "Bot: OK: provider '%s' is reachable and accepted the API key.\n", state.ProviderName)
+	case res.StatusCode == http.StatusNotFound:
+		fmt.Fprintln(ctx.Out, "Bot: The server is reachable, but has no model list at this path (set it with models:<path> in APIS).")
+	default:
+		fmt.Fprintln(ctx.Out, "Bot: The server is reachable, but the request failed.")
+	}
+	return nil
+}
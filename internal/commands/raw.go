@@ -0,0 +1,90 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/henryhwang/chatbot/internal/api"
+)
+
+// --- Raw Requests ---
+
+// Command to send a hand-written request payload to the chat endpoint and
+// stream the response, bypassing the conversation (nothing is added to
+// history). The JSON comes from a file, or is typed in, ending with a line
+// containing only "."; it is checked before anything is sent.
+func sendRaw(ctx *CommandContext, args []string) error {
+	state := ctx.State
+	var body []byte
+	switch len(args) {
+	case 0:
+		fmt.Fprintln(ctx.Out, "Bot: Enter the JSON request body, then a line containing only '.' (an empty body cancels):")
+		var lines []string
+		for {
+			line, err := readLine("... ")
+			if strings.TrimSpace(line) == "." {
+				break
+			}
+			lines = append(lines, line)
+			if err != nil { // EOF ends the body like "."
+				if !errors.Is(err, io.EOF) {
+					return err
+				}
+				break
+			}
+		}
+		body = []byte(strings.Join(lines, "\n"))
+		if strings.TrimSpace(string(body)) == "" {
+			fmt.Fprintln(ctx.Out, "Bot: Nothing sent.")
+			return nil
+		}
+	case 1:
+		data, err := os.ReadFile(args[0])
+		if err != nil {
+			return fmt.Errorf("reading request body: %w", err)
+		}
+		body = data
+	default:
+		return usageError("/raw [file]")
+	}
+
+	if err := checkJSON(body); err != nil {
+		return err
+	}
+
+	rawCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	err := api.SendRaw(rawCtx, state.Provider, state.Settings, body, api.NewRenderer(state.Provider, state.Settings))
+	if errors.Is(err, context.Canceled) {
+		fmt.Fprintln(ctx.Out, "\nBot: Request cancelled.")
+		return nil
+	}
+	if state.Settings.JSONOutput {
+		return nil // The error was already emitted as JSON
+	}
+	return err
+}
+
+// checkJSON reports why body is not a single valid JSON value, giving the
+// line and column of a syntax error.
+func checkJSON(body []byte) error {
+	var value any
+	err := json.Unmarshal(body, &value)
+	var syntax *json.SyntaxError
+	if errors.As(err, &syntax) {
+		before := string(body[:syntax.Offset])
+		line := strings.Count(before, "\n") + 1
+		column := len(before) - strings.LastIndex(before, "\n")
+		return fmt.Errorf("invalid JSON at line %d, column %d: %v", line, column, syntax)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package commands
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/henryhwang/chatbot/internal/prompts"
+)
+
+// --- Prompt Variables ---
+
+// setSystemPrompt renders template with the session's prompt variables and
+// makes the result the system prompt, remembering the template so later
+// /vars changes re-render it. An empty template removes the system prompt.
+func setSystemPrompt(ctx *CommandContext, template string) error {
+	text, err := prompts.Render(template, ctx.State.PromptVars)
+	if err != nil {
+		return err
+	}
+	if err := ctx.Conversation.SetSystemPrompt(text); err != nil {
+		return err
+	}
+	ctx.State.SystemPromptTemplate = template
+	return nil
+}
+
+// Command to list, set or remove the variables filled into {{.Name}}
+// placeholders of the system prompt
+func promptVars(ctx *CommandContext, args []string) error {
+	state := ctx.State
+	if len(args) == 0 {
+		if len(state.PromptVars) == 0 {
+			fmt.Fprintln(ctx.Out, "Bot: No prompt variables set. Use /vars <name> <value> to add one.")
+			return nil
+		}
+		names := make([]string, 0, len(state.PromptVars))
+		for name := range state.PromptVars {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Fprintln(ctx.Out, "Prompt variables:")
+		for _, name := range names {
+			fmt.Fprintf(ctx.Out, "  %s = %s\n", name, state.PromptVars[name])
+		}
+		return nil
+	}
+
+	name := args[0]
+	value := strings.TrimSpace(strings.TrimPrefix(ctx.ArgText, name))
+	if value == "" {
+		return usageError("/vars <name> <value|->")
+	}
+
+	previous, had := state.PromptVars[name]
+	if state.PromptVars == nil {
+		state.PromptVars = make(map[string]string)
+	}
+	if value == "-" {
+		delete(state.PromptVars, name)
+	} else {
+		state.PromptVars[name] = value
+	}
+
+	// Re-render the system prompt (if it came from a template); undo the
+	// change if it no longer renders
+	if template := state.SystemPromptTemplate; template != "" {
+		if err := setSystemPrompt(ctx, template); err != nil {
+			if had {
+				state.PromptVars[name] = previous
+			} else {
+				delete(state.PromptVars, name)
+			}
+			return fmt.Errorf("variable not changed: the system prompt would fail to render: %w", err)
+		}
+	}
+	if value == "-" {
+		fmt.Fprintf(ctx.Out, "Bot: Removed prompt variable %s.\n", name)
+	} else {
+		fmt.Fprintf(ctx.Out, "Bot: Set prompt variable %s.\n", name)
+	}
+	return nil
+}
@@ -1,9 +1,19 @@
 package config
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"net/url"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/henryhwang/chatbot/internal/types"
 
@@ -12,6 +22,8 @@ import (
 
 // --- Configuration Loading ---
 
+// Load reads the default provider from the environment (and .env). Instead of
+// exiting, it returns an error describing every missing or invalid variable.
 func Load() (types.ModelProvider, error) {
 	err := godotenv.Load() // Load .env file if present
 	if err != nil {
@@ -19,55 +31,430 @@ func Load() (types.ModelProvider, error) {
 		log.Println("Warning: No .env file found, attempting to use environment variables directly.")
 	}
 
-	// Read required environment variables
-	providerName := os.Getenv("MODEL_PROVIDER") // Optional name
-	apiKey := os.Getenv("API_KEY")
-	apiBase := os.Getenv("API_URL_BASE")
-	apisString := os.Getenv("APIS") // e.g., "chat:/v1/chat/completions,models:/v1/models"
-	model := os.Getenv("MODEL")
+	// Read and validate the unprefixed variables; every problem is reported at once
+	provider, err := readProvider("", strings.TrimSpace(os.Getenv("MODEL_PROVIDER")))
+	if err != nil {
+		return types.ModelProvider{}, fmt.Errorf("invalid configuration: %w", err)
+	}
+	return provider, nil
+}
+
+// System prompt used when neither SYSTEM_PROMPT_FILE nor SYSTEM_PROMPT is set
+const defaultSystemPrompt = "you are great as golang developer"
+
+// LoadSystemPrompt returns the system prompt: the contents of
+// SYSTEM_PROMPT_FILE if set, otherwise SYSTEM_PROMPT (an empty value means no
+// system prompt), otherwise a built-in default. It may be a template with
+// {{.Name}} placeholders (see LoadPromptVars). A file that can't be read is
+// an error rather than a silent fallback.
+func LoadSystemPrompt() (string, error) {
+	if path := strings.TrimSpace(os.Getenv("SYSTEM_PROMPT_FILE")); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("SYSTEM_PROMPT_FILE: %w", err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	return envString("SYSTEM_PROMPT", defaultSystemPrompt), nil
+}
+
+// LoadPromptVars returns the system prompt template variables given as
+// PROMPT_VAR_<Name> environment variables, keyed by Name (case preserved).
+func LoadPromptVars() map[string]string {
+	vars := make(map[string]string)
+	for _, entry := range os.Environ() {
+		key, value, _ := strings.Cut(entry, "=")
+		if name, ok := strings.CutPrefix(key, "PROMPT_VAR_"); ok && name != "" {
+			vars[name] = value
+		}
+	}
+	return vars
+}
+
+// LoadProviders returns every configured provider keyed by name. The default
+// provider (from the unprefixed variables) is included under its
+// MODEL_PROVIDER name, or "default" if unset. Additional providers are listed
+// in PROVIDERS (e.g. "openai,deepseek") and configured with prefixed variables
+// such as OPENAI_API_KEY, OPENAI_API_URL_BASE, OPENAI_APIS and OPENAI_MODEL.
+// Incomplete named providers are skipped with a warning.
+func LoadProviders(defaultProvider types.ModelProvider) map[string]types.ModelProvider {
+	providers := make(map[string]types.ModelProvider)
+	providers[DefaultProviderName(defaultProvider)] = defaultProvider
+
+	for _, name := range strings.Split(os.Getenv("PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		provider, err := loadNamedProvider(name)
+		if err != nil {
+			log.Printf("Warning: Skipping provider '%s': %v", name, err)
+			continue
+		}
+		providers[name] = provider
+	}
+	return providers
+}
+
+// DefaultProviderName returns the name the default provider is registered under.
+func DefaultProviderName(provider types.ModelProvider) string {
+	if name := strings.ToLower(strings.TrimSpace(provider.Provider)); name != "" {
+		return name
+	}
+	return "default"
+}
+
+// loadNamedProvider reads a provider from variables prefixed with its upper-cased name.
+func loadNamedProvider(name string) (types.ModelProvider, error) {
+	return readProvider(strings.ToUpper(name)+"_", name)
+}
+
+// readProvider reads a provider from API_KEY (or API_KEY_FILE or API_KEY_CMD,
+// see resolveAPIKey), API_URL_BASE, APIS and MODEL (with the given prefix),
+// plus the optional ORG_ID, PROJECT_ID and EXTRA_HEADERS. With
+// PROVIDER_FORMAT=azure, APIS may be omitted; DEPLOYMENT (default MODEL) and
+// API_VERSION then build the chat URL. Empty or whitespace-only values count
+// as missing. The error lists every missing variable rather than just the first.
+func readProvider(prefix, name string) (types.ModelProvider, error) {
+	get := func(key string) string { return strings.TrimSpace(os.Getenv(prefix + key)) }
+	apiKey, keyErr := resolveAPIKey(prefix)
+	apiBase := get("API_URL_BASE")
+	apisString := get("APIS") // e.g., "chat:/v1/chat/completions,models:/v1/models"
+	model := get("MODEL")
+	format := strings.ToLower(get("PROVIDER_FORMAT"))
+	if format == "" {
+		format = "openai"
+	}
+
+	missing := []string{}
+	for _, v := range []struct{ key, value string }{
+		{prefix + "API_KEY", apiKey},
+		{prefix + "API_URL_BASE", apiBase},
+		{prefix + "APIS", apisString},
+		{prefix + "MODEL", model},
+	} {
+		if v.key == prefix+"API_KEY" && (keyErr != nil || format == "ollama") {
+			continue // A failed key lookup is reported on its own; a local Ollama needs no key
+		}
+		if v.value == "" && !(format == "azure" && v.key == prefix+"APIS") { // Azure paths are built from the deployment
+			missing = append(missing, v.key)
+		}
+	}
 
-	// Validate required variables
-	if apiKey == "" {
-		log.Fatal("FATAL: API_KEY environment variable not set.")
+	problems := []string{}
+	if keyErr != nil {
+		problems = append(problems, keyErr.Error())
 	}
-	if apiBase == "" {
-		log.Fatal("FATAL: API_URL_BASE environment variable not set.")
+	if len(missing) > 0 {
+		problems = append(problems, "missing required environment variables: "+strings.Join(missing, ", "))
 	}
-	if apisString == "" {
-		log.Fatal("FATAL: APIS environment variable not set (e.g., 'chat:/v1/chat/completions').")
+	if !isProviderFormat(format) {
+		problems = append(problems, fmt.Sprintf("%sPROVIDER_FORMAT must be one of %s (got '%s')", prefix, strings.Join(providerFormats, ", "), format))
 	}
-	if model == "" {
-		log.Fatal("FATAL: MODEL environment variable not set.")
+	if apiBase != "" {
+		if err := validateBaseURL(apiBase); err != nil {
+			problems = append(problems, fmt.Sprintf("%sAPI_URL_BASE %v", prefix, err))
+		}
 	}
 
-	// Parse the APIS string into a map
-	apis := make(map[string]string)
-	for _, apiEntry := range strings.Split(apisString, ",") {
-		parts := strings.SplitN(strings.TrimSpace(apiEntry), ":", 2)
+	// Parse the APIS string into a map; the crucial 'chat' endpoint must be defined
+	var apis map[string]string
+	if apisString != "" {
+		apis = parseKeyValueList(apisString, prefix+"APIS", "key:path")
+		if _, ok := apis["chat"]; !ok && format != "azure" {
+			problems = append(problems, prefix+"APIS must contain a 'chat' endpoint (e.g., 'chat:/v1/chat/completions')")
+		}
+		for _, key := range sortedKeys(apis) {
+			if !strings.HasPrefix(apis[key], "/") {
+				problems = append(problems, fmt.Sprintf("%sAPIS path for '%s' must start with '/' (got '%s')", prefix, key, apis[key]))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return types.ModelProvider{}, errors.New(strings.Join(problems, "; "))
+	}
+
+	return types.ModelProvider{
+		Provider: name,
+		UrlBase:  strings.TrimSuffix(apiBase, "/"), // Remove trailing slash for consistency
+		APIKey:   apiKey,
+		APIs:     apis,
+		Model:    model,
+		Format:   format,
+
+		Deployment: orDefault(get("DEPLOYMENT"), model),
+		APIVersion: orDefault(get("API_VERSION"), defaultAzureAPIVersion),
+
+		OrgID:     get("ORG_ID"),
+		ProjectID: get("PROJECT_ID"),
+
+		ExtraHeaders: parseKeyValueList(os.Getenv(prefix+"EXTRA_HEADERS"), prefix+"EXTRA_HEADERS", "Name:Value"),
+
+		RoleContentPrefix: parseKeyValueList(os.Getenv(prefix+"ROLE_CONTENT_PREFIX"), prefix+"ROLE_CONTENT_PREFIX", "role:prefix"),
+	}, nil
+}
+
+// How long API_KEY_CMD may run before it is abandoned
+const apiKeyCmdTimeout = 30 * time.Second
+
+// resolveAPIKey returns the API key from the first of these that is set (with
+// the given prefix): API_KEY_FILE, a file holding the key; API_KEY_CMD, a
+// shell command printing it (e.g. a password manager lookup); or API_KEY
+// itself. Surrounding whitespace is trimmed.
+func resolveAPIKey(prefix string) (string, error) {
+	if path := strings.TrimSpace(os.Getenv(prefix + "API_KEY_FILE")); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("%sAPI_KEY_FILE could not be read: %w", prefix, err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	if command := strings.TrimSpace(os.Getenv(prefix + "API_KEY_CMD")); command != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), apiKeyCmdTimeout)
+		defer cancel()
+		var stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, "sh", "-c", command)
+		cmd.Stderr = &stderr
+		cmd.WaitDelay = time.Second // A background child holding the output open can't outlast the timeout
+		out, err := cmd.Output()
+		if err != nil && !errors.Is(err, exec.ErrWaitDelay) { // ErrWaitDelay: the command finished, its output is complete
+			if detail := strings.TrimSpace(stderr.String()); detail != "" {
+				err = fmt.Errorf("%w: %s", err, detail)
+			}
+			return "", fmt.Errorf("%sAPI_KEY_CMD failed: %w", prefix, err)
+		}
+		return strings.TrimSpace(string(out)), nil
+	}
+	return strings.TrimSpace(os.Getenv(prefix + "API_KEY")), nil
+}
+
+// API formats accepted by PROVIDER_FORMAT (see api.ProviderFor)
+var providerFormats = []string{"openai", "anthropic", "ollama", "azure"}
+
+// api-version sent to Azure OpenAI when API_VERSION is unset
+const defaultAzureAPIVersion = "2024-10-21"
+
+// orDefault returns value, or fallback if value is empty.
+func orDefault(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+// isProviderFormat reports whether format is a supported API format.
+func isProviderFormat(format string) bool {
+	for _, known := range providerFormats {
+		if format == known {
+			return true
+		}
+	}
+	return false
+}
+
+// validateBaseURL checks that raw is an absolute http(s) URL with a host, so
+// a typo is caught at startup rather than as an HTTP error mid-conversation.
+func validateBaseURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("is not a valid URL: %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("must start with http:// or https:// (got '%s')", raw)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("has no host (got '%s')", raw)
+	}
+	return nil
+}
+
+// sortedKeys returns the map's keys in order, for deterministic messages.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// parseKeyValueList parses a comma-separated "key:value" list (as used by APIS)
+// into a map. Malformed entries are skipped with a warning naming envName.
+func parseKeyValueList(raw, envName, format string) map[string]string {
+	result := make(map[string]string)
+	for _, entry := range strings.Split(raw, ",") {
+		parts := strings.SplitN(strings.TrimSpace(entry), ":", 2)
 		if len(parts) == 2 {
 			key := strings.TrimSpace(parts[0])
 			value := strings.TrimSpace(parts[1])
 			if key != "" && value != "" {
-				apis[key] = value
+				result[key] = value
 			} else {
-				log.Printf("Warning: Skipping malformed API entry in APIS env var: '%s'", apiEntry)
+				log.Printf("Warning: Skipping malformed entry in %s env var: '%s'", envName, entry)
 			}
 		} else if len(parts) == 1 && strings.TrimSpace(parts[0]) != "" {
-			log.Printf("Warning: API entry missing path in APIS env var: '%s'. Requires 'key:path' format.", apiEntry)
+			log.Printf("Warning: Entry missing value in %s env var: '%s'. Requires '%s' format.", envName, entry, format)
 		}
 	}
+	return result
+}
+
+// LoadSettings reads optional behaviour toggles from the environment.
+// It should be called after Load so that any .env file has been applied.
+func LoadSettings() types.Settings {
+	return types.Settings{
+		DefaultMaxTokens:     envInt("MAX_TOKENS", 32000),
+		TruncationStrategy:   envChoice("TRUNCATION_STRATEGY", "simple", "simple", "turn-window", "relevance", "summarize"),
+		ContextTurns:         envInt("CONTEXT_TURNS", 10),
+		SystemPromptInBudget: envBool("SYSTEM_PROMPT_IN_BUDGET", true),
+		MaxHistoryMessages:   envInt("MAX_HISTORY_MESSAGES", 0),
+		ContextWarnRatio:     envFloat("CONTEXT_WARN_RATIO", 0.9),
+		RequestTimeout:       time.Duration(envInt("REQUEST_TIMEOUT", 60)) * time.Second,
+		ConnectTimeout:       time.Duration(envInt("CONNECT_TIMEOUT", 10)) * time.Second,
+		StreamIdleTimeout:    time.Duration(envInt("STREAM_IDLE_TIMEOUT", 60)) * time.Second,
+		MaxRetries:           envInt("MAX_RETRIES", 3),
+		RateLimitRPS:         envFloat("RATE_LIMIT_RPS", 0),
+		StreamUsage:          envBool("STREAM_USAGE", true),
+		StreamMaxLineBytes:   envInt("STREAM_MAX_LINE_BYTES", 1024*1024),
+		StreamPayloadJoins:   envInt("STREAM_PAYLOAD_JOINS", 2),
+		PromptShowTokens:     envBool("PROMPT_SHOW_TOKENS", false),
+		RenderMarkdown:       envBool("RENDER_MARKDOWN", false),
+		Wrap:                 envBool("WRAP", false),
+		BotPrefix:            envString("BOT_PREFIX", "Bot: "),
+		UserPrefix:           envString("USER_PREFIX", "You: "),
+		ReasoningPrefix:      envString("REASONING_PREFIX", "Reasoning: "),
+		ShowReasoning:        envChoice("SHOW_REASONING", "full", "full", "collapsed", "hidden"),
+		Color:                envBool("COLOR", true),
+		OutputFilterCmd:      strings.TrimSpace(os.Getenv("OUTPUT_FILTER_CMD")),
+		OutputFilterTimeout:  time.Duration(envInt("OUTPUT_FILTER_TIMEOUT", 10)) * time.Second,
+
+		SystemReminderInterval: envInt("SYSTEM_REMINDER_INTERVAL", 0),
+		SystemReminderText:     os.Getenv("SYSTEM_REMINDER_TEXT"),
+
+		TranscriptFile: strings.TrimSpace(os.Getenv("TRANSCRIPT_FILE")),
+
+		RecordSession: strings.TrimSpace(os.Getenv("RECORD_SESSION")),
+		ReplaySession: strings.TrimSpace(os.Getenv("REPLAY_SESSION")),
 
-	// Ensure the crucial 'chat' endpoint is defined
-	if _, ok := apis["chat"]; !ok {
-		log.Fatal("FATAL: APIS environment variable must contain a 'chat' endpoint (e.g., 'chat:/v1/chat/completions').")
+		ProxyURL: proxyURL(),
+
+		UserAgent: strings.TrimSpace(os.Getenv("USER_AGENT")),
+
+		ServeAddr: envString("SERVE_ADDR", "127.0.0.1:8080"),
+
+		DropEmptyAssistant: envBool("DROP_EMPTY_ASSISTANT", true),
+		OnCancel:           envChoice("ON_CANCEL", "rollback", "rollback", "keep"),
+
+		KeepPartialResponse: envBool("KEEP_PARTIAL_RESPONSE", false),
+		KeepReasoning:       envBool("KEEP_REASONING", false),
+
+		EnableTools: envBool("TOOLS", false),
+
+		ExpandFileRefs:    envBool("EXPAND_FILE_REFS", false), // Opt-in: "@" also starts mentions, handles and decorators
+		StoreExpandedRefs: envChoice("FILE_REFS_STORE", "raw", "raw", "expanded") == "expanded",
+
+		Sampling: loadSamplingParams(),
+
+		Debug: envBool("DEBUG", false),
+
+		PersistSession: envBool("PERSIST_SESSION", false),
+		SessionFile:    chatbotFile("SESSION_FILE", "session.json"),
+
+		AliasesFile: chatbotFile("ALIASES_FILE", "aliases.json"),
 	}
+}
 
-	// Return the configured provider struct
-	return types.ModelProvider{
-		Provider: providerName,
-		UrlBase:  strings.TrimSuffix(apiBase, "/"), // Remove trailing slash for consistency
-		APIKey:   apiKey,
-		APIs:     apis,
-		Model:    model,
-	}, nil
+// chatbotFile returns the path in the environment variable key, defaulting to
+// ~/.chatbot/name (or .chatbot/name in the working directory if home is unknown).
+func chatbotFile(key, name string) string {
+	if path := strings.TrimSpace(os.Getenv(key)); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(".chatbot", name)
+	}
+	return filepath.Join(home, ".chatbot", name)
+}
+
+// proxyURL returns PROXY_URL if it is a usable proxy address. An invalid
+// value is ignored with a warning, leaving the standard proxy variables in effect.
+func proxyURL() string {
+	raw := strings.TrimSpace(os.Getenv("PROXY_URL"))
+	if raw == "" {
+		return ""
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5") {
+		log.Printf("Warning: Invalid PROXY_URL '%s' (expected http://, https:// or socks5://host:port), ignoring it", raw)
+		return ""
+	}
+	return raw
+}
+
+// envString returns an environment variable verbatim (surrounding spaces
+// matter for prefixes), or def when it is unset. Set but empty means empty.
+func envString(key string, def string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return def
+}
+
+// envBool parses a boolean environment variable, returning def when unset or invalid.
+func envBool(key string, def bool) bool {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return def
+	}
+	value, err := strconv.ParseBool(raw)
+	if err != nil {
+		log.Printf("Warning: Invalid boolean for %s: '%s', using default %t", key, raw, def)
+		return def
+	}
+	return value
+}
+
+// envInt parses an integer environment variable, returning def when unset or invalid.
+func envInt(key string, def int) int {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return def
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil {
+		log.Printf("Warning: Invalid integer for %s: '%s', using default %d", key, raw, def)
+		return def
+	}
+	return value
+}
+
+// envFloat parses a non-negative number from the environment, returning def when unset or invalid.
+func envFloat(key string, def float64) float64 {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return def
+	}
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil || value < 0 {
+		log.Printf("Warning: Invalid number for %s: '%s', using default %g", key, raw, def)
+		return def
+	}
+	return value
+}
+
+// envChoice reads an environment variable that must be one of allowed,
+// returning def when unset or invalid.
+func envChoice(key string, def string, allowed ...string) string {
+	raw := strings.ToLower(strings.TrimSpace(os.Getenv(key)))
+	if raw == "" {
+		return def
+	}
+	for _, choice := range allowed {
+		if raw == choice {
+			return raw
+		}
+	}
+	log.Printf("Warning: Invalid value for %s: '%s' (expected one of %s), using default '%s'", key, raw, strings.Join(allowed, ", "), def)
+	return def
 }
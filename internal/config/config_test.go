@@ -0,0 +1,182 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadProviderReportsEveryProblem(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    []string // Substrings the error must contain
+		notWant []string
+	}{
+		{
+			name: "nothing set",
+			env:  map[string]string{},
+			want: []string{"T_API_KEY", "T_API_URL_BASE", "T_APIS", "T_MODEL"},
+		},
+		{
+			name:    "unreadable key file hides no missing variable",
+			env:     map[string]string{"T_API_KEY_FILE": "/nonexistent/key", "T_MODEL": "m"},
+			want:    []string{"T_API_KEY_FILE could not be read", "missing required environment variables: T_API_URL_BASE, T_APIS"},
+			notWant: []string{"T_MODEL", "variables: T_API_KEY"},
+		},
+		{
+			name:    "failing key command hides no missing variable",
+			env:     map[string]string{"T_API_KEY_CMD": "exit 3", "T_APIS": "chat:/c"},
+			want:    []string{"T_API_KEY_CMD failed", "missing required environment variables: T_API_URL_BASE, T_MODEL"},
+			notWant: []string{"variables: T_API_KEY"},
+		},
+		{
+			name:    "ollama needs no key",
+			env:     map[string]string{"T_PROVIDER_FORMAT": "ollama", "T_MODEL": "m"},
+			want:    []string{"missing required environment variables: T_API_URL_BASE, T_APIS"},
+			notWant: []string{"T_API_KEY"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			_, err := readProvider("T_", "t")
+			if err == nil {
+				t.Fatal("got no error")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not mention %q", err, want)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(err.Error(), notWant) {
+					t.Errorf("error %q mentions %q", err, notWant)
+				}
+			}
+		})
+	}
+}
+
+func TestResolveAPIKeyCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		want    string
+		wantErr bool
+	}{
+		{"prints the key", "echo ' secret '", "secret", false},
+		{"background child holding stdout", "sleep 10 & echo secret", "secret", false},
+		{"fails", "echo denied >&2; exit 1", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("K_API_KEY_CMD", tt.command)
+			start := time.Now()
+			got, err := resolveAPIKey("K_")
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("got %q, %v; want %q (error: %v)", got, err, tt.want, tt.wantErr)
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("took %s waiting on the background child", elapsed)
+			}
+		})
+	}
+}
+
+func TestReadProviderValidatesURLs(t *testing.T) {
+	tests := []struct {
+		name    string
+		base    string
+		apis    string
+		wantErr string // "" for a valid configuration
+	}{
+		{name: "valid", base: "https://api.example.com/", apis: "chat:/v1/chat/completions,models:/v1/models"},
+		{name: "http with port", base: "http://localhost:11434", apis: "chat:/api/chat"},
+		{name: "missing scheme", base: "api.example.com", apis: "chat:/c", wantErr: "must start with http:// or https://"},
+		{name: "relative URL", base: "/v1", apis: "chat:/c", wantErr: "must start with http:// or https://"},
+		{name: "other scheme", base: "ftp://api.example.com", apis: "chat:/c", wantErr: "must start with http:// or https://"},
+		{name: "no host", base: "https://", apis: "chat:/c", wantErr: "has no host"},
+		{name: "unparsable", base: "http://[::1", apis: "chat:/c", wantErr: "is not a valid URL"},
+		{name: "path without slash", base: "https://api.example.com", apis: "chat:v1/chat", wantErr: "APIS path for 'chat' must start with '/'"},
+		{name: "no chat endpoint", base: "https://api.example.com", apis: "models:/v1/models", wantErr: "must contain a 'chat' endpoint"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("U_API_KEY", "key")
+			t.Setenv("U_MODEL", "m")
+			t.Setenv("U_API_URL_BASE", tt.base)
+			t.Setenv("U_APIS", tt.apis)
+			provider, err := readProvider("U_", "u")
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if strings.HasSuffix(provider.UrlBase, "/") {
+					t.Errorf("trailing slash kept in %q", provider.UrlBase)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v, want one mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestProxyURL(t *testing.T) {
+	tests := map[string]string{
+		"":                         "",
+		"http://proxy.corp:3128":   "http://proxy.corp:3128",
+		" https://proxy.corp:443 ": "https://proxy.corp:443",
+		"socks5://127.0.0.1:1080":  "socks5://127.0.0.1:1080",
+		"proxy.corp:3128":          "",
+		"ftp://proxy.corp":         "",
+		"http://":                  "",
+	}
+	for value, want := range tests {
+		t.Setenv("PROXY_URL", value)
+		if got := proxyURL(); got != want {
+			t.Errorf("PROXY_URL=%q: got %q, want %q", value, got, want)
+		}
+	}
+}
+
+func TestParseKeyValueList(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want map[string]string
+	}{
+		{"", map[string]string{}},
+		{"HTTP-Referer:https://example.com", map[string]string{"HTTP-Referer": "https://example.com"}},
+		{" api-version : 2024-06-01 , X-Title:My App ", map[string]string{"api-version": "2024-06-01", "X-Title": "My App"}},
+		{"!Authorization:Basic abc==", map[string]string{"!Authorization": "Basic abc=="}},
+		{"Missing-Value, :no-name, Empty:, Good:yes", map[string]string{"Good": "yes"}},
+	}
+	for _, tt := range tests {
+		got := parseKeyValueList(tt.raw, "EXTRA_HEADERS", "Name:Value")
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("parseKeyValueList(%q) = %v, want %v", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestExpandFileRefsIsOptIn(t *testing.T) {
+	tests := []struct {
+		env  string
+		want bool
+	}{
+		{"", false},
+		{"true", true},
+		{"false", false},
+	}
+	for _, tt := range tests {
+		t.Setenv("EXPAND_FILE_REFS", tt.env)
+		if got := LoadSettings().ExpandFileRefs; got != tt.want {
+			t.Errorf("EXPAND_FILE_REFS=%q: expansion %v, want %v", tt.env, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/henryhwang/chatbot/internal/types"
+)
+
+// --- Sampling Parameters ---
+
+// samplingParam describes one optional request parameter settable via env or /set.
+type samplingParam struct {
+	name     string // Name used by /set and in the request JSON
+	env      string // Environment variable providing the default
+	min, max float64
+	integer  bool
+}
+
+var samplingParams = []samplingParam{
+	{name: "temperature", env: "TEMPERATURE", min: 0, max: 2},
+	{name: "top_p", env: "TOP_P", min: 0, max: 1},
+	// MAX_TOKENS already sizes the context budget, so the completion limit uses its own name
+	{name: "max_tokens", env: "RESPONSE_MAX_TOKENS", min: 1, max: 1 << 30, integer: true},
+	{name: "presence_penalty", env: "PRESENCE_PENALTY", min: -2, max: 2},
+	{name: "seed", env: "SEED", min: -(1 << 53), max: 1 << 53, integer: true}, // Exact as a float64
+	{name: "n", env: "CHOICES", min: 1, max: 128, integer: true},
+}
+
+// SamplingParamNames lists the parameters accepted by SetSamplingParam.
+func SamplingParamNames() []string {
+	names := make([]string, len(samplingParams))
+	for i, p := range samplingParams {
+		names[i] = p.name
+	}
+	return append(names, "stop", "format")
+}
+
+// SetSamplingParam parses raw and stores it in params under name. A raw value
+// of "off" or "unset" clears the parameter so it isn't sent at all. For
+// "stop", raw is a comma-separated list (see parseStopSequences); for
+// "format", see parseResponseFormat.
+func SetSamplingParam(params *types.SamplingParams, name, raw string) error {
+	if name == "format" {
+		format, err := parseResponseFormat(raw)
+		if err != nil {
+			return err
+		}
+		params.ResponseFormat = format
+		return nil
+	}
+	if name == "stop" {
+		stop, err := parseStopSequences(raw)
+		if err != nil {
+			return err
+		}
+		params.Stop = stop
+		return nil
+	}
+
+	var spec *samplingParam
+	for i := range samplingParams {
+		if samplingParams[i].name == name {
+			spec = &samplingParams[i]
+		}
+	}
+	if spec == nil {
+		return fmt.Errorf("unknown parameter '%s' (expected one of %s)", name, strings.Join(SamplingParamNames(), ", "))
+	}
+
+	raw = strings.ToLower(strings.TrimSpace(raw))
+	if raw == "off" || raw == "unset" {
+		setSamplingValue(params, name, nil)
+		return nil
+	}
+	value, err := strconv.ParseFloat(raw, 64)
+	// ParseFloat accepts "nan" and "inf", which can't be sent as JSON
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) || (spec.integer && value != float64(int(value))) {
+		kind := "a number"
+		if spec.integer {
+			kind = "an integer"
+		}
+		return fmt.Errorf("%s must be %s (got '%s')", name, kind, raw)
+	}
+	if value < spec.min || value > spec.max {
+		return fmt.Errorf("%s must be between %g and %g (got %g)", name, spec.min, spec.max, value)
+	}
+	setSamplingValue(params, name, &value)
+	return nil
+}
+
+// setSamplingValue assigns (or clears, when value is nil) the named field.
+func setSamplingValue(params *types.SamplingParams, name string, value *float64) {
+	switch name {
+	case "temperature":
+		params.Temperature = value
+	case "top_p":
+		params.TopP = value
+	case "presence_penalty":
+		params.PresencePenalty = value
+	case "max_tokens":
+		params.MaxTokens = intValue(value)
+	case "seed":
+		params.Seed = intValue(value)
+	case "n":
+		params.N = intValue(value)
+	}
+}
+
+// intValue converts an optional whole-number value to an optional int.
+func intValue(value *float64) *int {
+	if value == nil {
+		return nil
+	}
+	n := int(*value)
+	return &n
+}
+
+// parseStopSequences splits a comma-separated list of stop sequences. An
+// item may be double-quoted to keep surrounding spaces or commas, or to use
+// escapes such as "\n". "off" or "unset" clears the list.
+func parseStopSequences(raw string) ([]string, error) {
+	raw = strings.TrimSpace(raw)
+	if lower := strings.ToLower(raw); lower == "off" || lower == "unset" || raw == "" {
+		return nil, nil
+	}
+
+	var stop []string
+	for raw != "" {
+		item := raw
+		if strings.HasPrefix(raw, `"`) {
+			quoted, err := strconv.QuotedPrefix(raw)
+			if err != nil {
+				return nil, fmt.Errorf("stop has an unterminated or invalid quoted sequence: %s", raw)
+			}
+			item, _ = strconv.Unquote(quoted)
+			raw = strings.TrimSpace(raw[len(quoted):])
+			if raw != "" && !strings.HasPrefix(raw, ",") {
+				return nil, fmt.Errorf("stop sequences must be separated by commas (near '%s')", raw)
+			}
+		} else if i := strings.Index(raw, ","); i >= 0 {
+			item = strings.TrimSpace(raw[:i])
+			raw = raw[i:]
+		} else {
+			item = strings.TrimSpace(raw)
+			raw = ""
+		}
+		raw = strings.TrimSpace(strings.TrimPrefix(raw, ","))
+		if item == "" {
+			return nil, fmt.Errorf("stop sequences must not be empty")
+		}
+		stop = append(stop, item)
+	}
+	return stop, nil
+}
+
+// parseResponseFormat reads a response format: "json" for any JSON object,
+// "schema <file>" for replies following the JSON schema in file (named
+// after it), or "text", "off" or "unset" for no constraint.
+func parseResponseFormat(raw string) (*types.ResponseFormat, error) {
+	kind, path, _ := strings.Cut(strings.TrimSpace(raw), " ")
+	switch strings.ToLower(kind) {
+	case "text", "off", "unset", "":
+		return nil, nil
+	case "json", "json_object":
+		return &types.ResponseFormat{Type: "json_object"}, nil
+	case "schema", "json_schema":
+		path = strings.TrimSpace(path)
+		if path == "" {
+			return nil, fmt.Errorf("format schema needs the file holding the JSON schema")
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading schema: %w", err)
+		}
+		if !json.Valid(data) {
+			return nil, fmt.Errorf("schema %s is not valid JSON", path)
+		}
+		return &types.ResponseFormat{Type: "json_schema", JSONSchema: &types.JSONSchema{
+			Name:   schemaName(path),
+			Schema: json.RawMessage(data),
+			Strict: true,
+		}}, nil
+	}
+	return nil, fmt.Errorf("unknown format '%s' (expected json, schema <file> or text)", kind)
+}
+
+// schemaName derives a schema name from its file name, keeping the
+// characters the API allows (letters, digits, "_" and "-").
+func schemaName(path string) string {
+	base := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	name := strings.Map(func(r rune) rune {
+		if r == '_' || r == '-' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, base)
+	if name == "" {
+		return "schema"
+	}
+	return name
+}
+
+// loadSamplingParams reads parameter defaults from the environment. Invalid
+// values are skipped with a warning, leaving the parameter unset.
+func loadSamplingParams() types.SamplingParams {
+	var params types.SamplingParams
+	if raw := os.Getenv("RESPONSE_FORMAT"); strings.TrimSpace(raw) != "" {
+		format, err := parseResponseFormat(raw)
+		if err != nil {
+			log.Printf("Warning: Invalid RESPONSE_FORMAT: %v, leaving it unset", err)
+		}
+		params.ResponseFormat = format
+	}
+	if raw := os.Getenv("STOP_SEQUENCES"); strings.TrimSpace(raw) != "" {
+		stop, err := parseStopSequences(raw)
+		if err != nil {
+			log.Printf("Warning: Invalid STOP_SEQUENCES: %v, leaving it unset", err)
+		}
+		params.Stop = stop
+	}
+	for _, p := range samplingParams {
+		raw := strings.TrimSpace(os.Getenv(p.env))
+		if raw == "" {
+			continue
+		}
+		if err := SetSamplingParam(&params, p.name, raw); err != nil {
+			log.Printf("Warning: Invalid %s: %v, leaving it unset", p.env, err)
+		}
+	}
+	return params
+}
+
+// FormatSamplingParams renders the parameters for display, e.g.
+// "temperature=0.2 top_p=(unset) ...".
+func FormatSamplingParams(params types.SamplingParams) string {
+	format := func(v *float64) string {
+		if v == nil {
+			return "(unset)"
+		}
+		return strconv.FormatFloat(*v, 'g', -1, 64)
+	}
+	formatInt := func(v *int) string {
+		if v == nil {
+			return "(unset)"
+		}
+		return strconv.Itoa(*v)
+	}
+	stop := "(unset)"
+	if len(params.Stop) > 0 {
+		quoted := make([]string, len(params.Stop))
+		for i, s := range params.Stop {
+			quoted[i] = strconv.Quote(s)
+		}
+		stop = strings.Join(quoted, ",")
+	}
+	responseFormat := "(unset)"
+	if f := params.ResponseFormat; f != nil {
+		responseFormat = "json"
+		if f.JSONSchema != nil {
+			responseFormat = "schema:" + f.JSONSchema.Name
+		}
+	}
+	return fmt.Sprintf("temperature=%s top_p=%s max_tokens=%s presence_penalty=%s seed=%s n=%s stop=%s format=%s",
+		format(params.Temperature), format(params.TopP), formatInt(params.MaxTokens), format(params.PresencePenalty), formatInt(params.Seed), formatInt(params.N), stop, responseFormat)
+}
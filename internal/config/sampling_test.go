@@ -0,0 +1,149 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/henryhwang/chatbot/internal/types"
+)
+
+func TestSetSamplingParam(t *testing.T) {
+	tests := []struct {
+		name, param, raw string
+		wantErr          bool
+		want             string // FormatSamplingParams field after setting, e.g. "temperature=0.5"
+	}{
+		{name: "number", param: "temperature", raw: "0.5", want: "temperature=0.5"},
+		{name: "off clears", param: "temperature", raw: "off", want: "temperature=(unset)"},
+		{name: "nan", param: "temperature", raw: "nan", wantErr: true},
+		{name: "NaN mixed case", param: "top_p", raw: "NaN", wantErr: true},
+		{name: "inf within no bound", param: "max_tokens", raw: "inf", wantErr: true},
+		{name: "negative inf", param: "presence_penalty", raw: "-Inf", wantErr: true},
+		{name: "infinity spelled out", param: "seed", raw: "+infinity", wantErr: true},
+		{name: "above max", param: "top_p", raw: "1.5", wantErr: true},
+		{name: "fraction for integer", param: "n", raw: "2.5", wantErr: true},
+		{name: "integer", param: "seed", raw: "42", want: "seed=42"},
+		{name: "unknown", param: "bogus", raw: "1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := types.SamplingParams{}
+			err := SetSamplingParam(&params, tt.param, tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error: %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if FormatSamplingParams(params) != FormatSamplingParams(types.SamplingParams{}) {
+					t.Errorf("rejected value was stored: %s", FormatSamplingParams(params))
+				}
+				return
+			}
+			if got := FormatSamplingParams(params); !containsField(got, tt.want) {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+			if _, err := json.Marshal(params); err != nil {
+				t.Errorf("params no longer marshal: %v", err)
+			}
+		})
+	}
+}
+
+// containsField reports whether the space-separated fields include field.
+func containsField(fields, field string) bool {
+	return slices.Contains(strings.Fields(fields), field)
+}
+
+func TestParseStopSequences(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    []string
+		wantErr bool
+	}{
+		{raw: "###", want: []string{"###"}},
+		{raw: "END, STOP", want: []string{"END", "STOP"}},
+		{raw: `"###"`, want: []string{"###"}},
+		{raw: `"a, b", " c "`, want: []string{"a, b", " c "}},
+		{raw: `"\n\n",END`, want: []string{"\n\n", "END"}},
+		{raw: "off"},
+		{raw: "unset"},
+		{raw: ""},
+		{raw: "a,,b", wantErr: true},
+		{raw: `"unterminated`, wantErr: true},
+		{raw: `"a" b`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			got, err := parseStopSequences(tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error: %v", err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStopSequencesFromEnv(t *testing.T) {
+	t.Setenv("STOP_SEQUENCES", `###,"\n\n"`)
+	if got, want := loadSamplingParams().Stop, []string{"###", "\n\n"}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	t.Setenv("STOP_SEQUENCES", "")
+	if got := loadSamplingParams().Stop; got != nil {
+		t.Errorf("got %q while unset", got)
+	}
+}
+
+func TestParseResponseFormat(t *testing.T) {
+	dir := t.TempDir()
+	schema := filepath.Join(dir, "person v2.json")
+	if err := os.WriteFile(schema, []byte(`{"type":"object","properties":{"name":{"type":"string"}}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	invalid := filepath.Join(dir, "broken.json")
+	if err := os.WriteFile(invalid, []byte(`{"type":`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		raw     string
+		want    string // Marshaled "response_format" member ("" for none)
+		wantErr bool
+	}{
+		{name: "json", raw: "json", want: `"response_format":{"type":"json_object"}`},
+		{name: "json_object", raw: "json_object", want: `"response_format":{"type":"json_object"}`},
+		{name: "schema", raw: "schema " + schema, want: `"response_format":{"type":"json_schema","json_schema":{"name":"person_v2","schema":{"type":"object","properties":{"name":{"type":"string"}}},"strict":true}}`},
+		{name: "text", raw: "text"},
+		{name: "off", raw: "off"},
+		{name: "schema without file", raw: "schema", wantErr: true},
+		{name: "missing schema", raw: "schema " + filepath.Join(dir, "none.json"), wantErr: true},
+		{name: "invalid schema", raw: "schema " + invalid, wantErr: true},
+		{name: "unknown", raw: "xml", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := types.SamplingParams{}
+			err := SetSamplingParam(&params, "format", tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error: %v", err, tt.wantErr)
+			}
+			data, err := json.Marshal(params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.want == "" {
+				if strings.Contains(string(data), "response_format") {
+					t.Errorf("response_format sent while unset: %s", data)
+				}
+			} else if !strings.Contains(string(data), tt.want) {
+				t.Errorf("got %s, want it to contain %s", data, tt.want)
+			}
+		})
+	}
+}
@@ -1,47 +1,98 @@
 package conversation
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"strings"
+	"sync"
 	"time" // Import time package
 
 	"github.com/henryhwang/chatbot/internal/types"
 )
 
-func estimateTokens(text string) int {
+// EstimateTokens returns a rough token estimate for text.
+func EstimateTokens(text string) int {
 	const baseCost = 5
 	return baseCost + len(text)/4
 }
 
+// messageTokens returns the token estimate for msg, computing it on first use
+// and caching it on the message. Messages in the history have it computed when
+// they are added or edited, so strategies only read it.
+func messageTokens(msg *types.Message) int {
+	if msg.TokenCount == 0 {
+		msg.TokenCount = EstimateTokens(msg.Content)
+	}
+	return msg.TokenCount
+}
+
+// ContextGenerationStrategy selects the messages sent to the API. Besides the
+// context, Generate reports how many history messages it left out entirely
+// (messages folded into a summary still count as included).
 type ContextGenerationStrategy interface {
-	Generate(conversation *Conversation) ([]types.Message, error)
+	Generate(conversation *Conversation) ([]types.Message, int, error)
+}
+
+// RequestContextGenerator is implemented by strategies with expensive work
+// (e.g. summarizing with the LLM) that should only be done for a request
+// about to be sent, and be cancellable with it. Generate then reuses the
+// result of the last GenerateForRequest.
+type RequestContextGenerator interface {
+	GenerateForRequest(ctx context.Context, conversation *Conversation) ([]types.Message, int, error)
+}
+
+// ErrSystemPromptTooLarge is returned (wrapped) when the system prompt alone
+// is over the token budget, leaving no room for any message.
+var ErrSystemPromptTooLarge = errors.New("the system prompt is over the context budget")
+
+// systemPromptTooLarge is the error strategies return for a system prompt
+// over the budget, with the sizes involved.
+func systemPromptTooLarge(systemTokens, maxTokens int) error {
+	return fmt.Errorf("%w (~%d of %d tokens); shorten it or raise MAX_TOKENS", ErrSystemPromptTooLarge, systemTokens, maxTokens)
 }
 
-type SimpleTruncationStrategy struct{}
+// SimpleTruncationStrategy keeps the most recent messages that fit in the
+// token budget, which includes the system prompt unless ExcludeSystemPrompt
+// is set (for providers that count it separately, or a prompt small enough
+// to ignore).
+type SimpleTruncationStrategy struct {
+	ExcludeSystemPrompt bool
+}
 
-func (s *SimpleTruncationStrategy) Generate(conversation *Conversation) ([]types.Message, error) {
+func (s *SimpleTruncationStrategy) Generate(conversation *Conversation) ([]types.Message, int, error) {
 	fullHistory := conversation.fullHistory
 	systemPrompt := conversation.systemPrompt
 	maxTokens := conversation.maxTokens
 	currentTokens := 0
 
-	if systemPrompt != nil {
-		systemTokens := estimateTokens(systemPrompt.Content)
+	if systemPrompt != nil && !s.ExcludeSystemPrompt {
+		systemTokens := messageTokens(systemPrompt)
 		if systemTokens > maxTokens {
-			return nil, errors.New("maxTokens is smaller than the system prompt alone")
+			return nil, 0, systemPromptTooLarge(systemTokens, maxTokens)
 		}
 		currentTokens += systemTokens
 	}
 
+	omitted := 0
+
 	conversationContext := []types.Message{}
 	for i := len(fullHistory) - 1; i >= 0; i-- {
 		message := fullHistory[i]
-		messageTokens := estimateTokens(message.Content)
+		tokens := messageTokens(&fullHistory[i])
 
-		if currentTokens+messageTokens <= maxTokens {
+		if currentTokens+tokens <= maxTokens {
 			conversationContext = append(conversationContext, message)
-			currentTokens += messageTokens
+			currentTokens += tokens
+		} else if i == len(fullHistory)-1 && message.Role == "user" {
+			// A request without the user message it is for makes no sense, so
+			// the latest one is sent on its own even when it is over the budget
+			// (CountTokens on the context tells the caller)
+			conversationContext = append(conversationContext, message)
+			omitted = i // Everything older
+			break
 		} else {
+			omitted = i + 1 // This message and everything older
 			break
 		}
 	}
@@ -58,68 +109,445 @@ func (s *SimpleTruncationStrategy) Generate(conversation *Conversation) ([]types
 	}
 	finalContext = append(finalContext, finalConversation...)
 
-	return finalContext, nil
+	return finalContext, omitted, nil
 }
 
-// Conversation manages the history of messages in a chat session.
+// Conversation manages the history of messages in a chat session. Its
+// methods are safe for concurrent use; context strategies run on a snapshot,
+// so a slow one (e.g. summarizing) doesn't hold up adding messages.
 type Conversation struct {
+	mu sync.RWMutex // Guards the fields below
+
 	systemPrompt *types.Message
 	fullHistory  []types.Message
 	strategy     ContextGenerationStrategy
 	maxTokens    int
+
+	reminderInterval int    // Reinject a system reminder every N user turns (0 disables)
+	reminderText     string // Reminder content; defaults to the system prompt when empty
+
+	maxHistoryMessages int // Oldest messages are pruned from fullHistory beyond this many (0 = unbounded)
+
+	nearlyFull bool // The context was at the warning ratio when ContextNearlyFull last checked
+
+	lastUsage  *types.UsageInfo // Usage reported for the most recent request (nil if never reported)
+	totalUsage types.UsageInfo  // Cumulative usage across the session
 }
 
 // NewConversation creates a new Conversation instance.
 // Optionally initializes with a system message.
 func NewConversation(systemPromptText string, strategy ContextGenerationStrategy, maxTokens int) *Conversation {
-	var systemMsg *types.Message
-	if strings.TrimSpace(systemPromptText) != "" {
-		systemMsg = &types.Message{Timestamp: time.Now(), Role: "system", Content: systemPromptText}
-	}
 	return &Conversation{
-		systemPrompt: systemMsg,
+		systemPrompt: newSystemMessage(systemPromptText),
 		fullHistory:  []types.Message{},
 		strategy:     strategy,
 		maxTokens:    maxTokens,
 	}
 }
 
+// newSystemMessage returns the system prompt message for text, or nil if text is blank.
+func newSystemMessage(text string) *types.Message {
+	if strings.TrimSpace(text) == "" {
+		return nil
+	}
+	msg := &types.Message{Timestamp: time.Now(), Role: "system", Content: text}
+	msg.TokenCount = EstimateTokens(text)
+	return msg
+}
+
+// snapshot returns a copy of the conversation for a strategy to generate the
+// context from without holding the lock. Token counts are already cached on
+// the messages, so strategies never write to the shared history.
+func (c *Conversation) snapshot() *Conversation {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var systemPrompt *types.Message
+	if c.systemPrompt != nil {
+		msg := *c.systemPrompt
+		systemPrompt = &msg
+	}
+	return &Conversation{
+		systemPrompt:     systemPrompt,
+		fullHistory:      append([]types.Message(nil), c.fullHistory...),
+		strategy:         c.strategy,
+		maxTokens:        c.maxTokens,
+		reminderInterval: c.reminderInterval,
+		reminderText:     c.reminderText,
+	}
+}
+
 // AddMessage appends a new message with the current timestamp to the conversation history.
 // History is no longer truncated here.
 func (c *Conversation) AddMessage(role, content string) {
-	c.fullHistory = append(c.fullHistory, types.Message{
-		Role:      role,
-		Content:   content,
-		Timestamp: time.Now(), // Add timestamp
-	})
+	c.AddMessageWithReasoning(role, content, "")
+}
+
+// AddMessageWithReasoning appends a message along with the reasoning that
+// produced it. The reasoning is kept for saving and export only; it is never
+// part of the API context.
+func (c *Conversation) AddMessageWithReasoning(role, content, reasoning string) {
+	c.AppendMessage(types.Message{Role: role, Content: content, Reasoning: reasoning})
+}
+
+// AppendMessage appends msg as is (e.g. with tool calls), stamped with the current time.
+func (c *Conversation) AppendMessage(msg types.Message) {
+	msg.Timestamp = time.Now() // Add timestamp
+	msg.TokenCount = EstimateTokens(msg.Content)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.fullHistory = append(c.fullHistory, msg)
+	c.pruneHistory()
 }
 
 func (c *Conversation) AddUserMessage(role, content string) {
-	c.fullHistory = append(c.fullHistory, types.Message{
-		Role:      "user",
-		Content:   content,
-		Timestamp: time.Now(), // Add timestamp
-	})
+	c.AppendMessage(types.Message{Role: "user", Content: content})
 }
 
 func (c *Conversation) AddAssistantMessage(role, content string) {
-	c.fullHistory = append(c.fullHistory, types.Message{
-		Role:      "assistant",
-		Content:   content,
-		Timestamp: time.Now(), // Add timestamp
-	})
+	c.AppendMessage(types.Message{Role: "assistant", Content: content})
+}
+
+// SetMaxHistoryMessages caps how many messages are stored, so long sessions
+// don't grow without bound. Unlike context truncation, pruned messages are
+// gone for good (including from /save). The system prompt is kept separately
+// and never pruned. A limit of 0 removes the cap.
+func (c *Conversation) SetMaxHistoryMessages(limit int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxHistoryMessages = limit
+	c.pruneHistory()
+}
+
+// pruneHistory drops the oldest messages once the history exceeds the cap,
+// then any replies (assistant or tool messages) left at the front without
+// the user message they answered, so the history always starts with a user
+// turn. The newest message is always kept.
+func (c *Conversation) pruneHistory() {
+	if c.maxHistoryMessages <= 0 || len(c.fullHistory) <= c.maxHistoryMessages {
+		return
+	}
+	start := len(c.fullHistory) - c.maxHistoryMessages
+	for start < len(c.fullHistory)-1 && c.fullHistory[start].Role != "user" {
+		start++
+	}
+	// Copy so the pruned messages can be garbage collected
+	c.fullHistory = append([]types.Message(nil), c.fullHistory[start:]...)
 }
 
 // GetFullHistory returns the most recent slice of messages suitable for sending to the API,
 // respecting the maxMessagesForAPI limit, without modifying the full history.
 func (c *Conversation) GetFullHistory() []types.Message {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	historyCopy := make([]types.Message, len(c.fullHistory))
 	copy(historyCopy, c.fullHistory)
 
 	return historyCopy
 }
 
-func (c *Conversation) GetContext() []types.Message {
-	context, _ := c.strategy.Generate(c)
+// GetContext returns the messages that would currently be sent to the API.
+// It fails when the strategy can't generate a context at all, e.g. when the
+// system prompt alone is over the token budget.
+func (c *Conversation) GetContext() ([]types.Message, error) {
+	context, _, err := c.GetContextWithOmitted()
+	return context, err
+}
+
+// GetContextWithOmitted returns the context along with the number of history
+// messages that did not fit and were left out of it. The system reminder, if
+// one is due, is added here rather than by the strategy, so it applies to
+// every strategy; room is kept for it in the budget the strategy is given.
+func (c *Conversation) GetContextWithOmitted() ([]types.Message, int, error) {
+	return c.generate(context.Background(), false)
+}
+
+// RequestContext is GetContextWithOmitted for a request about to be sent:
+// strategies may do expensive work for it (see RequestContextGenerator),
+// which cancelling ctx aborts.
+func (c *Conversation) RequestContext(ctx context.Context) ([]types.Message, int, error) {
+	return c.generate(ctx, true)
+}
+
+// generate runs the strategy on a snapshot and adds the system reminder.
+func (c *Conversation) generate(ctx context.Context, forRequest bool) ([]types.Message, int, error) {
+	snapshot := c.snapshot()
+	reminderAt, reminder := snapshot.reminderPosition()
+	if reminderAt >= 0 {
+		snapshot.maxTokens -= messageTokens(&reminder)
+	}
+	var messages []types.Message
+	var omitted int
+	var err error
+	if generator, ok := c.strategy.(RequestContextGenerator); ok && forRequest {
+		messages, omitted, err = generator.GenerateForRequest(ctx, snapshot)
+	} else {
+		messages, omitted, err = c.strategy.Generate(snapshot)
+	}
+	if err != nil || reminderAt < 0 {
+		return messages, omitted, err
+	}
+	return insertReminder(messages, snapshot.fullHistory[reminderAt], reminder), omitted, nil
+}
+
+// insertReminder returns context with reminder placed before target, the user
+// message it travels with. When the strategy left target out (or folded it
+// into a summary), context is returned unchanged.
+func insertReminder(context []types.Message, target, reminder types.Message) []types.Message {
+	for i, msg := range context {
+		if msg.Role == target.Role && msg.Timestamp.Equal(target.Timestamp) && msg.Content == target.Content {
+			withReminder := make([]types.Message, 0, len(context)+1)
+			withReminder = append(withReminder, context[:i]...)
+			withReminder = append(withReminder, reminder)
+			return append(withReminder, context[i:]...)
+		}
+	}
 	return context
 }
+
+// MaxTokens returns the token budget used when generating the context.
+func (c *Conversation) MaxTokens() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.maxTokens
+}
+
+// SetMaxTokens changes the token budget used when generating the context,
+// e.g. after switching to a model with a different context window.
+func (c *Conversation) SetMaxTokens(maxTokens int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxTokens = maxTokens
+}
+
+// ContextTokens returns the estimated token count of the context that would
+// currently be sent to the API, or 0 when none can be generated.
+func (c *Conversation) ContextTokens() int {
+	context, _ := c.GetContext()
+	return CountTokens(context)
+}
+
+// CountTokens returns the estimated size of messages, e.g. a generated
+// context, which can exceed the budget when the latest user message alone
+// does.
+func CountTokens(messages []types.Message) int {
+	total := 0
+	for i := range messages {
+		total += messageTokens(&messages[i])
+	}
+	return total
+}
+
+// ContextNearlyFull reports whether the context has reached ratio of the
+// token budget, along with its estimated size. crossed is true only for the
+// first check at or above ratio since the context was last below it (e.g.
+// before /clear), so a warning based on it isn't repeated every turn.
+func (c *Conversation) ContextNearlyFull(ratio float64) (tokens int, crossed bool) {
+	tokens = c.ContextTokens()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	full := ratio > 0 && float64(tokens) >= ratio*float64(c.maxTokens)
+	crossed = full && !c.nearlyFull
+	c.nearlyFull = full
+	return tokens, crossed
+}
+
+// LastAssistantMessage returns the most recent assistant message, if any.
+func (c *Conversation) LastAssistantMessage() (types.Message, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for i := len(c.fullHistory) - 1; i >= 0; i-- {
+		if c.fullHistory[i].Role == "assistant" {
+			return c.fullHistory[i], true
+		}
+	}
+	return types.Message{}, false
+}
+
+// LastUserMessage returns the most recent user message, if any.
+func (c *Conversation) LastUserMessage() (types.Message, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for i := len(c.fullHistory) - 1; i >= 0; i-- {
+		if c.fullHistory[i].Role == "user" {
+			return c.fullHistory[i], true
+		}
+	}
+	return types.Message{}, false
+}
+
+// SetSystemReminder enables reinjecting a system reminder every interval user
+// turns, so long conversations keep the model on-task. An empty text reuses
+// the system prompt. An interval of 0 disables the reminder.
+func (c *Conversation) SetSystemReminder(interval int, text string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reminderInterval = interval
+	c.reminderText = strings.TrimSpace(text)
+}
+
+// reminderPosition returns the fullHistory index of the user message the
+// system reminder should precede, along with the reminder message itself.
+// The reminder sits before the most recent user turn whose ordinal is a
+// multiple of the interval, so it moves forward every interval turns.
+// Returns -1 when no reminder applies. It is called on a snapshot, so it
+// takes no lock.
+func (c *Conversation) reminderPosition() (int, types.Message) {
+	text := c.reminderText
+	if text == "" && c.systemPrompt != nil {
+		text = c.systemPrompt.Content
+	}
+	if c.reminderInterval <= 0 || text == "" {
+		return -1, types.Message{}
+	}
+
+	userTurns := 0
+	position := -1
+	for i, msg := range c.fullHistory {
+		if msg.Role != "user" {
+			continue
+		}
+		userTurns++
+		if userTurns%c.reminderInterval == 0 {
+			position = i
+		}
+	}
+	if position < 0 {
+		return -1, types.Message{}
+	}
+	return position, types.Message{Role: "system", Content: text, Timestamp: c.fullHistory[position].Timestamp}
+}
+
+// EditAt replaces the content of the message at index in the full history,
+// updating its cached token count.
+func (c *Conversation) EditAt(index int, content string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if index < 0 || index >= len(c.fullHistory) {
+		return fmt.Errorf("message index %d out of range (history has %d messages)", index, len(c.fullHistory))
+	}
+	c.fullHistory[index].Content = content
+	c.fullHistory[index].TokenCount = EstimateTokens(content)
+	return nil
+}
+
+// RollbackLastUserMessage removes the final message if it is a user message
+// with no reply, e.g. after a cancelled turn. Returns true if a message was removed.
+func (c *Conversation) RollbackLastUserMessage() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	last := len(c.fullHistory) - 1
+	if last < 0 || c.fullHistory[last].Role != "user" {
+		return false
+	}
+	c.fullHistory = c.fullHistory[:last]
+	return true
+}
+
+// PopLastTurn removes the most recent user message and everything after it
+// (the reply, including any tool rounds), returning the user's text so the
+// turn can be sent again. Returns false if there is no user message.
+func (c *Conversation) PopLastTurn() (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := len(c.fullHistory) - 1; i >= 0; i-- {
+		if c.fullHistory[i].Role == "user" {
+			content := c.fullHistory[i].Content
+			c.fullHistory = c.fullHistory[:i]
+			return content, true
+		}
+	}
+	return "", false
+}
+
+// Branch returns a deep copy of the history and system prompt, which
+// Checkout can restore later.
+func (c *Conversation) Branch() types.Branch {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	branch := types.Branch{History: copyMessages(c.fullHistory), Created: time.Now()}
+	if c.systemPrompt != nil {
+		msg := *c.systemPrompt
+		branch.SystemPrompt = &msg
+	}
+	return branch
+}
+
+// Checkout replaces the history and system prompt with a copy of branch's,
+// leaving branch itself untouched so it can be checked out again.
+func (c *Conversation) Checkout(branch types.Branch) {
+	var systemPrompt *types.Message
+	if branch.SystemPrompt != nil {
+		msg := *branch.SystemPrompt
+		systemPrompt = &msg
+	}
+	history := copyMessages(branch.History)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.systemPrompt = systemPrompt
+	c.fullHistory = history
+}
+
+// copyMessages copies messages, including the tool calls each refers to.
+func copyMessages(messages []types.Message) []types.Message {
+	copied := make([]types.Message, len(messages))
+	for i, msg := range messages {
+		msg.ToolCalls = append([]types.ToolCall(nil), msg.ToolCalls...)
+		copied[i] = msg
+	}
+	return copied
+}
+
+// AddUsage records token usage reported by the API for a request.
+func (c *Conversation) AddUsage(usage types.UsageInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastUsage = &usage
+	c.totalUsage.PromptTokens += usage.PromptTokens
+	c.totalUsage.CompletionTokens += usage.CompletionTokens
+	c.totalUsage.TotalTokens += usage.TotalTokens
+}
+
+// Usage returns the usage of the most recent request (nil if the provider
+// never reported any) and the cumulative session total.
+func (c *Conversation) Usage() (*types.UsageInfo, types.UsageInfo) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.lastUsage, c.totalUsage
+}
+
+// Reset clears the conversation history and token usage accounting while
+// keeping the configured system prompt.
+func (c *Conversation) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.fullHistory = []types.Message{}
+	c.lastUsage = nil
+	c.totalUsage = types.UsageInfo{}
+}
+
+// GetSystemPrompt returns the current system prompt text, or "" if none is set.
+func (c *Conversation) GetSystemPrompt() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.systemPrompt == nil {
+		return ""
+	}
+	return c.systemPrompt.Content
+}
+
+// SetSystemPrompt replaces the system prompt; an empty text removes it. The
+// context strategy re-validates the new prompt (e.g. that it fits within
+// maxTokens), and on failure the previous prompt is kept.
+func (c *Conversation) SetSystemPrompt(text string) error {
+	systemPrompt := newSystemMessage(text)
+	candidate := c.snapshot()
+	candidate.systemPrompt = systemPrompt
+	if _, _, err := c.strategy.Generate(candidate); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.systemPrompt = systemPrompt
+	return nil
+}
@@ -0,0 +1,308 @@
+package conversation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/henryhwang/chatbot/internal/types"
+)
+
+// withTurns returns conv after adding turns question/answer pairs.
+func withTurns(conv *Conversation, turns int) *Conversation {
+	for i := 1; i <= turns; i++ {
+		conv.AddMessage("user", fmt.Sprintf("question %d", i))
+		conv.AddMessage("assistant", fmt.Sprintf("answer %d", i))
+	}
+	return conv
+}
+
+// testStrategies returns a fresh instance of every strategy, by name.
+func testStrategies() map[string]ContextGenerationStrategy {
+	return map[string]ContextGenerationStrategy{
+		"simple":      &SimpleTruncationStrategy{},
+		"turn-window": &TurnWindowStrategy{Turns: 100},
+		"relevance":   &RelevanceStrategy{},
+		"summarize": &SummarizationStrategy{Summarize: func(context.Context, []types.Message) (string, error) {
+			return "summary", nil
+		}},
+	}
+}
+
+func TestSystemReminderCadence(t *testing.T) {
+	tests := []struct {
+		turns    int
+		interval int
+		before   string // User message the reminder should precede ("" for none)
+	}{
+		{turns: 1, interval: 2, before: ""},
+		{turns: 2, interval: 2, before: "question 2"},
+		{turns: 3, interval: 2, before: "question 2"},
+		{turns: 4, interval: 2, before: "question 4"},
+		{turns: 5, interval: 3, before: "question 3"},
+		{turns: 3, interval: 0, before: ""},
+	}
+	for name, strategy := range testStrategies() {
+		for _, tt := range tests {
+			t.Run(fmt.Sprintf("%s/%d turns every %d", name, tt.turns, tt.interval), func(t *testing.T) {
+				conv := withTurns(NewConversation("Be terse.", strategy, 10000), tt.turns)
+				conv.SetSystemReminder(tt.interval, "Remember: be terse.")
+				context, err := conv.GetContext()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				reminders, before := 0, ""
+				for i, msg := range context {
+					if msg.Role == "system" && msg.Content == "Remember: be terse." {
+						reminders++
+						if i+1 < len(context) {
+							before = context[i+1].Content
+						}
+					}
+				}
+				if tt.before == "" {
+					if reminders != 0 {
+						t.Errorf("got %d reminders, want none", reminders)
+					}
+					return
+				}
+				if reminders != 1 || before != tt.before {
+					t.Errorf("got %d reminder(s) before %q, want one before %q", reminders, before, tt.before)
+				}
+				if context[0].Content != "Be terse." {
+					t.Errorf("system prompt not first: %q", context[0].Content)
+				}
+			})
+		}
+	}
+}
+
+func TestSystemReminderFitsBudget(t *testing.T) {
+	for name, strategy := range testStrategies() {
+		t.Run(name, func(t *testing.T) {
+			conv := withTurns(NewConversation("", strategy, 60), 10)
+			conv.SetSystemReminder(1, "Remember the rules.")
+			context, err := conv.GetContext()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tokens := CountTokens(context); tokens > 60 {
+				t.Errorf("context with reminder is %d tokens, over the budget of 60", tokens)
+			}
+			if last := context[len(context)-3]; last.Content != "Remember the rules." {
+				t.Errorf("reminder missing before the latest turn, got %q", last.Content)
+			}
+		})
+	}
+}
+
+func TestTokenCountCache(t *testing.T) {
+	const sentinel = 1000 // Far from any estimate, so a recount would show
+	tests := []struct {
+		name   string
+		change func(conv *Conversation) error
+		want   int // Tokens of the first message afterwards (-1 for a fresh estimate)
+	}{
+		{"reused while unchanged", func(*Conversation) error { return nil }, sentinel},
+		{"reused after other messages change", func(conv *Conversation) error { return conv.EditAt(1, "changed") }, sentinel},
+		{"recomputed after EditAt", func(conv *Conversation) error { return conv.EditAt(0, "an edited question") }, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conv := withTurns(NewConversation("", &SimpleTruncationStrategy{}, 100000), 2)
+			if got, want := conv.fullHistory[0].TokenCount, EstimateTokens("question 1"); got != want {
+				t.Fatalf("token count cached on add is %d, want %d", got, want)
+			}
+			conv.fullHistory[0].TokenCount = sentinel
+			if err := tt.change(conv); err != nil {
+				t.Fatal(err)
+			}
+
+			want := tt.want
+			if want == -1 {
+				want = EstimateTokens(conv.fullHistory[0].Content)
+			}
+			context, err := conv.GetContext()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := messageTokens(&context[0]); got != want {
+				t.Errorf("first message counts %d tokens, want %d", got, want)
+			}
+			if total, others := conv.ContextTokens(), CountTokens(context[1:]); total != want+others {
+				t.Errorf("ContextTokens() = %d, want %d", total, want+others)
+			}
+		})
+	}
+}
+
+func TestMaxHistoryMessages(t *testing.T) {
+	tests := []struct {
+		name    string
+		limit   int
+		history []string // role:content, added in order
+		want    []string
+	}{
+		{
+			name:    "no cap",
+			limit:   0,
+			history: []string{"user:q1", "assistant:a1", "user:q2", "assistant:a2"},
+			want:    []string{"user:q1", "assistant:a1", "user:q2", "assistant:a2"},
+		},
+		{
+			name:    "whole turns pruned",
+			limit:   2,
+			history: []string{"user:q1", "assistant:a1", "user:q2", "assistant:a2"},
+			want:    []string{"user:q2", "assistant:a2"},
+		},
+		{
+			name:    "orphaned reply pruned with its question",
+			limit:   3,
+			history: []string{"user:q1", "assistant:a1", "user:q2", "assistant:a2"},
+			want:    []string{"user:q2", "assistant:a2"},
+		},
+		{
+			name:    "tool results pruned with their turn",
+			limit:   4,
+			history: []string{"user:q1", "assistant:", "tool:12:00", "assistant:noon", "user:q2"},
+			want:    []string{"user:q2"},
+		},
+		{
+			name:    "newest message always kept",
+			limit:   1,
+			history: []string{"user:q1", "assistant:a1"},
+			want:    []string{"assistant:a1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conv := NewConversation("Be terse.", &SimpleTruncationStrategy{}, 10000)
+			conv.SetMaxHistoryMessages(tt.limit)
+			for _, entry := range tt.history {
+				role, content, _ := strings.Cut(entry, ":")
+				conv.AddMessage(role, content)
+				if tt.limit > 0 && len(conv.GetFullHistory()) > tt.limit {
+					t.Fatalf("history grew to %d messages, over the cap of %d", len(conv.GetFullHistory()), tt.limit)
+				}
+			}
+
+			var got []string
+			for _, msg := range conv.GetFullHistory() {
+				got = append(got, msg.Role+":"+msg.Content)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("history %q, want %q", got, tt.want)
+			}
+			context, err := conv.GetContext()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if context[0].Role != "system" || context[0].Content != "Be terse." {
+				t.Errorf("system prompt was pruned: %+v", context[0])
+			}
+		})
+	}
+}
+
+// Run with -race: history is added to by the chat loop while other
+// goroutines (e.g. the HTTP server or a background summary) read it.
+func TestConcurrentAccess(t *testing.T) {
+	conv := NewConversation("system", &SimpleTruncationStrategy{}, 2000)
+	conv.SetMaxHistoryMessages(50)
+
+	const writers, readers, rounds = 4, 4, 100
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				conv.AddMessage("user", fmt.Sprintf("question %d from %d", i, w))
+				conv.AddMessage("assistant", "answer")
+			}
+		}()
+	}
+	for r := 0; r < readers; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				if _, err := conv.GetContext(); err != nil {
+					t.Error(err)
+					return
+				}
+				conv.GetFullHistory()
+				conv.ContextTokens()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := len(conv.GetFullHistory()); got > 50 {
+		t.Errorf("history has %d messages, limit is 50", got)
+	}
+}
+
+func TestOversizedLatestUserMessage(t *testing.T) {
+	huge := strings.Repeat("lorem ipsum dolor sit amet ", 200)
+	tests := []struct {
+		name     string
+		strategy *SimpleTruncationStrategy
+		latest   string // Role of the oversized message
+		wantLast bool   // Whether it is sent
+	}{
+		{name: "user message", strategy: &SimpleTruncationStrategy{}, latest: "user", wantLast: true},
+		{name: "system prompt excluded", strategy: &SimpleTruncationStrategy{ExcludeSystemPrompt: true}, latest: "user", wantLast: true},
+		{name: "assistant message", strategy: &SimpleTruncationStrategy{}, latest: "assistant"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conv := withTurns(NewConversation("system", tt.strategy, 200), 3)
+			conv.AddMessage(tt.latest, huge)
+
+			context, omitted, err := conv.GetContextWithOmitted()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if context[0].Role != "system" {
+				t.Errorf("context starts with %q, want the system prompt", context[0].Role)
+			}
+			if !tt.wantLast {
+				if len(context) != 1 || omitted != 7 {
+					t.Errorf("got %d messages with %d omitted, want only the system prompt", len(context), omitted)
+				}
+				return
+			}
+			if len(context) != 2 || context[1].Content != huge {
+				t.Fatalf("context %+v, want the system prompt and the oversized message alone", context)
+			}
+			if omitted != 6 {
+				t.Errorf("%d omitted, want the 6 older messages", omitted)
+			}
+			if tokens := CountTokens(context); tokens <= conv.MaxTokens() {
+				t.Errorf("context is ~%d tokens, want it reported over the budget of %d", tokens, conv.MaxTokens())
+			}
+		})
+	}
+}
+
+func TestSystemPromptTooLarge(t *testing.T) {
+	prompt := strings.Repeat("always answer in great detail ", 100)
+	for name, strategy := range testStrategies() {
+		t.Run(name, func(t *testing.T) {
+			conv := withTurns(NewConversation(prompt, strategy, 100), 1)
+			conv.AddMessage("user", "hi")
+			_, err := conv.GetContext()
+			if !errors.Is(err, ErrSystemPromptTooLarge) {
+				t.Fatalf("got %v, want ErrSystemPromptTooLarge", err)
+			}
+			if !strings.Contains(err.Error(), "of 100 tokens") {
+				t.Errorf("error %q does not give the budget", err)
+			}
+		})
+	}
+}
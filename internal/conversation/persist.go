@@ -0,0 +1,57 @@
+package conversation
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/henryhwang/chatbot/internal/types"
+)
+
+// savedMessage is the on-disk form of a message. Unlike the API form it
+// keeps the timestamp.
+type savedMessage struct {
+	Role      string    `json:"role"`
+	Content   string    `json:"content"`
+	Reasoning string    `json:"reasoning,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+
+	ToolCalls  []types.ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+}
+
+// MarshalHistory serializes the full history (role, content, any kept
+// reasoning and timestamp of each message) as indented JSON.
+func (c *Conversation) MarshalHistory() ([]byte, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	saved := make([]savedMessage, len(c.fullHistory))
+	for i, msg := range c.fullHistory {
+		saved[i] = savedMessage{Role: msg.Role, Content: msg.Content, Reasoning: msg.Reasoning, Timestamp: msg.Timestamp, ToolCalls: msg.ToolCalls, ToolCallID: msg.ToolCallID}
+	}
+	return json.MarshalIndent(saved, "", "  ")
+}
+
+// UnmarshalHistory replaces the history with messages serialized by
+// MarshalHistory. On error the current history is left untouched.
+func (c *Conversation) UnmarshalHistory(data []byte) error {
+	var saved []savedMessage
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return fmt.Errorf("invalid history JSON: %w", err)
+	}
+	history := make([]types.Message, len(saved))
+	for i, msg := range saved {
+		switch msg.Role {
+		case "user", "assistant", "system", "tool":
+		default:
+			return fmt.Errorf("message %d has unknown role '%s'", i+1, msg.Role)
+		}
+		history[i] = types.Message{Role: msg.Role, Content: msg.Content, Reasoning: msg.Reasoning, Timestamp: msg.Timestamp, ToolCalls: msg.ToolCalls, ToolCallID: msg.ToolCallID}
+		history[i].TokenCount = EstimateTokens(msg.Content)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.fullHistory = history
+	c.pruneHistory()
+	return nil
+}
@@ -0,0 +1,148 @@
+package conversation
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/henryhwang/chatbot/internal/types"
+)
+
+// defaultRecentMessages is the size of the verbatim recent window used when
+// RelevanceStrategy.RecentMessages is not set.
+const defaultRecentMessages = 4
+
+// RelevanceStrategy keeps the most recent messages verbatim and, when older
+// messages don't all fit in the budget, fills the remaining space with the
+// older messages most relevant to the latest user message (by keyword overlap)
+// instead of strictly dropping oldest-first.
+type RelevanceStrategy struct {
+	RecentMessages int // Number of most recent messages always kept when they fit
+}
+
+func (s *RelevanceStrategy) Generate(conversation *Conversation) ([]types.Message, int, error) {
+	fullHistory := conversation.fullHistory
+	systemPrompt := conversation.systemPrompt
+	maxTokens := conversation.maxTokens
+	currentTokens := 0
+
+	if systemPrompt != nil {
+		systemTokens := messageTokens(systemPrompt)
+		if systemTokens > maxTokens {
+			return nil, 0, systemPromptTooLarge(systemTokens, maxTokens)
+		}
+		currentTokens += systemTokens
+	}
+
+	recentLimit := s.RecentMessages
+	if recentLimit <= 0 {
+		recentLimit = defaultRecentMessages
+	}
+
+	// Take the recent window, newest first, while it fits
+	keep := make([]bool, len(fullHistory))
+	windowStart := len(fullHistory)
+	for i := len(fullHistory) - 1; i >= 0 && len(fullHistory)-i <= recentLimit; i-- {
+		tokens := messageTokens(&fullHistory[i])
+		if currentTokens+tokens > maxTokens {
+			break
+		}
+		keep[i] = true
+		currentTokens += tokens
+		windowStart = i
+	}
+
+	// Score the older messages against the latest user message
+	queryWords := keywords(latestUserContent(fullHistory))
+	type candidate struct {
+		index int
+		score int
+	}
+	candidates := []candidate{}
+	for i := 0; i < windowStart; i++ {
+		if score := overlapScore(queryWords, fullHistory[i].Content); score > 0 {
+			candidates = append(candidates, candidate{index: i, score: score})
+		}
+	}
+	// Highest score first; prefer newer messages on ties
+	sort.SliceStable(candidates, func(a, b int) bool {
+		if candidates[a].score != candidates[b].score {
+			return candidates[a].score > candidates[b].score
+		}
+		return candidates[a].index > candidates[b].index
+	})
+
+	for _, c := range candidates {
+		tokens := messageTokens(&fullHistory[c.index])
+		if currentTokens+tokens <= maxTokens {
+			keep[c.index] = true
+			currentTokens += tokens
+		}
+	}
+
+	// Fill any remaining budget with the newest older messages, as SimpleTruncationStrategy would
+	for i := windowStart - 1; i >= 0; i-- {
+		if keep[i] {
+			continue
+		}
+		tokens := messageTokens(&fullHistory[i])
+		if currentTokens+tokens > maxTokens {
+			break
+		}
+		keep[i] = true
+		currentTokens += tokens
+	}
+
+	finalContext := []types.Message{}
+	if systemPrompt != nil {
+		finalContext = append(finalContext, *systemPrompt)
+	}
+	omitted := 0
+	for i, msg := range fullHistory {
+		if keep[i] {
+			finalContext = append(finalContext, msg)
+		} else {
+			omitted++
+		}
+	}
+
+	return finalContext, omitted, nil
+}
+
+// latestUserContent returns the content of the most recent user message.
+func latestUserContent(history []types.Message) string {
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Role == "user" {
+			return history[i].Content
+		}
+	}
+	return ""
+}
+
+// keywords splits text into a set of lowercase words, ignoring very short ones.
+func keywords(text string) map[string]bool {
+	words := make(map[string]bool)
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	})
+	for _, word := range fields {
+		if len(word) >= 3 {
+			words[word] = true
+		}
+	}
+	return words
+}
+
+// overlapScore counts how many of the query keywords appear in text.
+func overlapScore(queryWords map[string]bool, text string) int {
+	if len(queryWords) == 0 {
+		return 0
+	}
+	score := 0
+	for word := range keywords(text) {
+		if queryWords[word] {
+			score++
+		}
+	}
+	return score
+}
@@ -0,0 +1,79 @@
+package conversation
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/henryhwang/chatbot/internal/types"
+)
+
+func TestRelevanceStrategyKeepsRelevantOldMessages(t *testing.T) {
+	spec := "Spec: the widget service listens on port 8443 behind the gateway."
+	tests := []struct {
+		name    string
+		history []types.Message
+		keep    []string // Older messages that must survive
+		drop    []string // Older messages that must be left out
+	}{
+		{
+			name: "relevant old message beats irrelevant newer ones",
+			history: []types.Message{
+				{Role: "user", Content: spec},
+				{Role: "assistant", Content: "Noted, I will remember that detail."},
+				{Role: "user", Content: "Tell me a joke about cats and dogs."},
+				{Role: "assistant", Content: "Why did the cat sit on the computer?"},
+				{Role: "user", Content: "Which port does the widget service use?"},
+			},
+			keep: []string{spec},
+			drop: []string{"Noted, I will remember that detail.", "Tell me a joke about cats and dogs."},
+		},
+		{
+			name: "without overlap the newest older messages are kept",
+			history: []types.Message{
+				{Role: "user", Content: spec},
+				{Role: "assistant", Content: "Noted, I will remember that detail."},
+				{Role: "user", Content: "Tell me a joke about cats and dogs."},
+				{Role: "assistant", Content: "Why did the cat sit on the computer?"},
+				{Role: "user", Content: "Something unrelated entirely, please."},
+			},
+			keep: []string{"Tell me a joke about cats and dogs."},
+			drop: []string{spec},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strategy := &RelevanceStrategy{RecentMessages: 2}
+			// Room for the recent window plus one older message of the spec's size
+			budget := messageTokens(&tt.history[0])
+			for i := len(tt.history) - 2; i < len(tt.history); i++ {
+				budget += messageTokens(&tt.history[i])
+			}
+			conv := NewConversation("", strategy, budget)
+			for _, msg := range tt.history {
+				conv.AddMessage(msg.Role, msg.Content)
+			}
+
+			context, omitted, err := conv.GetContextWithOmitted()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var contents []string
+			for _, msg := range context {
+				contents = append(contents, msg.Content)
+			}
+			if omitted == 0 {
+				t.Fatalf("nothing was omitted; the budget of %d does not exercise the strategy", budget)
+			}
+			for _, want := range append(tt.keep, tt.history[len(tt.history)-2].Content, tt.history[len(tt.history)-1].Content) {
+				if !slices.Contains(contents, want) {
+					t.Errorf("%q was dropped; context is %q", want, contents)
+				}
+			}
+			for _, unwanted := range tt.drop {
+				if slices.Contains(contents, unwanted) {
+					t.Errorf("%q was kept; context is %q", unwanted, contents)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,25 @@
+package conversation
+
+import (
+	"fmt"
+)
+
+// NewStrategy returns the context generation strategy with the given name:
+// "simple" (default), "turn-window", "relevance" or "summarize". contextTurns
+// configures the turn-window size and excludeSystemPrompt whether "simple"
+// leaves the system prompt out of the token budget; summarizer is used by
+// "summarize".
+func NewStrategy(name string, contextTurns int, excludeSystemPrompt bool, summarizer Summarizer) (ContextGenerationStrategy, error) {
+	switch name {
+	case "", "simple":
+		return &SimpleTruncationStrategy{ExcludeSystemPrompt: excludeSystemPrompt}, nil
+	case "turn-window":
+		return &TurnWindowStrategy{Turns: contextTurns}, nil
+	case "relevance":
+		return &RelevanceStrategy{}, nil
+	case "summarize":
+		return &SummarizationStrategy{Summarize: summarizer}, nil
+	default:
+		return nil, fmt.Errorf("unknown truncation strategy '%s' (expected simple, turn-window, relevance or summarize)", name)
+	}
+}
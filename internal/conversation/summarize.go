@@ -0,0 +1,155 @@
+package conversation
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/henryhwang/chatbot/internal/types"
+)
+
+// Summarizer condenses messages into a short summary, typically by asking the
+// LLM; ctx is the turn's, so cancelling the turn cancels the summary too.
+type Summarizer func(ctx context.Context, messages []types.Message) (string, error)
+
+// defaultSummarizeThreshold is used when SummarizationStrategy.Threshold is not set.
+const defaultSummarizeThreshold = 6
+
+// SummarizationStrategy keeps recent messages verbatim and, once the history
+// no longer fits in maxTokens, replaces the older messages with a summary
+// produced by Summarize. The summary is cached and only regenerated once at
+// least Threshold further messages have fallen out of the recent window.
+// Summarizing happens only for a request about to be sent (GenerateForRequest);
+// other reads of the context (e.g. token counts) reuse the cached summary.
+type SummarizationStrategy struct {
+	Summarize Summarizer
+	Threshold int // Minimum number of newly-old messages before re-summarizing
+
+	mu          sync.Mutex // Guards the cached summary; conversations may generate their context concurrently
+	summary     string     // Cached summary of fullHistory[:covered]
+	covered     int        // Number of leading history messages the summary covers
+	coveredLast time.Time  // Timestamp of the last covered message, to detect history resets
+}
+
+func (s *SummarizationStrategy) Generate(conversation *Conversation) ([]types.Message, int, error) {
+	return s.generate(context.Background(), conversation, false)
+}
+
+// GenerateForRequest is Generate, summarizing older messages first if due.
+func (s *SummarizationStrategy) GenerateForRequest(ctx context.Context, conversation *Conversation) ([]types.Message, int, error) {
+	return s.generate(ctx, conversation, true)
+}
+
+// generate builds the context from the cached summary, refreshing it first
+// when summarize is set and enough messages have fallen out of the window.
+func (s *SummarizationStrategy) generate(ctx context.Context, conversation *Conversation, summarize bool) ([]types.Message, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fullHistory := conversation.fullHistory
+	systemPrompt := conversation.systemPrompt
+	maxTokens := conversation.maxTokens
+	currentTokens := 0
+
+	if systemPrompt != nil {
+		systemTokens := messageTokens(systemPrompt)
+		if systemTokens > maxTokens {
+			return nil, 0, systemPromptTooLarge(systemTokens, maxTokens)
+		}
+		currentTokens += systemTokens
+	}
+
+	// Drop the cached summary if the history it covers has changed (e.g. /clear)
+	if s.covered > len(fullHistory) || (s.covered > 0 && !fullHistory[s.covered-1].Timestamp.Equal(s.coveredLast)) {
+		s.summary, s.covered = "", 0
+	}
+
+	historyTokens := 0
+	for i := range fullHistory {
+		historyTokens += messageTokens(&fullHistory[i])
+	}
+	if s.covered == 0 && currentTokens+historyTokens <= maxTokens {
+		// Everything fits, no summary needed
+		return conversation.withSystemPrompt(fullHistory), 0, nil
+	}
+
+	// Recent window gets three quarters of the remaining budget; the rest is for the summary
+	recentBudget := (maxTokens - currentTokens) * 3 / 4
+	windowStart := len(fullHistory)
+	recentTokens := 0
+	for i := len(fullHistory) - 1; i >= 0; i-- {
+		tokens := messageTokens(&fullHistory[i])
+		if recentTokens+tokens > recentBudget {
+			break
+		}
+		recentTokens += tokens
+		windowStart = i
+	}
+
+	threshold := s.Threshold
+	if threshold <= 0 {
+		threshold = defaultSummarizeThreshold
+	}
+	if summarize && windowStart > s.covered && (s.covered == 0 || windowStart-s.covered >= threshold) {
+		if err := s.resummarize(ctx, fullHistory, windowStart); err != nil {
+			if ctx.Err() != nil {
+				return nil, 0, err // The turn was cancelled while summarizing
+			}
+			log.Printf("Warning: Failed to summarize older messages, dropping them instead: %v", err)
+		}
+	}
+
+	// Messages not covered by the summary are kept newest-first while they fit
+	remaining := []types.Message{}
+	if s.summary != "" {
+		currentTokens += EstimateTokens(s.summary)
+	}
+	for i := len(fullHistory) - 1; i >= s.covered; i-- {
+		tokens := messageTokens(&fullHistory[i])
+		if currentTokens+tokens > maxTokens {
+			break
+		}
+		currentTokens += tokens
+		remaining = append([]types.Message{fullHistory[i]}, remaining...)
+	}
+
+	// Messages covered by the summary are represented, so only the others count as omitted
+	omitted := len(fullHistory) - s.covered - len(remaining)
+	if s.summary != "" {
+		note := types.Message{Role: "system", Content: "Summary of the earlier conversation: " + s.summary, Timestamp: s.coveredLast}
+		remaining = append([]types.Message{note}, remaining...)
+	}
+	return conversation.withSystemPrompt(remaining), omitted, nil
+}
+
+// resummarize folds the previous summary and the messages up to windowStart
+// into a new cached summary. On failure the previous summary is kept and the
+// strategy degrades to dropping the oldest messages.
+func (s *SummarizationStrategy) resummarize(ctx context.Context, fullHistory []types.Message, windowStart int) error {
+	if s.Summarize == nil {
+		return nil
+	}
+	toSummarize := []types.Message{}
+	if s.summary != "" {
+		toSummarize = append(toSummarize, types.Message{Role: "system", Content: "Summary so far: " + s.summary})
+	}
+	toSummarize = append(toSummarize, fullHistory[s.covered:windowStart]...)
+
+	summary, err := s.Summarize(ctx, toSummarize)
+	if err != nil {
+		return err
+	}
+	s.summary = summary
+	s.covered = windowStart
+	s.coveredLast = fullHistory[windowStart-1].Timestamp
+	return nil
+}
+
+// withSystemPrompt prepends the system prompt (if any) to messages.
+func (c *Conversation) withSystemPrompt(messages []types.Message) []types.Message {
+	finalContext := []types.Message{}
+	if c.systemPrompt != nil {
+		finalContext = append(finalContext, *c.systemPrompt)
+	}
+	return append(finalContext, messages...)
+}
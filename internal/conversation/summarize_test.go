@@ -0,0 +1,86 @@
+package conversation
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/henryhwang/chatbot/internal/types"
+)
+
+// countingSummarizer returns a summarizer that counts its calls and fails
+// with ctx's error once ctx is cancelled.
+func countingSummarizer(calls *int) Summarizer {
+	return func(ctx context.Context, messages []types.Message) (string, error) {
+		*calls++
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		return "summary", nil
+	}
+}
+
+func TestSummarizeOnlyForRequests(t *testing.T) {
+	calls := 0
+	conv := withTurns(NewConversation("Be terse.", &SummarizationStrategy{Summarize: countingSummarizer(&calls)}, 80), 10)
+
+	// Incidental reads of the context must not summarize
+	reads := map[string]func(){
+		"GetContext":        func() { conv.GetContext() },
+		"ContextTokens":     func() { conv.ContextTokens() },
+		"ContextNearlyFull": func() { conv.ContextNearlyFull(0.5) },
+		"SetSystemPrompt":   func() { conv.SetSystemPrompt("Be brief.") },
+	}
+	for name, read := range reads {
+		read()
+		if calls != 0 {
+			t.Fatalf("%s summarized (%d calls)", name, calls)
+		}
+	}
+
+	messages, _, err := conv.RequestContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Fatalf("got %d summarizer calls for a request, want 1", calls)
+	}
+	if !hasSummary(messages) {
+		t.Error("request context has no summary")
+	}
+
+	// The cached summary serves later reads and requests
+	messages, _ = conv.GetContext()
+	if !hasSummary(messages) {
+		t.Error("later read doesn't reuse the cached summary")
+	}
+	conv.RequestContext(context.Background())
+	if calls != 1 {
+		t.Errorf("summary regenerated without new old messages (%d calls)", calls)
+	}
+}
+
+func TestSummarizeCancelled(t *testing.T) {
+	calls := 0
+	conv := withTurns(NewConversation("", &SummarizationStrategy{Summarize: countingSummarizer(&calls)}, 80), 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, _, err := conv.RequestContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want context.Canceled", err)
+	}
+	if calls != 1 {
+		t.Errorf("got %d summarizer calls, want 1", calls)
+	}
+}
+
+// hasSummary reports whether messages include the summary note.
+func hasSummary(messages []types.Message) bool {
+	for _, msg := range messages {
+		if msg.Role == "system" && strings.HasPrefix(msg.Content, "Summary of the earlier conversation:") {
+			return true
+		}
+	}
+	return false
+}
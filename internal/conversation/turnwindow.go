@@ -0,0 +1,72 @@
+package conversation
+
+import (
+	"github.com/henryhwang/chatbot/internal/types"
+)
+
+// defaultContextTurns is used when TurnWindowStrategy.Turns is not set.
+const defaultContextTurns = 10
+
+// TurnWindowStrategy keeps the most recent complete turns plus the system
+// prompt. A turn starts at a user message and includes every message up to the
+// next user message, so an assistant reply is never sent without the user
+// message it answers. Whole turns are also dropped if they exceed maxTokens,
+// except the latest.
+type TurnWindowStrategy struct {
+	Turns int // Number of most recent turns to keep
+}
+
+func (s *TurnWindowStrategy) Generate(conversation *Conversation) ([]types.Message, int, error) {
+	fullHistory := conversation.fullHistory
+	systemPrompt := conversation.systemPrompt
+	maxTokens := conversation.maxTokens
+	currentTokens := 0
+
+	if systemPrompt != nil {
+		systemTokens := messageTokens(systemPrompt)
+		if systemTokens > maxTokens {
+			return nil, 0, systemPromptTooLarge(systemTokens, maxTokens)
+		}
+		currentTokens += systemTokens
+	}
+
+	turns := s.Turns
+	if turns <= 0 {
+		turns = defaultContextTurns
+	}
+
+	// Walk back over turn boundaries (user messages), keeping whole turns
+	start := len(fullHistory)
+	end := len(fullHistory)
+	kept := 0
+	for i := len(fullHistory) - 1; i >= 0 && kept < turns; i-- {
+		if fullHistory[i].Role != "user" {
+			continue
+		}
+		turnTokens := 0
+		for j := i; j < end; j++ {
+			turnTokens += messageTokens(&fullHistory[j])
+		}
+		if currentTokens+turnTokens > maxTokens {
+			if kept == 0 {
+				// A request without the user message it is for makes no sense,
+				// so the latest turn is sent on its own even when it is over the
+				// budget (CountTokens on the context tells the caller)
+				start = i
+			}
+			break
+		}
+		currentTokens += turnTokens
+		start = i
+		end = i
+		kept++
+	}
+
+	finalContext := []types.Message{}
+	if systemPrompt != nil {
+		finalContext = append(finalContext, *systemPrompt)
+	}
+	finalContext = append(finalContext, fullHistory[start:]...)
+
+	return finalContext, start, nil // Everything before the first kept turn is omitted
+}
@@ -0,0 +1,96 @@
+package conversation
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/henryhwang/chatbot/internal/types"
+)
+
+func TestTurnWindowStrategy(t *testing.T) {
+	huge := strings.Repeat("lorem ipsum dolor sit amet ", 200)
+	tests := []struct {
+		name        string
+		history     []types.Message
+		turns       int
+		budget      []int // Indices of the messages the budget has room for (nil for plenty)
+		want        []string
+		wantOmitted int
+	}{
+		{
+			name:        "assistant before the first user message is dropped",
+			history:     []types.Message{{Role: "assistant", Content: "orphan"}, {Role: "user", Content: "q1"}, {Role: "assistant", Content: "a1"}, {Role: "user", Content: "q2"}},
+			want:        []string{"q1", "a1", "q2"},
+			wantOmitted: 1,
+		},
+		{
+			name:        "turn limit",
+			history:     []types.Message{{Role: "user", Content: "q1"}, {Role: "assistant", Content: "a1"}, {Role: "user", Content: "q2"}, {Role: "assistant", Content: "a2"}, {Role: "user", Content: "q3"}},
+			turns:       2,
+			want:        []string{"q2", "a2", "q3"},
+			wantOmitted: 2,
+		},
+		{
+			name:        "tool results stay with their turn",
+			history:     []types.Message{{Role: "user", Content: "q1"}, {Role: "assistant", Content: ""}, {Role: "tool", Content: "result"}, {Role: "assistant", Content: "a1"}, {Role: "user", Content: "q2"}},
+			turns:       2,
+			want:        []string{"q1", "", "result", "a1", "q2"},
+			wantOmitted: 0,
+		},
+		{
+			name:        "turn over the budget is dropped whole",
+			history:     []types.Message{{Role: "user", Content: "q1"}, {Role: "assistant", Content: "a1"}, {Role: "user", Content: "q2"}},
+			budget:      []int{1, 2}, // Room for the reply, but not with its question
+			want:        []string{"q2"},
+			wantOmitted: 2,
+		},
+		{
+			name:        "oversized latest turn is sent alone",
+			history:     []types.Message{{Role: "user", Content: "q1"}, {Role: "assistant", Content: "a1"}, {Role: "user", Content: huge}},
+			budget:      []int{0, 1},
+			want:        []string{huge},
+			wantOmitted: 2,
+		},
+		{
+			name:        "oversized latest turn keeps its tool round",
+			history:     []types.Message{{Role: "user", Content: "q1"}, {Role: "user", Content: huge}, {Role: "assistant", Content: ""}, {Role: "tool", Content: "result"}},
+			budget:      []int{0},
+			want:        []string{huge, "", "result"},
+			wantOmitted: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			budget := 10000
+			if tt.budget != nil {
+				budget = 0
+				for _, i := range tt.budget {
+					budget += messageTokens(&tt.history[i])
+				}
+			}
+			conv := NewConversation("", &TurnWindowStrategy{Turns: tt.turns}, budget)
+			for _, msg := range tt.history {
+				conv.AppendMessage(msg)
+			}
+
+			context, omitted, err := conv.GetContextWithOmitted()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var contents []string
+			for _, msg := range context {
+				contents = append(contents, msg.Content)
+			}
+			if !slices.Equal(contents, tt.want) {
+				t.Errorf("context %q, want %q", contents, tt.want)
+			}
+			if omitted != tt.wantOmitted {
+				t.Errorf("%d omitted, want %d", omitted, tt.wantOmitted)
+			}
+			if len(context) > 0 && context[0].Role != "user" {
+				t.Errorf("context starts with a %s message", context[0].Role)
+			}
+		})
+	}
+}
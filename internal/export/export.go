@@ -0,0 +1,71 @@
+package export
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/henryhwang/chatbot/internal/types"
+)
+
+// Layout used for message and export timestamps
+const timeLayout = "2006-01-02 15:04:05"
+
+// Markdown renders messages as a readable markdown transcript with a
+// "**You:**" / "**Bot:**" header per message. Message content is copied
+// verbatim, so code blocks are preserved; kept reasoning goes into a
+// collapsible <details> section.
+func Markdown(messages []types.Message, exportedAt time.Time) string {
+	var out strings.Builder
+	out.WriteString("# Chat Transcript\n\n")
+	fmt.Fprintf(&out, "_Exported %s_\n", exportedAt.Format(timeLayout))
+
+	for _, msg := range messages {
+		out.WriteString("\n---\n\n")
+		fmt.Fprintf(&out, "**%s:**", speaker(msg.Role))
+		if !msg.Timestamp.IsZero() {
+			fmt.Fprintf(&out, " _%s_", msg.Timestamp.Format(timeLayout))
+		}
+		out.WriteString("\n\n")
+		if msg.Reasoning != "" {
+			// Collapsed by default so the transcript stays readable
+			out.WriteString("<details>\n<summary>Reasoning</summary>\n\n")
+			out.WriteString(closeFences(strings.TrimRight(msg.Reasoning, "\n")))
+			out.WriteString("\n\n</details>\n\n")
+		}
+		out.WriteString(closeFences(strings.TrimRight(msg.Content, "\n")))
+		out.WriteString("\n")
+	}
+	return out.String()
+}
+
+// speaker returns the transcript header for a message role.
+func speaker(role string) string {
+	switch role {
+	case "user":
+		return "You"
+	case "assistant":
+		return "Bot"
+	case "system":
+		return "System"
+	case "tool":
+		return "Tool"
+	default:
+		return role
+	}
+}
+
+// closeFences appends a closing fence if content ends inside a code block
+// (e.g. a cancelled response), so it can't swallow the following messages.
+func closeFences(content string) string {
+	open := false
+	for _, line := range strings.Split(content, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			open = !open
+		}
+	}
+	if open {
+		return content + "\n```"
+	}
+	return content
+}
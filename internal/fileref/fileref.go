@@ -0,0 +1,111 @@
+package fileref
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/henryhwang/chatbot/internal/conversation"
+)
+
+// Matches "@path" references at the start of input or after whitespace
+var refPattern = regexp.MustCompile(`(^|\s)@(\S+)`)
+
+// Expand replaces @path references in input with the bare path and appends
+// each referenced file's contents as a fenced block labelled with its name.
+// Glob patterns (e.g. @*.go) expand to every matching file. References that
+// don't exist, or whose files would exceed the remaining token budget, are
+// skipped and reported in the returned warnings.
+func Expand(input string, budget int) (string, []string) {
+	matches := refPattern.FindAllStringSubmatch(input, -1)
+	if len(matches) == 0 {
+		return input, nil
+	}
+
+	warnings := []string{}
+	blocks := []string{}
+	seen := make(map[string]bool)
+	used := conversation.EstimateTokens(input)
+	text := input
+
+	for _, match := range matches {
+		ref := strings.TrimRight(match[2], ".,;:!?)")
+		paths, err := resolve(ref)
+		if err != nil {
+			warnings = append(warnings, err.Error())
+			continue
+		}
+		text = strings.Replace(text, "@"+ref, ref, 1)
+
+		for _, path := range paths {
+			if seen[path] {
+				continue
+			}
+			seen[path] = true
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				warnings = append(warnings, fmt.Sprintf("skipping @%s: %v", path, err))
+				continue
+			}
+			block := Fence(path, string(data))
+			tokens := conversation.EstimateTokens(block)
+			if used+tokens > budget {
+				warnings = append(warnings, fmt.Sprintf("skipping @%s: ~%d tokens would exceed the context budget", path, tokens))
+				continue
+			}
+			used += tokens
+			blocks = append(blocks, block)
+		}
+	}
+
+	if len(blocks) == 0 {
+		return text, warnings
+	}
+	return text + "\n\n" + strings.Join(blocks, "\n\n"), warnings
+}
+
+// Fence wraps a file's contents in a fenced code block labelled with its path.
+func Fence(path, content string) string {
+	return fmt.Sprintf("```%s\n%s\n```", path, strings.TrimRight(content, "\n"))
+}
+
+// How much of a file IsBinary looks at, as git does
+const binarySniffBytes = 8000
+
+// IsBinary reports whether data looks like a binary file rather than text:
+// it has a NUL byte near the start.
+func IsBinary(data []byte) bool {
+	return bytes.IndexByte(data[:min(len(data), binarySniffBytes)], 0) >= 0
+}
+
+// resolve turns a reference into a sorted list of regular files, expanding
+// glob patterns.
+func resolve(ref string) ([]string, error) {
+	candidates := []string{ref}
+	if strings.ContainsAny(ref, "*?[") {
+		globbed, err := filepath.Glob(ref)
+		if err != nil {
+			return nil, fmt.Errorf("skipping @%s: invalid pattern: %v", ref, err)
+		}
+		candidates = globbed
+	}
+
+	files := []string{}
+	for _, path := range candidates {
+		info, err := os.Stat(path)
+		if err != nil || info.IsDir() {
+			continue
+		}
+		files = append(files, path)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("skipping @%s: no such file", ref)
+	}
+	sort.Strings(files)
+	return files, nil
+}
@@ -0,0 +1,97 @@
+package fileref
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExpand(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{"a.go": "package a\n", "b.go": "package b\n", "notes.txt": "notes"}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a, b, notes := filepath.Join(dir, "a.go"), filepath.Join(dir, "b.go"), filepath.Join(dir, "notes.txt")
+	missing := filepath.Join(dir, "missing.go")
+
+	tests := []struct {
+		name     string
+		input    string
+		budget   int
+		want     string
+		warnings []string // Substrings, one per expected warning
+	}{
+		{name: "no references", input: "explain this", budget: 1000, want: "explain this"},
+		{
+			name:   "single",
+			input:  "explain @" + a,
+			budget: 1000,
+			want:   "explain " + a + "\n\n" + Fence(a, "package a"),
+		},
+		{
+			name:   "multiple with trailing punctuation",
+			input:  "compare @" + a + " and @" + notes + ".",
+			budget: 1000,
+			want:   "compare " + a + " and " + notes + ".\n\n" + Fence(a, "package a") + "\n\n" + Fence(notes, "notes"),
+		},
+		{
+			name:   "glob",
+			input:  "review @" + filepath.Join(dir, "*.go"),
+			budget: 1000,
+			want:   "review " + filepath.Join(dir, "*.go") + "\n\n" + Fence(a, "package a") + "\n\n" + Fence(b, "package b"),
+		},
+		{
+			name:   "duplicates inlined once",
+			input:  "@" + a + " @" + filepath.Join(dir, "a.*"),
+			budget: 1000,
+			want:   a + " " + filepath.Join(dir, "a.*") + "\n\n" + Fence(a, "package a"),
+		},
+		{
+			name:     "missing file",
+			input:    "explain @" + missing,
+			budget:   1000,
+			want:     "explain @" + missing,
+			warnings: []string{"no such file"},
+		},
+		{
+			name:     "over budget",
+			input:    "explain @" + a,
+			budget:   10,
+			want:     "explain " + a,
+			warnings: []string{"exceed the context budget"},
+		},
+		{name: "email address is not a reference", input: "mail me@example.com", budget: 1000, want: "mail me@example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, warnings := Expand(tt.input, tt.budget)
+			if got != tt.want {
+				t.Errorf("Expand() = %q, want %q", got, tt.want)
+			}
+			if len(warnings) != len(tt.warnings) {
+				t.Fatalf("warnings %q, want %d", warnings, len(tt.warnings))
+			}
+			for i, want := range tt.warnings {
+				if !strings.Contains(warnings[i], want) {
+					t.Errorf("warning %q does not mention %q", warnings[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestIsBinary(t *testing.T) {
+	if IsBinary([]byte("plain text\n")) {
+		t.Error("text reported as binary")
+	}
+	if !IsBinary([]byte("PNG\x00\x01")) {
+		t.Error("NUL byte not reported as binary")
+	}
+	if IsBinary(append([]byte(strings.Repeat("a", binarySniffBytes)), 0)) {
+		t.Error("NUL byte past the sniffed prefix reported as binary")
+	}
+}
@@ -0,0 +1,76 @@
+package models
+
+import (
+	"strings"
+)
+
+// Limits describes a model's context window and maximum completion length, in tokens.
+type Limits struct {
+	ContextWindow int
+	MaxCompletion int
+}
+
+// Known model limits, matched by longest prefix of the model ID so dated
+// variants (e.g. "gpt-4o-2024-08-06") and tags (e.g. "llama3.1:8b") resolve.
+var knownLimits = map[string]Limits{
+	// OpenAI
+	"gpt-4o":        {ContextWindow: 128000, MaxCompletion: 16384},
+	"gpt-4o-mini":   {ContextWindow: 128000, MaxCompletion: 16384},
+	"gpt-4.1":       {ContextWindow: 1047576, MaxCompletion: 32768},
+	"gpt-4-turbo":   {ContextWindow: 128000, MaxCompletion: 4096},
+	"gpt-4":         {ContextWindow: 8192, MaxCompletion: 2048},
+	"gpt-3.5-turbo": {ContextWindow: 16385, MaxCompletion: 4096},
+	"o1":            {ContextWindow: 200000, MaxCompletion: 100000},
+	"o3":            {ContextWindow: 200000, MaxCompletion: 100000},
+	"o4-mini":       {ContextWindow: 200000, MaxCompletion: 100000},
+
+	// DeepSeek
+	"deepseek-chat":     {ContextWindow: 64000, MaxCompletion: 8192},
+	"deepseek-reasoner": {ContextWindow: 64000, MaxCompletion: 8192},
+
+	// Anthropic
+	"claude-3":          {ContextWindow: 200000, MaxCompletion: 4096},
+	"claude-3-5-sonnet": {ContextWindow: 200000, MaxCompletion: 8192},
+	"claude-3-7-sonnet": {ContextWindow: 200000, MaxCompletion: 8192},
+	"claude-sonnet-4":   {ContextWindow: 200000, MaxCompletion: 8192},
+	"claude-opus-4":     {ContextWindow: 200000, MaxCompletion: 8192},
+
+	// Open models commonly served via Ollama and friends
+	"llama3":        {ContextWindow: 8192, MaxCompletion: 2048},
+	"llama3.1":      {ContextWindow: 128000, MaxCompletion: 4096},
+	"llama3.2":      {ContextWindow: 128000, MaxCompletion: 4096},
+	"qwen2.5":       {ContextWindow: 32768, MaxCompletion: 8192},
+	"mistral":       {ContextWindow: 32768, MaxCompletion: 4096},
+	"mistral-large": {ContextWindow: 128000, MaxCompletion: 4096},
+	"gemma2":        {ContextWindow: 8192, MaxCompletion: 2048},
+}
+
+// Lookup returns the known limits for a model ID. Gateway prefixes such as
+// "openai/" are ignored and the longest matching known prefix wins.
+func Lookup(model string) (Limits, bool) {
+	id := strings.ToLower(strings.TrimSpace(model))
+	if slash := strings.LastIndex(id, "/"); slash >= 0 {
+		id = id[slash+1:]
+	}
+
+	bestPrefix := ""
+	for prefix := range knownLimits {
+		if strings.HasPrefix(id, prefix) && len(prefix) > len(bestPrefix) {
+			bestPrefix = prefix
+		}
+	}
+	if bestPrefix == "" {
+		return Limits{}, false
+	}
+	return knownLimits[bestPrefix], true
+}
+
+// ContextBudget returns the token budget for conversation context: the model's
+// context window minus headroom for its completion. Unknown models use fallback.
+func ContextBudget(model string, fallback int) int {
+	limits, ok := Lookup(model)
+	if !ok {
+		return fallback
+	}
+	return limits.ContextWindow - limits.MaxCompletion
+}
@@ -0,0 +1,57 @@
+package models
+
+import "testing"
+
+func TestLookup(t *testing.T) {
+	tests := []struct {
+		model  string
+		want   Limits
+		wantOk bool
+	}{
+		{"gpt-4o", Limits{128000, 16384}, true},
+		{"gpt-4o-mini", Limits{128000, 16384}, true},
+		{"gpt-4o-2024-08-06", Limits{128000, 16384}, true},
+		{"gpt-4", Limits{8192, 2048}, true},
+		{"gpt-4-turbo-2024-04-09", Limits{128000, 4096}, true}, // Not plain "gpt-4"
+		{"gpt-4.1-mini", Limits{1047576, 32768}, true},
+		{"openai/gpt-4o-mini", Limits{128000, 16384}, true},
+		{"OpenAI/GPT-4", Limits{8192, 2048}, true},
+		{"claude-3-5-sonnet-20241022", Limits{200000, 8192}, true},
+		{"claude-3-haiku-20240307", Limits{200000, 4096}, true},
+		{"llama3:8b", Limits{8192, 2048}, true},
+		{"llama3.1:8b", Limits{128000, 4096}, true}, // Not "llama3"
+		{"mistral-large-latest", Limits{128000, 4096}, true},
+		{"mistral:7b", Limits{32768, 4096}, true},
+		{" deepseek-chat ", Limits{64000, 8192}, true},
+		{"unknown-model", Limits{}, false},
+		{"", Limits{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := Lookup(tt.model)
+		if got != tt.want || ok != tt.wantOk {
+			t.Errorf("Lookup(%q) = %+v, %v, want %+v, %v", tt.model, got, ok, tt.want, tt.wantOk)
+		}
+	}
+}
+
+func TestContextBudget(t *testing.T) {
+	tests := []struct {
+		model    string
+		fallback int
+		want     int
+	}{
+		{"gpt-4o", 4096, 111616},
+		{"gpt-4o-mini-2024-07-18", 4096, 111616},
+		{"openai/gpt-4", 4096, 6144},
+		{"llama3.1:8b", 4096, 123904},
+		{"llama3:70b", 4096, 6144},
+		{"claude-sonnet-4-20250514", 4096, 191808},
+		{"unknown-model", 4096, 4096},
+		{"my-finetune", 30000, 30000},
+	}
+	for _, tt := range tests {
+		if got := ContextBudget(tt.model, tt.fallback); got != tt.want {
+			t.Errorf("ContextBudget(%q, %d) = %d, want %d", tt.model, tt.fallback, got, tt.want)
+		}
+	}
+}
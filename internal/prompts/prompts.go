@@ -0,0 +1,31 @@
+package prompts
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// Render fills {{.Name}} placeholders in text from vars using text/template.
+// A placeholder without a value is an error naming the variable, rather than
+// being rendered as "<no value>". Text without placeholders is returned as is.
+func Render(text string, vars map[string]string) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+	tmpl, err := template.New("prompt").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid prompt template: %w", err)
+	}
+	if vars == nil {
+		vars = map[string]string{} // A nil map would render missing keys silently
+	}
+	var out strings.Builder
+	if err := tmpl.Execute(&out, vars); err != nil {
+		if _, key, ok := strings.Cut(err.Error(), `map has no entry for key "`); ok {
+			return "", fmt.Errorf("undefined prompt variable '%s'", strings.TrimSuffix(key, `"`))
+		}
+		return "", fmt.Errorf("prompt template: %w", err)
+	}
+	return out.String(), nil
+}
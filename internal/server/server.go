@@ -0,0 +1,280 @@
+// Package server exposes the chatbot over HTTP: prompts are POSTed to /chat
+// and the reply streams back as server-sent events, one conversation per
+// session id.
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/henryhwang/chatbot/chat"
+	"github.com/henryhwang/chatbot/internal/api"
+	"github.com/henryhwang/chatbot/internal/types"
+)
+
+// --- HTTP Server Mode ---
+
+// Largest /chat request body accepted
+const maxRequestBytes = 1 << 20
+
+// How long shutdown waits for streams in progress before closing them
+const shutdownTimeout = 10 * time.Second
+
+// How long a session is kept after its last turn; clients rarely say when
+// they are done, so this is what bounds the number of conversations held
+const sessionIdleTimeout = 30 * time.Minute
+
+// session is one client's conversation. busy is held for the length of a
+// turn, since a conversation takes one turn at a time.
+type session struct {
+	conv     *chat.Conversation
+	busy     sync.Mutex
+	lastUsed time.Time // Guarded by Server.mu
+}
+
+// Server answers /chat and /models for one provider.
+type Server struct {
+	provider        types.ModelProvider
+	settings        types.Settings
+	client          *chat.Client
+	newConversation func() *chat.Conversation
+
+	idleTimeout time.Duration // Sessions unused for longer are removed
+
+	mu       sync.Mutex
+	sessions map[string]*session
+}
+
+// New returns a server for provider. newConversation creates the
+// conversation of each new session (system prompt, context strategy, budget).
+func New(provider types.ModelProvider, settings types.Settings, newConversation func() *chat.Conversation) *Server {
+	return &Server{
+		provider:        provider,
+		settings:        settings,
+		client:          chat.New(provider, settings),
+		newConversation: newConversation,
+		idleTimeout:     sessionIdleTimeout,
+		sessions:        map[string]*session{},
+	}
+}
+
+// Handler returns the server's routes:
+//
+//	POST /chat    {"session_id": "...", "prompt": "..."} -> text/event-stream
+//	GET  /models  the provider's model list, as the provider returns it
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/chat", s.handleChat)
+	mux.HandleFunc("/models", s.handleModels)
+	return mux
+}
+
+// ListenAndServe serves on addr until ctx is cancelled, then shuts down
+// gracefully: new connections are refused and streams in progress get
+// shutdownTimeout to finish before they are cut off.
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	srv := &http.Server{Addr: addr, Handler: s.Handler(), ReadHeaderTimeout: 10 * time.Second}
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- srv.ListenAndServe() }()
+
+	select {
+	case err := <-serveErr:
+		return err // Failed to start, e.g. the address is in use
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		srv.Close() // Cancels the streams still running
+		return fmt.Errorf("shutdown: %w", err)
+	}
+	return nil
+}
+
+// chatRequest is the body of POST /chat. Without a session id, or with one
+// that is unknown or has expired, a new session is started; its id is sent
+// back in the "session" event.
+type chatRequest struct {
+	SessionID string `json:"session_id"`
+	Prompt    string `json:"prompt"`
+}
+
+// handleChat runs one turn of the session's conversation and streams its
+// events. A client that disconnects cancels the turn.
+func (s *Server) handleChat(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		httpError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+	var req chatRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBytes)).Decode(&req); err != nil {
+		httpError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	if strings.TrimSpace(req.Prompt) == "" {
+		httpError(w, http.StatusBadRequest, "prompt is empty")
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		httpError(w, http.StatusInternalServerError, "streaming unsupported")
+		return
+	}
+
+	id, sess := s.session(req.SessionID)
+	if !sess.busy.TryLock() {
+		httpError(w, http.StatusConflict, "session has a turn in progress")
+		return
+	}
+	defer sess.busy.Unlock()
+	defer s.touch(sess) // The idle time counts from the end of the turn
+
+	events, err := s.client.Chat(r.Context(), sess.conv, req.Prompt)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	writeEvent(w, "session", map[string]string{"session_id": id})
+	flusher.Flush()
+	for event := range events { // Drained to the end, so the turn is complete before the session is freed
+		name, data := eventPayload(event)
+		if name == "" {
+			continue
+		}
+		writeEvent(w, name, data)
+		flusher.Flush()
+	}
+}
+
+// session returns the session with id, or a new one with a new id when id
+// is empty, unknown or expired. Sessions idle for longer than idleTimeout
+// are removed first.
+func (s *Server) session(id string) (string, *session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.expireSessions(now)
+	if sess, ok := s.sessions[id]; ok && id != "" {
+		sess.lastUsed = now
+		return id, sess
+	}
+	id = newSessionID()
+	sess := &session{conv: s.newConversation(), lastUsed: now}
+	s.sessions[id] = sess
+	return id, sess
+}
+
+// expireSessions removes the sessions idle since before now - idleTimeout,
+// except those with a turn in progress. s.mu must be held.
+func (s *Server) expireSessions(now time.Time) {
+	for id, sess := range s.sessions {
+		if now.Sub(sess.lastUsed) <= s.idleTimeout || !sess.busy.TryLock() {
+			continue
+		}
+		sess.busy.Unlock()
+		delete(s.sessions, id)
+	}
+}
+
+// touch records that sess was just used.
+func (s *Server) touch(sess *session) {
+	s.mu.Lock()
+	sess.lastUsed = time.Now()
+	s.mu.Unlock()
+}
+
+// newSessionID returns a random 128-bit id in hex.
+func newSessionID() string {
+	b := make([]byte, 16)
+	rand.Read(b) // Never fails on supported platforms
+	return hex.EncodeToString(b)
+}
+
+// eventPayload maps a turn event to an SSE event name and JSON data; a name
+// of "" means the event is not sent.
+func eventPayload(event chat.StreamEvent) (string, any) {
+	switch event := event.(type) {
+	case chat.ReasoningEvent:
+		return "reasoning", map[string]string{"text": event.Text}
+	case chat.ContentEvent:
+		return "content", map[string]string{"text": event.Text}
+	case chat.ToolCallEvent:
+		return "tool_call", map[string]string{"name": event.Call.Function.Name, "arguments": event.Call.Function.Arguments, "output": event.Output}
+	case chat.NoticeEvent:
+		return "notice", map[string]string{"text": event.Text}
+	case chat.WarningEvent:
+		return "warning", map[string]string{"text": event.Text}
+	case chat.DoneEvent:
+		return "done", struct {
+			Content   string           `json:"content"`
+			Reasoning string           `json:"reasoning,omitempty"`
+			Usage     *types.UsageInfo `json:"usage,omitempty"`
+		}{event.Content, event.Reasoning, event.Usage}
+	case chat.ErrorEvent:
+		return "error", map[string]string{"error": event.Err.Error()}
+	}
+	return "", nil // StartEvent: nothing useful to a client
+}
+
+// writeEvent writes one server-sent event with data encoded as JSON.
+func writeEvent(w io.Writer, name string, data any) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("Error encoding '%s' event: %v", name, err)
+		return
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, payload)
+}
+
+// handleModels relays the provider's model list, so clients can see what
+// the configured key gives access to.
+func (s *Server) handleModels(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		httpError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+	client, err := api.HTTPClient(s.settings)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	req, err := api.NewModelListRequest(r.Context(), s.provider)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		if !errors.Is(err, context.Canceled) {
+			httpError(w, http.StatusBadGateway, "fetching models: "+err.Error())
+		}
+		return
+	}
+	defer res.Body.Close()
+	w.Header().Set("Content-Type", res.Header.Get("Content-Type"))
+	w.WriteHeader(res.StatusCode)
+	io.Copy(w, res.Body)
+}
+
+// httpError replies with status and a JSON {"error": message} body.
+func httpError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/henryhwang/chatbot/chat"
+	"github.com/henryhwang/chatbot/internal/types"
+)
+
+// providerServer is a fake OpenAI-compatible provider answering "ok" and
+// recording how many messages each request carried.
+type providerServer struct {
+	mu       sync.Mutex
+	messages []int
+}
+
+func (p *providerServer) start(t *testing.T) types.ModelProvider {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload types.OpenAIRequest
+		json.NewDecoder(r.Body).Decode(&payload)
+		p.mu.Lock()
+		p.messages = append(p.messages, len(payload.Messages))
+		p.mu.Unlock()
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"ok\"}}]}\n\ndata: [DONE]\n\n")
+	}))
+	t.Cleanup(srv.Close)
+	return types.ModelProvider{UrlBase: srv.URL, APIs: map[string]string{"chat": "/chat"}, Model: "m", Format: "openai"}
+}
+
+// post sends prompt to /chat in session id ("" for a new one), returning the
+// session id the reply names.
+func post(t *testing.T, url, id, prompt string) string {
+	t.Helper()
+	body, _ := json.Marshal(chatRequest{SessionID: id, Prompt: prompt})
+	res, err := http.Post(url+"/chat", "application/json", strings.NewReader(string(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("status %d", res.StatusCode)
+	}
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+			var session struct {
+				SessionID string `json:"session_id"`
+			}
+			if json.Unmarshal([]byte(data), &session) == nil && session.SessionID != "" {
+				io.Copy(io.Discard, res.Body) // Let the turn finish
+				return session.SessionID
+			}
+		}
+	}
+	t.Fatal("no session event")
+	return ""
+}
+
+func TestSessionExpiry(t *testing.T) {
+	var provider providerServer
+	srv := New(provider.start(t), types.Settings{}, func() *chat.Conversation { return chat.NewConversation("", 10000) })
+	httpSrv := httptest.NewServer(srv.Handler())
+	defer httpSrv.Close()
+
+	id := post(t, httpSrv.URL, "", "first")
+	if again := post(t, httpSrv.URL, id, "second"); again != id {
+		t.Fatalf("session %q continued as %q", id, again)
+	}
+
+	srv.mu.Lock()
+	srv.idleTimeout = 10 * time.Millisecond
+	srv.mu.Unlock()
+	time.Sleep(20 * time.Millisecond)
+	renewed := post(t, httpSrv.URL, id, "third")
+	if renewed == id {
+		t.Error("expired session was continued")
+	}
+
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+	// The second turn carries the first; the third starts afresh
+	if want := []int{1, 3, 1}; !slices.Equal(provider.messages, want) {
+		t.Errorf("requests carried %v messages, want %v", provider.messages, want)
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if _, ok := srv.sessions[id]; ok || len(srv.sessions) != 1 {
+		t.Errorf("%d session(s) held, want only the new one", len(srv.sessions))
+	}
+}
+
+func TestUnknownSessionStartsNew(t *testing.T) {
+	var provider providerServer
+	srv := New(provider.start(t), types.Settings{}, func() *chat.Conversation { return chat.NewConversation("", 10000) })
+	httpSrv := httptest.NewServer(srv.Handler())
+	defer httpSrv.Close()
+
+	if id := post(t, httpSrv.URL, "made-up", "hi"); id == "made-up" || id == "" {
+		t.Errorf("got session %q, want a new server-chosen id", id)
+	}
+}
@@ -0,0 +1,124 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/henryhwang/chatbot/internal/types"
+)
+
+// --- Tool Registry ---
+
+// Handler runs a tool with the model's JSON-encoded arguments and returns the
+// result to send back to the model.
+type Handler func(ctx context.Context, arguments json.RawMessage) (string, error)
+
+// Tool is a local function the model may call.
+type Tool struct {
+	Name        string          // Function name the model uses (letters, digits, _ and -)
+	Description string          // Tells the model when to use the tool
+	Parameters  json.RawMessage // JSON schema of the arguments object
+	Handler     Handler
+}
+
+// Registry holds the tools offered to the model, in registration order.
+type Registry struct {
+	tools map[string]Tool
+	order []string
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{tools: make(map[string]Tool)}
+}
+
+var validName = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// Register adds a tool. The name must be unique and valid, the schema must be
+// a JSON object and a handler is required.
+func (r *Registry) Register(tool Tool) error {
+	if !validName.MatchString(tool.Name) {
+		return fmt.Errorf("invalid tool name '%s'", tool.Name)
+	}
+	if _, exists := r.tools[tool.Name]; exists {
+		return fmt.Errorf("tool '%s' is already registered", tool.Name)
+	}
+	if tool.Handler == nil {
+		return fmt.Errorf("tool '%s' has no handler", tool.Name)
+	}
+	var schema map[string]interface{}
+	if err := json.Unmarshal(tool.Parameters, &schema); err != nil {
+		return fmt.Errorf("tool '%s' parameters must be a JSON schema object: %w", tool.Name, err)
+	}
+	r.tools[tool.Name] = tool
+	r.order = append(r.order, tool.Name)
+	return nil
+}
+
+// Len returns the number of registered tools.
+func (r *Registry) Len() int {
+	return len(r.order)
+}
+
+// Definitions describes the registered tools for an API request.
+func (r *Registry) Definitions() []types.Tool {
+	definitions := make([]types.Tool, 0, len(r.order))
+	for _, name := range r.order {
+		tool := r.tools[name]
+		definitions = append(definitions, types.Tool{
+			Type: "function",
+			Function: types.ToolFunction{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.Parameters,
+			},
+		})
+	}
+	return definitions
+}
+
+// Call runs the named tool. Empty arguments are passed as "{}".
+func (r *Registry) Call(ctx context.Context, name, arguments string) (string, error) {
+	tool, ok := r.tools[name]
+	if !ok {
+		return "", fmt.Errorf("unknown tool '%s'", name)
+	}
+	if arguments == "" {
+		arguments = "{}"
+	}
+	if !json.Valid([]byte(arguments)) {
+		return "", fmt.Errorf("arguments for '%s' are not valid JSON", name)
+	}
+	return tool.Handler(ctx, json.RawMessage(arguments))
+}
+
+// --- Built-in Tools ---
+
+// CurrentTime reports the local date and time, optionally in a given IANA time zone.
+func CurrentTime() Tool {
+	return Tool{
+		Name:        "get_current_time",
+		Description: "Get the current date and time, optionally in a specific IANA time zone (e.g. Europe/Paris).",
+		Parameters:  json.RawMessage(`{"type":"object","properties":{"timezone":{"type":"string","description":"IANA time zone name; defaults to local time"}}}`),
+		Handler: func(ctx context.Context, arguments json.RawMessage) (string, error) {
+			var args struct {
+				Timezone string `json:"timezone"`
+			}
+			if err := json.Unmarshal(arguments, &args); err != nil {
+				return "", err
+			}
+			now := time.Now()
+			if args.Timezone != "" {
+				location, err := time.LoadLocation(args.Timezone)
+				if err != nil {
+					return "", fmt.Errorf("unknown time zone '%s'", args.Timezone)
+				}
+				now = now.In(location)
+			}
+			return now.Format(time.RFC1123), nil
+		},
+	}
+}
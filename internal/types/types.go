@@ -1,6 +1,9 @@
 package types
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 // --- Configuration and Provider ---
 
@@ -10,15 +13,222 @@ type ModelProvider struct {
 	APIKey   string
 	APIs     map[string]string
 	Model    string
+	Format   string // API format: "openai" (default), "anthropic", "ollama" or "azure"
+
+	Deployment string // Azure deployment addressed instead of the model (DEPLOYMENT, default MODEL)
+	APIVersion string // Azure api-version query parameter (API_VERSION)
+
+	OrgID     string // Optional OpenAI-Organization header, for billing separation (ORG_ID)
+	ProjectID string // Optional OpenAI-Project header (PROJECT_ID)
+
+	ExtraHeaders map[string]string // Additional headers for gateways, e.g. "api-version" (EXTRA_HEADERS); a "!" name prefix replaces a built-in header
+
+	RoleContentPrefix map[string]string // Optional per-role marker prepended to outgoing content (e.g. "user" -> "### Instruction:")
+}
+
+// MaskedKey returns the API key in a form safe to print or log. Only keys
+// longer than 8 characters reveal their last 4; shorter ones are fully masked.
+func (p ModelProvider) MaskedKey() string {
+	const mask = "********"
+	switch {
+	case p.APIKey == "":
+		return "(not set)"
+	case len(p.APIKey) <= 8:
+		return mask
+	default:
+		return mask + p.APIKey[len(p.APIKey)-4:]
+	}
+}
+
+// RuntimeState holds the mutable session state shared between the REPL and
+// the command layer, so changes made by commands (e.g. switching provider)
+// apply to subsequent requests.
+type RuntimeState struct {
+	ProviderName string                   // Name of the active provider
+	Provider     ModelProvider            // Active provider used for requests
+	Providers    map[string]ModelProvider // All configured providers, by name
+	Settings     Settings
+	ModelLists   map[string]ModelList // Cached /list results, by provider name
+	Aliases      map[string]string    // Prompt shortcuts defined with /alias, by name
+
+	SystemPromptTemplate string            // System prompt before {{.Name}} placeholders are filled
+	PromptVars           map[string]string // Values for the placeholders (PROMPT_VAR_<Name> or /vars)
+
+	Branches      map[string]Branch // Conversation snapshots saved with /branch, by name
+	CurrentBranch string            // Branch last saved or checked out ("" if none)
+}
+
+// Branch is a deep copy of the conversation saved by /branch, so /checkout
+// can return to it however the conversation has moved on since.
+type Branch struct {
+	SystemPrompt         *Message  // nil if there was none; its timestamp is kept
+	SystemPromptTemplate string    // The template the prompt was rendered from, if any
+	History              []Message // The full history, timestamps included
+	Created              time.Time
+}
+
+// Settings holds optional application behaviour toggles read from the environment.
+type Settings struct {
+	DefaultMaxTokens     int           // Context token budget for models missing from the known-limits table
+	TruncationStrategy   string        // Context strategy: "simple", "turn-window", "relevance" or "summarize"
+	ContextTurns         int           // Number of turns kept by the turn-window strategy
+	SystemPromptInBudget bool          // Count the system prompt against the token budget in the simple strategy (SYSTEM_PROMPT_IN_BUDGET)
+	MaxHistoryMessages   int           // Cap on stored messages; the oldest are pruned beyond it (0 = unbounded)
+	ContextWarnRatio     float64       // Warn once the context reaches this fraction of the token budget (CONTEXT_WARN_RATIO; 0 = never)
+	RequestTimeout       time.Duration // Limit on waiting for the response headers of a request; streaming may run longer
+	ConnectTimeout       time.Duration // Limit on establishing a connection to the provider (0 = none)
+	StreamIdleTimeout    time.Duration // Abort a stream that sends no data for this long (0 = never)
+	MaxRetries           int           // Retries for transient API failures (429, 5xx, network errors)
+	RateLimitRPS         float64       // Most requests sent per second, waiting as needed (0 = unlimited)
+	StreamUsage          bool          // Request token usage in the final stream chunk (stream_options.include_usage)
+	StreamMaxLineBytes   int           // Longest single line accepted in a streamed response
+	StreamPayloadJoins   int           // Payloads an undecodable stream payload may be joined with before it is dropped
+	PromptShowTokens     bool          // Show "[used/budget]" token estimate in the input prompt
+	Quiet                bool          // Suppress the "Bot:" prefix and reasoning output (set by -q)
+	JSONOutput           bool          // Emit one JSON object per turn instead of streamed text (set by -json)
+	RenderMarkdown       bool          // Highlight fenced code blocks with ANSI colours when stdout is a terminal
+	Wrap                 bool          // Word-wrap responses to the terminal width, except in code blocks (WRAP)
+	BotPrefix            string        // Shown before each response (BOT_PREFIX, default "Bot: ")
+	UserPrefix           string        // Input prompt (USER_PREFIX, default "You: ")
+	ReasoningPrefix      string        // Shown before reasoning output (REASONING_PREFIX, default "Reasoning: ")
+	ShowReasoning        string        // Reasoning display: "full", "collapsed" (a token count line) or "hidden" (SHOW_REASONING)
+	Color                bool          // Colour the prefixes and reasoning when stdout is a terminal
+	OutputFilterCmd      string        // Shell command each completed response is piped through for display
+	OutputFilterTimeout  time.Duration // Max time the output filter may run before falling back
+
+	SystemReminderInterval int    // Reinject the system prompt every N user turns (0 disables)
+	SystemReminderText     string // Optional short reminder used instead of the full system prompt
+
+	TranscriptFile string // Every completed turn is appended here; "{date}" in the path rotates it daily
+
+	RecordSession string // File to record redacted HTTP exchanges to, for bug reports
+	ReplaySession string // File to replay recorded HTTP exchanges from instead of the network
+
+	ProxyURL string // Proxy for all provider requests (PROXY_URL); when empty, HTTP_PROXY/HTTPS_PROXY/NO_PROXY apply
+
+	UserAgent string // User-Agent header for provider requests (USER_AGENT); empty means "chatbot/<version>"
+
+	ServeAddr string // Listen address of "chatbot serve" (SERVE_ADDR, default 127.0.0.1:8080; -addr overrides)
+
+	DropEmptyAssistant bool   // Omit empty assistant messages (e.g. from failed turns) from API payloads
+	OnCancel           string // "rollback" removes the dangling user message of a cancelled turn; "keep" leaves it for retry
+
+	KeepPartialResponse bool // Keep the content streamed before Ctrl-C as the assistant's reply (overrides OnCancel)
+	KeepReasoning       bool // Store streamed reasoning with the assistant's reply, for /save and /export
+
+	EnableTools bool // Offer the built-in tools (e.g. current time) to OpenAI-format models (TOOLS)
+
+	ExpandFileRefs    bool // Inline the contents of @path references in user messages (EXPAND_FILE_REFS, off by default)
+	StoreExpandedRefs bool // Store the expanded message in history instead of the raw @path text (FILE_REFS_STORE=expanded)
+
+	Debug bool // Log full request bodies and raw response lines to stderr (DEBUG or -debug)
+
+	PersistSession bool   // Restore the conversation from SessionFile at startup and save it on exit
+	SessionFile    string // Where the persisted conversation lives (default ~/.chatbot/session.json)
+
+	AliasesFile string // Where /alias definitions are kept across sessions (default ~/.chatbot/aliases.json)
+
+	Sampling SamplingParams // Optional temperature/top_p/max_tokens/presence_penalty sent with chat requests
+
+	Hooks []Hook // Run around every request, in order (registered by programs using package chat)
+}
+
+// Hook runs custom logic around each request sent to the provider, e.g.
+// logging, redacting personal data or collecting metrics. A turn that runs
+// tools sends several requests, and the hooks run around each of them.
+type Hook interface {
+	// BeforeRequest is given the messages about to be sent and returns the
+	// ones to send instead (or the same). They are the request's own copies,
+	// so changing a message's content doesn't change the history. An error
+	// ends the turn before anything is sent.
+	BeforeRequest(messages []Message) ([]Message, error)
+
+	// AfterResponse is told how the request went: the reply and the token
+	// usage (nil if not reported), or the error that ended it, with whatever
+	// content arrived before. It is called once for every BeforeRequest,
+	// including when the request or its stream fails.
+	AfterResponse(content string, usage *UsageInfo, err error)
+}
+
+// HookFuncs is a Hook made of functions; either may be nil.
+type HookFuncs struct {
+	Before func(messages []Message) ([]Message, error)
+	After  func(content string, usage *UsageInfo, err error)
+}
+
+func (h HookFuncs) BeforeRequest(messages []Message) ([]Message, error) {
+	if h.Before == nil {
+		return messages, nil
+	}
+	return h.Before(messages)
+}
+
+func (h HookFuncs) AfterResponse(content string, usage *UsageInfo, err error) {
+	if h.After != nil {
+		h.After(content, usage, err)
+	}
 }
 
 // --- API Request/Response Structures ---
 
 // Request structure for the chat API (used for both streaming and non-streaming)
 type OpenAIRequest struct {
-	Model    string    `json:"model"`
-	Messages []Message `json:"messages"`
-	Stream   bool      `json:"stream,omitempty"` // Set to true for streaming
+	Model         string         `json:"model"`
+	Messages      []Message      `json:"messages"`
+	Stream        bool           `json:"stream,omitempty"`         // Set to true for streaming
+	StreamOptions *StreamOptions `json:"stream_options,omitempty"` // Optional streaming behaviour (e.g. include usage)
+	Tools         []Tool         `json:"tools,omitempty"`          // Functions the model may call
+	SamplingParams
+}
+
+// Optional generation parameters; nil fields are omitted so the API default applies
+type SamplingParams struct {
+	Temperature     *float64 `json:"temperature,omitempty"`
+	TopP            *float64 `json:"top_p,omitempty"`
+	MaxTokens       *int     `json:"max_tokens,omitempty"` // Completion length limit (not the context budget)
+	PresencePenalty *float64 `json:"presence_penalty,omitempty"`
+	Stop            []string `json:"stop,omitempty"` // Sequences that end generation; nil or empty is omitted
+	Seed            *int     `json:"seed,omitempty"` // For reproducible outputs; a pointer so 0 is distinct from unset
+	N               *int     `json:"n,omitempty"`    // Number of alternative answers (OpenAI format only)
+
+	ResponseFormat *ResponseFormat `json:"response_format,omitempty"` // Constrain the reply to JSON
+}
+
+// ResponseFormat asks for the reply as JSON: any JSON object ("json_object"),
+// or one matching a schema ("json_schema").
+type ResponseFormat struct {
+	Type       string      `json:"type"`
+	JSONSchema *JSONSchema `json:"json_schema,omitempty"` // Set for "json_schema" only
+}
+
+// JSONSchema is a named schema the reply must follow.
+type JSONSchema struct {
+	Name   string          `json:"name"`
+	Schema json.RawMessage `json:"schema"`
+	Strict bool            `json:"strict,omitempty"` // Follow the schema exactly rather than as guidance
+}
+
+// Options controlling what a streaming response includes
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"` // Ask for a final chunk carrying token usage
+}
+
+// Models available from a provider (GET /v1/models); Data is kept sorted by ID
+type ModelList struct {
+	Data []ModelInfo `json:"data"`
+}
+
+// A single entry in a provider's model list
+type ModelInfo struct {
+	ID      string `json:"id"`
+	OwnedBy string `json:"owned_by,omitempty"`
+}
+
+// Token usage reported by the API for a single request
+type UsageInfo struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
 }
 
 // Standard message structure, now including a timestamp
@@ -26,6 +236,12 @@ type Message struct {
 	Role      string    `json:"role"`
 	Content   string    `json:"content"`
 	Timestamp time.Time `json:"-"` // Exclude from API JSON, internal use only
+	Reasoning string    `json:"-"` // Reasoning that preceded an assistant reply (KEEP_REASONING); never sent to the API
+
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`   // Functions requested by an assistant message
+	ToolCallID string     `json:"tool_call_id,omitempty"` // For role "tool": the call this message answers
+
+	TokenCount int `json:"-"` // Cached token estimate (0 = not yet computed), internal use only
 }
 
 // --- Structs specifically for STREAMING response handling ---
@@ -33,32 +249,132 @@ type Message struct {
 // Overall structure of a single SSE data line payload
 type OpenAIStreamResponse struct {
 	Choices []StreamChoice `json:"choices"`
-	// Usage *UsageInfo `json:"usage,omitempty"` // Optional: If API provides usage at the end
+	Usage   *UsageInfo     `json:"usage,omitempty"` // Usually only present in the final chunk
+
+	SystemFingerprint string `json:"system_fingerprint,omitempty"` // Backend configuration; changes can explain differing outputs for one seed
 }
 
 // Structure of a choice within the stream
 type StreamChoice struct {
 	Delta        Delta   `json:"delta"`                   // The actual changes in this chunk
 	FinishReason *string `json:"finish_reason,omitempty"` // e.g., "stop", "length", "tool_calls"
-	// Index *int `json:"index,omitempty"` // Usually 0 for chat completions
+	Index        int     `json:"index"`                   // Which answer the delta belongs to; 0 unless n > 1
 }
 
 // Structure of the delta (the changes) in a stream chunk
 type Delta struct {
-	Role      string `json:"role,omitempty"`              // Assistant's role, usually in the first chunk
-	Content   string `json:"content,omitempty"`           // Final answer content chunk
-	Reasoning string `json:"reasoning_content,omitempty"` // <<< DeepSeek specific reasoning/thinking chunk
-	// ToolCalls []*ToolCall `json:"tool_calls,omitempty"` // Standard tool call mechanism (if supported/needed)
-}
-
-// --- Optional: Standard Tool Call Structures (If needed in the future) ---
-// type ToolCall struct {
-//  Index    *int             `json:"index,omitempty"`
-//  ID       string           `json:"id,omitempty"`
-//  Type     string           `json:"type,omitempty"` // "function"
-//  Function ToolCallFunction `json:"function,omitempty"`
-// }
-// type ToolCallFunction struct {
-//  Name      string `json:"name,omitempty"`
-//  Arguments string `json:"arguments,omitempty"`
-// }
+	Role      string     `json:"role,omitempty"`              // Assistant's role, usually in the first chunk
+	Content   string     `json:"content,omitempty"`           // Final answer content chunk
+	Reasoning string     `json:"reasoning_content,omitempty"` // <<< DeepSeek specific reasoning/thinking chunk
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`        // Tool call fragments, matched up by Index
+}
+
+// --- Tool Call Structures ---
+
+// A function call requested by the model. In a stream, a call arrives as
+// fragments sharing an Index: the first has the ID and name, later ones
+// continue the Arguments string.
+type ToolCall struct {
+	Index    *int             `json:"index,omitempty"` // Stream fragments only
+	ID       string           `json:"id,omitempty"`
+	Type     string           `json:"type,omitempty"` // "function"
+	Function ToolCallFunction `json:"function"`
+}
+
+// The function name and JSON-encoded arguments of a tool call
+type ToolCallFunction struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments"`
+}
+
+// A tool offered to the model in a request
+type Tool struct {
+	Type     string       `json:"type"` // "function"
+	Function ToolFunction `json:"function"`
+}
+
+// Description of a callable function; Parameters is a JSON schema object
+type ToolFunction struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters"`
+}
+
+// --- Anthropic Messages API Structures ---
+
+// Request structure for the Anthropic Messages API
+type AnthropicRequest struct {
+	Model       string             `json:"model"`
+	System      string             `json:"system,omitempty"` // System prompt is a top-level field, not a message
+	Messages    []AnthropicMessage `json:"messages"`
+	MaxTokens   int                `json:"max_tokens"` // Required by the API
+	Stream      bool               `json:"stream,omitempty"`
+	Temperature *float64           `json:"temperature,omitempty"`
+	TopP        *float64           `json:"top_p,omitempty"`
+
+	StopSequences []string `json:"stop_sequences,omitempty"`
+}
+
+// A user or assistant turn in an Anthropic request
+type AnthropicMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// A single event of an Anthropic stream; which fields are set depends on Type
+type AnthropicStreamEvent struct {
+	Type    string `json:"type"` // message_start, content_block_delta, message_delta, message_stop, error...
+	Message *struct {
+		Usage AnthropicUsage `json:"usage"`
+	} `json:"message,omitempty"` // message_start
+	Delta *struct {
+		Type     string `json:"type"`               // text_delta or thinking_delta
+		Text     string `json:"text,omitempty"`     // Answer content
+		Thinking string `json:"thinking,omitempty"` // Extended thinking content
+	} `json:"delta,omitempty"` // content_block_delta (message_delta deltas carry only stop_reason)
+	Usage *AnthropicUsage `json:"usage,omitempty"` // message_delta
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"` // error
+}
+
+// Token usage as reported by Anthropic
+type AnthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// --- Ollama Native API Structures ---
+
+// Request structure for Ollama's native /api/chat endpoint
+type OllamaRequest struct {
+	Model    string          `json:"model"`
+	Messages []Message       `json:"messages"`
+	Stream   bool            `json:"stream"`
+	Options  *OllamaOptions  `json:"options,omitempty"`
+	Format   json.RawMessage `json:"format,omitempty"` // "json", or a JSON schema the reply must follow
+}
+
+// Generation options for Ollama; nil fields use the model's defaults
+type OllamaOptions struct {
+	Temperature     *float64 `json:"temperature,omitempty"`
+	TopP            *float64 `json:"top_p,omitempty"`
+	NumPredict      *int     `json:"num_predict,omitempty"` // Completion length limit
+	PresencePenalty *float64 `json:"presence_penalty,omitempty"`
+	Stop            []string `json:"stop,omitempty"`
+	Seed            *int     `json:"seed,omitempty"`
+}
+
+// A single NDJSON line of an Ollama stream; the last has Done set and carries token counts
+type OllamaStreamChunk struct {
+	Message *struct {
+		Role     string `json:"role"`
+		Content  string `json:"content"`
+		Thinking string `json:"thinking,omitempty"` // Reasoning content of thinking models
+	} `json:"message,omitempty"`
+	Done            bool   `json:"done"`
+	PromptEvalCount int    `json:"prompt_eval_count,omitempty"`
+	EvalCount       int    `json:"eval_count,omitempty"`
+	Error           string `json:"error,omitempty"`
+}
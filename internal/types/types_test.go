@@ -0,0 +1,21 @@
+package types
+
+import "testing"
+
+func TestMaskedKey(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"", "(not set)"},
+		{"abc", "********"},
+		{"12345678", "********"},
+		{"123456789", "********6789"},
+		{"sk-proj-abcdefghijklmnopqrstuvwxyz", "********wxyz"},
+	}
+	for _, tt := range tests {
+		if got := (ModelProvider{APIKey: tt.key}).MaskedKey(); got != tt.want {
+			t.Errorf("MaskedKey() for %q = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+}
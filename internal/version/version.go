@@ -0,0 +1,95 @@
+// Package version reports which build of the chatbot is running.
+package version
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+// Build information, set at build time, e.g.:
+//
+//	go build -ldflags "-X github.com/henryhwang/chatbot/internal/version.Version=1.4.0
+//	  -X github.com/henryhwang/chatbot/internal/version.Commit=$(git rev-parse --short HEAD)
+//	  -X github.com/henryhwang/chatbot/internal/version.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)" ./cmd/chatbot
+//
+// Whatever is left empty is taken from the module and VCS information the Go
+// toolchain embeds, where available.
+var (
+	Version   string
+	Commit    string
+	BuildDate string
+)
+
+// Info describes the running build.
+type Info struct {
+	Version   string // Release version, "dev" if unknown
+	Commit    string // VCS revision, "" if unknown
+	Modified  bool   // The build had uncommitted changes (from VCS information only)
+	BuildDate string // Build or commit time, "" if unknown
+	GoVersion string // Toolchain that built the binary, e.g. "go1.23.0"
+	Platform  string // e.g. "linux/amd64"
+	Module    string // Main module path and version, e.g. "github.com/henryhwang/chatbot (devel)"
+}
+
+// Get returns the build information, preferring values set with -ldflags.
+func Get() Info {
+	info := Info{
+		Version:   Version,
+		Commit:    Commit,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+	if build, ok := debug.ReadBuildInfo(); ok {
+		info.Module = strings.TrimSpace(build.Main.Path + " " + build.Main.Version)
+		if info.Version == "" && build.Main.Version != "" && build.Main.Version != "(devel)" {
+			info.Version = build.Main.Version // Installed with go install module@version
+		}
+		for _, setting := range build.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = setting.Value
+				}
+			case "vcs.time":
+				if info.BuildDate == "" {
+					info.BuildDate = setting.Value
+				}
+			case "vcs.modified":
+				info.Modified = setting.Value == "true" && Commit == "" // Describes the VCS revision only
+			}
+		}
+	}
+	if info.Version == "" {
+		info.Version = "dev"
+	}
+	return info
+}
+
+// Short returns the version alone, e.g. "1.4.0" or "dev".
+func Short() string {
+	return Get().Version
+}
+
+// String renders the information over several lines, first "chatbot <version>".
+func (i Info) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "chatbot %s", i.Version)
+	if i.Commit != "" {
+		commit := i.Commit
+		if i.Modified {
+			commit += " (modified)"
+		}
+		fmt.Fprintf(&b, "\ncommit: %s", commit)
+	}
+	if i.BuildDate != "" {
+		fmt.Fprintf(&b, "\ndate:   %s", i.BuildDate)
+	}
+	fmt.Fprintf(&b, "\ngo:     %s %s", i.GoVersion, i.Platform)
+	if i.Module != "" {
+		fmt.Fprintf(&b, "\nmodule: %s", i.Module)
+	}
+	return b.String()
+}